@@ -7,7 +7,6 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"strconv"
 	"strings"
@@ -66,39 +65,15 @@ func utilHandler(util internal.Util) http.Handler {
 		a := &internal.UtilArgs{Output: out}
 
 		upload := func() error {
-			var in io.Reader
-			contentType := r.Header.Get("Content-Type")
-			if strings.HasPrefix(contentType, "multipart/form-data") {
-				if err := r.ParseMultipartForm(maxPostSize); err != nil {
-					return fmt.Errorf("upload too big: %w", err)
-				}
-
-				var first *multipart.FileHeader
-				for _, files := range r.MultipartForm.File {
-					for _, file := range files {
-						if first != nil {
-							return errors.New("only one file is expected to be uploaded")
-						}
-						first = file
-					}
-				}
-
-				file, err := first.Open()
-				if err != nil {
-					return fmt.Errorf("failed to open file: %w", err)
-				}
-				defer file.Close()
-
-				in = file
-			} else {
-				in = io.LimitReader(r.Body, maxPostSize)
-			}
-
-			inBuf, err := ioutil.ReadAll(in)
+			inputs, names, err := readInputs(w, r)
 			if err != nil {
-				return fmt.Errorf("upload error: %w", err)
+				return err
+			}
+			if err := util.CheckInputs(len(inputs)); err != nil {
+				return err
 			}
-			a.Inputs = append(a.Inputs, inBuf)
+			a.Inputs = inputs
+			a.InputNames = names
 
 			a.Flags = make(map[string]interface{})
 			for name, flag := range util.Flags {
@@ -123,6 +98,20 @@ func utilHandler(util internal.Util) http.Handler {
 					a.Flags[name] = val
 				case string:
 					a.Flags[name] = qVal
+				case int:
+					val, err := strconv.Atoi(qVal)
+					if err != nil {
+						return fmt.Errorf("bad query param %s: %w", name, err)
+					}
+					a.Flags[name] = val
+				case float64:
+					val, err := strconv.ParseFloat(qVal, 64)
+					if err != nil {
+						return fmt.Errorf("bad query param %s: %w", name, err)
+					}
+					a.Flags[name] = val
+				case []string:
+					a.Flags[name] = r.URL.Query()[name]
 				}
 			}
 			return nil
@@ -145,11 +134,56 @@ func utilHandler(util internal.Util) http.Handler {
 		}
 
 		respondSpan, _ := tracer.StartSpanFromContext(r.Context(), "respond")
+		if util.ContentType != "" {
+			w.Header().Set("Content-Type", util.ContentType)
+		}
 		_, err = io.Copy(w, out)
 		respondSpan.Finish(tracer.WithError(err))
 	})
 }
 
+// readInputs returns the files uploaded via a multipart/form-data request and
+// their file names in the order they appear in the request. Any other request
+// is treated as a single file upload without a name.
+func readInputs(w http.ResponseWriter, r *http.Request) ([][]byte, []string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPostSize)
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "multipart/form-data") {
+		inBuf, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("upload error: %w", err)
+		}
+		return [][]byte{inBuf}, []string{""}, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("upload error: %w", err)
+	}
+	var (
+		inputs [][]byte
+		names  []string
+	)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return inputs, names, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("upload error: %w", err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		inBuf, err := ioutil.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("upload error: %w", err)
+		}
+		inputs = append(inputs, inBuf)
+		names = append(names, part.FileName())
+	}
+}
+
 func addSpanTags(r *http.Request) tracer.Span {
 	span, _ := tracer.SpanFromContext(r.Context())
 	span.SetTag("http.full_url", r.URL.String())
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUtilHandlerInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		variadic bool
+		files    []string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "exactly two",
+			files:    []string{"a", "b"},
+			wantCode: http.StatusOK,
+			want:     "a.pprof:a b.pprof:b",
+		},
+		{
+			name:     "exactly two too few",
+			files:    []string{"a"},
+			wantCode: http.StatusBadRequest,
+			want:     "error: expected 2 input files, got 1",
+		},
+		{
+			name:     "exactly two too many",
+			files:    []string{"a", "b", "c"},
+			wantCode: http.StatusBadRequest,
+			want:     "error: expected 2 input files, got 3",
+		},
+		{
+			name:     "two or more",
+			variadic: true,
+			files:    []string{"a", "b", "c"},
+			wantCode: http.StatusOK,
+			want:     "a.pprof:a b.pprof:b c.pprof:c",
+		},
+		{
+			name:     "two or more too few",
+			variadic: true,
+			files:    []string{"a"},
+			wantCode: http.StatusBadRequest,
+			want:     "error: expected at least 2 input files, got 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			require.NoError(t, mw.WriteField("comment", "ignored"))
+			for i, file := range tt.files {
+				fw, err := mw.CreateFormFile(fmt.Sprintf("file%d", i+1), file+".pprof")
+				require.NoError(t, err)
+				_, err = fw.Write([]byte(file))
+				require.NoError(t, err)
+			}
+			require.NoError(t, mw.Close())
+
+			req := httptest.NewRequest("POST", "/inputs", body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			utilHandler(inputsUtil(tt.variadic)).ServeHTTP(rec, req)
+			require.Equal(t, tt.wantCode, rec.Code)
+			require.Equal(t, tt.want, strings.TrimSpace(rec.Body.String()))
+		})
+	}
+
+	t.Run("single file body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/inputs", strings.NewReader("a"))
+		rec := httptest.NewRecorder()
+		utilHandler(inputsUtil(false)).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Equal(t, "error: expected 2 input files, got 1", strings.TrimSpace(rec.Body.String()))
+	})
+}
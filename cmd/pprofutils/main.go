@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/felixge/pprofutils/v2/internal"
@@ -127,6 +128,16 @@ func ffCommand(util internal.Util) *ffcli.Command {
 		case string:
 			fs.StringVar(&vt, name, vt, bf.Usage)
 			flags[name] = &vt
+		case int:
+			fs.IntVar(&vt, name, vt, bf.Usage)
+			flags[name] = &vt
+		case float64:
+			fs.Float64Var(&vt, name, vt, bf.Usage)
+			flags[name] = &vt
+		case []string:
+			sf := &stringsFlag{values: vt}
+			fs.Var(sf, name, bf.Usage)
+			flags[name] = sf
 		}
 	}
 
@@ -137,19 +148,22 @@ func ffCommand(util internal.Util) *ffcli.Command {
 		LongHelp:   util.LongHelp,
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			in, out, err := openInputOutput(args)
+			ins, out, err := openInputsOutput(args, util)
 			if err != nil {
 				return err
 			}
-			defer in.Close()
 			defer out.Close()
 
 			a := &internal.UtilArgs{}
-			inBuf, err := ioutil.ReadAll(in)
-			if err != nil {
-				return err
+			for _, in := range ins {
+				inBuf, err := ioutil.ReadAll(in)
+				in.Close()
+				if err != nil {
+					return err
+				}
+				a.Inputs = append(a.Inputs, inBuf)
+				a.InputNames = append(a.InputNames, in.name)
 			}
-			a.Inputs = append(a.Inputs, inBuf)
 			a.Output = out
 			a.Flags = make(map[string]interface{})
 			for k, v := range flags {
@@ -160,6 +174,12 @@ func ffCommand(util internal.Util) *ffcli.Command {
 					a.Flags[k] = *vt
 				case *string:
 					a.Flags[k] = *vt
+				case *int:
+					a.Flags[k] = *vt
+				case *float64:
+					a.Flags[k] = *vt
+				case *stringsFlag:
+					a.Flags[k] = vt.values
 				}
 			}
 
@@ -168,25 +188,71 @@ func ffCommand(util internal.Util) *ffcli.Command {
 	}
 }
 
-func openInputOutput(args []string) (io.ReadCloser, io.WriteCloser, error) {
-	inputPath := "-"
-	if len(args) >= 1 {
-		inputPath = args[0]
+// openInputsOutput opens the input files followed by the output file given in
+// args. If the util takes a single input, the input and output default to "-".
+// Utils with more than one input require all input paths to be given
+// explicitly. For utils with variadic inputs the last argument is the output
+// file if there are more arguments than required inputs.
+func openInputsOutput(args []string, util internal.Util) ([]namedInput, io.WriteCloser, error) {
+	n := util.NumInputs()
+	if util.VariadicInputs && len(args) > n {
+		n = len(args) - 1
 	}
-	in, err := openInput(inputPath)
-	if err != nil {
+	inputPaths := args
+	if len(inputPaths) > n {
+		inputPaths = inputPaths[:n]
+	}
+	if n == 1 && len(inputPaths) == 0 {
+		inputPaths = []string{"-"}
+	}
+	if len(args) > n+1 {
+		return nil, nil, fmt.Errorf("too many arguments: expected %d input files and an output file, got %d arguments", n, len(args))
+	}
+	if err := util.CheckInputs(len(inputPaths)); err != nil {
 		return nil, nil, err
 	}
 
+	var ins []namedInput
+	closeAll := func() {
+		for _, in := range ins {
+			in.Close()
+		}
+	}
+	for _, inputPath := range inputPaths {
+		in, err := openInput(inputPath)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		ins = append(ins, namedInput{ReadCloser: in, name: inputName(inputPath)})
+	}
+
 	outputPath := "-"
-	if len(args) >= 2 {
-		outputPath = args[1]
+	if len(args) > n {
+		outputPath = args[n]
 	}
 	out, err := openOutput(outputPath)
 	if err != nil {
+		closeAll()
 		return nil, nil, err
 	}
-	return in, out, nil
+	return ins, out, nil
+}
+
+// namedInput is an input file along with its name.
+type namedInput struct {
+	io.ReadCloser
+	name string
+}
+
+// inputName returns the input path as given, or an empty string for stdin.
+// The path is not shortened to its base name to keep inputs with the same
+// file name in different directories apart.
+func inputName(path string) string {
+	if path == "-" {
+		return ""
+	}
+	return path
 }
 
 func openInput(path string) (io.ReadCloser, error) {
@@ -203,6 +269,28 @@ func openOutput(path string) (io.WriteCloser, error) {
 	return os.Create(path)
 }
 
+// stringsFlag is a flag that can be given multiple times. The first value
+// replaces the default.
+type stringsFlag struct {
+	values []string
+	set    bool
+}
+
+func (s *stringsFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringsFlag) Set(val string) error {
+	if !s.set {
+		s.values, s.set = nil, true
+	}
+	s.values = append(s.values, val)
+	return nil
+}
+
 type nopWriteCloser struct {
 	io.Writer
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/felixge/pprofutils/v2/internal"
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFCommandInputs(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.pprof", "b.pprof", "c.pprof"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(strings.TrimSuffix(name, ".pprof")), 0644))
+		paths = append(paths, path)
+	}
+	outPath := filepath.Join(dir, "out")
+
+	tests := []struct {
+		name     string
+		variadic bool
+		args     []string
+		want     string
+		wantErr  string
+	}{
+		{
+			name: "exactly two",
+			args: []string{paths[0], paths[1], outPath},
+			want: paths[0] + ":a " + paths[1] + ":b",
+		},
+		{
+			name:    "exactly two too few",
+			args:    []string{paths[0]},
+			wantErr: "expected 2 input files, got 1",
+		},
+		{
+			name:    "exactly two too many",
+			args:    []string{paths[0], paths[1], paths[2], outPath},
+			wantErr: "too many arguments: expected 2 input files and an output file, got 4 arguments",
+		},
+		{
+			name:     "two or more",
+			variadic: true,
+			args:     []string{paths[0], paths[1], paths[2], outPath},
+			want:     paths[0] + ":a " + paths[1] + ":b " + paths[2] + ":c",
+		},
+		{
+			name:     "two or more too few",
+			variadic: true,
+			args:     []string{paths[0]},
+			wantErr:  "expected at least 2 input files, got 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(outPath)
+			cmd := ffCommand(inputsUtil(tt.variadic))
+			err := cmd.ParseAndRun(context.Background(), tt.args)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			got, err := os.ReadFile(outPath)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestFFCommandMergeSourceLabel(t *testing.T) {
+	dir := t.TempDir()
+	var args []string
+	for i, host := range []string{"host1", "host2"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, host), 0755))
+		path := filepath.Join(dir, host, "cpu.pprof")
+		prof, err := legacy.Text{}.Convert(strings.NewReader(fmt.Sprintf("main;foo %d", i+1)))
+		require.NoError(t, err)
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		require.NoError(t, prof.Write(f))
+		require.NoError(t, f.Close())
+		args = append(args, path)
+	}
+	outPath := filepath.Join(dir, "out.pprof")
+
+	var merge internal.Util
+	for _, u := range internal.Utils {
+		if u.Name == "merge" {
+			merge = u
+		}
+	}
+	cmd := ffCommand(merge)
+	require.NoError(t, cmd.ParseAndRun(context.Background(), append([]string{"-source_label=host"}, append(args, outPath)...)))
+
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	prof, err := profile.ParseData(data)
+	require.NoError(t, err)
+	got := map[string]int64{}
+	for _, s := range prof.Sample {
+		got[s.Label["host"][0]] += s.Value[0]
+	}
+	require.Equal(t, map[string]int64{args[0]: 1, args[1]: 2}, got)
+}
+
+// inputsUtil returns a util taking two inputs that writes the name and
+// content of each input to the output.
+func inputsUtil(variadic bool) internal.Util {
+	return internal.Util{
+		Name:           "inputs",
+		Inputs:         2,
+		VariadicInputs: variadic,
+		Execute: func(_ context.Context, a *internal.UtilArgs) error {
+			var parts []string
+			for i, in := range a.Inputs {
+				parts = append(parts, a.InputNames[i]+":"+string(in))
+			}
+			_, err := a.Output.Write([]byte(strings.Join(parts, " ")))
+			return err
+		},
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/peterbourgon/ff/v3 v3.1.0
 	github.com/stretchr/testify v1.8.4
 	github.com/wolfeidau/humanhash v1.1.0
+	google.golang.org/protobuf v1.30.0
 	gopkg.in/DataDog/dd-trace-go.v1 v1.62.0
 )
 
@@ -45,6 +46,5 @@ require (
 	golang.org/x/time v0.3.0 // indirect
 	golang.org/x/tools v0.12.1-0.20230815132531-74c255bcf846 // indirect
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,7 +1,7 @@
 package legacy
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/google/pprof/profile"
 )
@@ -22,40 +22,61 @@ type Delta struct {
 }
 
 // Delta computes the delta between all values b-a and returns them as a new
-// profile. Samples that end up with a delta of 0 are dropped. WARNING: Profile
-// a will be mutated by this function. You should pass a copy if that's
-// undesirable.
+// profile. Samples that end up with a delta of 0 are dropped. The time of the
+// delta is the time of b and its duration the time between a and b, or the
+// duration of b if the times are unknown. Neither a nor b are modified by this
+// function.
 func (d Delta) Convert(a, b *profile.Profile) (*profile.Profile, error) {
 	ratios := make([]float64, len(a.SampleType))
 
-	found := 0
-	for i, st := range a.SampleType {
-		// Empty c.SampleTypes means we calculate the delta for every st
-		if len(d.SampleTypes) == 0 {
+	// Empty d.SampleTypes means we calculate the delta for every st.
+	if len(d.SampleTypes) == 0 {
+		for i := range ratios {
 			ratios[i] = -1
-			continue
 		}
+	}
 
-		// Otherwise we only calcuate the delta for any st that is listed in
-		// c.SampleTypes. st's not listed in there will default to ratio 0, which
-		// means we delete them from pa, so only the pb values remain in the final
-		// profile.
-		for _, deltaSt := range d.SampleTypes {
+	// Otherwise we only calcuate the delta for any st that is listed in
+	// d.SampleTypes. st's not listed in there will default to ratio 0, which
+	// means we delete them from a, so only the b values remain in the final
+	// profile.
+	for _, deltaSt := range d.SampleTypes {
+		found := false
+		for i, st := range a.SampleType {
 			if deltaSt.Type == st.Type && deltaSt.Unit == st.Unit {
 				ratios[i] = -1
-				found++
+				found = true
 			}
 		}
-	}
-	if found != len(d.SampleTypes) {
-		return nil, errors.New("One or more sample type(s) was not found in the profile.")
+		if !found {
+			return nil, fmt.Errorf("sample type not found in profile: %s/%s", deltaSt.Type, deltaSt.Unit)
+		}
+		found = false
+		for _, st := range b.SampleType {
+			if deltaSt.Type == st.Type && deltaSt.Unit == st.Unit {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("sample type not found in new profile: %s/%s", deltaSt.Type, deltaSt.Unit)
+		}
 	}
 
-	a.ScaleN(ratios)
+	a = a.Copy()
+	if err := a.ScaleN(ratios); err != nil {
+		return nil, err
+	}
 
 	delta, err := profile.Merge([]*profile.Profile{a, b})
 	if err != nil {
 		return nil, err
 	}
+	// Merge sums up the durations and keeps the earliest time, but the delta
+	// only covers the interval between a and b.
+	delta.TimeNanos = b.TimeNanos
+	delta.DurationNanos = b.DurationNanos
+	if a.TimeNanos != 0 && b.TimeNanos > a.TimeNanos {
+		delta.DurationNanos = b.TimeNanos - a.TimeNanos
+	}
 	return delta, delta.CheckValid()
 }
@@ -83,7 +83,29 @@ main;foo;bar 0 6
 			var is = is.New(t)
 			deltaConfig := Delta{SampleTypes: []ValueType{{Type: "foo", Unit: "count"}}}
 			_, err := deltaConfig.Convert(profA, profB)
-			is.Equal("One or more sample type(s) was not found in the profile.", err.Error())
+			is.Equal("sample type not found in profile: foo/count", err.Error())
 		})
 	})
+
+	t.Run("inputs are not modified", func(t *testing.T) {
+		var (
+			is     = is.New(t)
+			aText  bytes.Buffer
+			profIn = strings.TrimSpace(`
+main;foo 5
+main;foobar 4
+`) + "\n"
+		)
+
+		profA, err := Text{}.Convert(strings.NewReader(profIn))
+		is.NoErr(err)
+		profB, err := Text{}.Convert(strings.NewReader(profIn))
+		is.NoErr(err)
+
+		_, err = Delta{}.Convert(profA, profB)
+		is.NoErr(err)
+
+		is.NoErr(Protobuf{}.Convert(profA, &aText))
+		is.Equal(aText.String(), profIn)
+	})
 }
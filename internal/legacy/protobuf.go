@@ -19,25 +19,42 @@ type Protobuf struct {
 	SampleTypes bool
 	// LineNumbers causes the text output to include line numbers for each frame
 	LineNumbers bool
+	// Extended causes the text output to use the extended folded format which
+	// includes the file name and line number of each frame as well as the
+	// labels of each sample. It implies SampleTypes and takes precedence over
+	// LineNumbers. See Text for a description of the format.
+	Extended bool
 }
 
 // Convert marshals the given protobuf profile into folded text format.
 func (p Protobuf) Convert(protobuf *profile.Profile, text io.Writer) error {
 	w := bufio.NewWriter(text)
-	if p.SampleTypes {
+	if p.SampleTypes || p.Extended {
 		var sampleTypes []string
 		for _, sampleType := range protobuf.SampleType {
 			sampleTypes = append(sampleTypes, sampleType.Type+"/"+sampleType.Unit)
 		}
+		if p.Extended {
+			sampleTypes = append(sampleTypes, extendedHeader)
+		}
 		w.WriteString(strings.Join(sampleTypes, " ") + "\n")
 	}
-	if err := protobuf.Aggregate(true, true, false, p.LineNumbers, false, false); err != nil {
+	if err := protobuf.Aggregate(true, true, p.Extended, p.LineNumbers || p.Extended, false, false); err != nil {
 		return err
 	}
 	protobuf = protobuf.Compact()
 	sort.Slice(protobuf.Sample, func(i, j int) bool {
 		return protobuf.Sample[i].Value[0] > protobuf.Sample[j].Value[0]
 	})
+
+	type foldedLine struct {
+		stack  string
+		values []int64
+	}
+	var (
+		lines   []*foldedLine
+		stackTo = map[string]*foldedLine{}
+	)
 	for _, sample := range protobuf.Sample {
 		var frames []string
 		for i := range sample.Location {
@@ -45,25 +62,102 @@ func (p Protobuf) Convert(protobuf *profile.Profile, text io.Writer) error {
 			for j := range loc.Line {
 				line := loc.Line[len(loc.Line)-j-1]
 				name := line.Function.Name
-				if p.LineNumbers {
+				if p.Extended {
+					name = formatExtendedFrame(line)
+				} else if p.LineNumbers {
 					name = name + ":" + strconv.FormatInt(line.Line, 10)
 				}
 				frames = append(frames, name)
 			}
 		}
+		fl := &foldedLine{stack: strings.Join(frames, ";"), values: sample.Value}
+		if !p.Extended {
+			lines = append(lines, fl)
+			continue
+		}
+
+		// The extended format has exactly one line per stack and label set so
+		// it can be converted back and forth without changing.
+		if labels := formatExtendedLabels(sample); labels != "" {
+			fl.stack = labels + " " + fl.stack
+		}
+		if prev, ok := stackTo[fl.stack]; ok {
+			for i, v := range fl.values {
+				prev.values[i] += v
+			}
+			continue
+		}
+		fl.values = append([]int64(nil), fl.values...)
+		stackTo[fl.stack] = fl
+		lines = append(lines, fl)
+	}
+	if p.Extended {
+		sort.Slice(lines, func(i, j int) bool {
+			if lines[i].values[0] != lines[j].values[0] {
+				return lines[i].values[0] > lines[j].values[0]
+			}
+			return lines[i].stack < lines[j].stack
+		})
+	}
+
+	for _, fl := range lines {
 		var values []string
-		for _, val := range sample.Value {
+		for _, val := range fl.values {
 			values = append(values, fmt.Sprintf("%d", val))
-			if !p.SampleTypes {
+			if !p.SampleTypes && !p.Extended {
 				break
 			}
 		}
 		fmt.Fprintf(
 			w,
 			"%s %s\n",
-			strings.Join(frames, ";"),
+			fl.stack,
 			strings.Join(values, " "),
 		)
 	}
 	return w.Flush()
 }
+
+// formatExtendedFrame returns the given line as a frame of the extended folded
+// format.
+func formatExtendedFrame(line profile.Line) string {
+	name := escapeFolded(line.Function.Name, frameReserved)
+	if line.Function.Filename == "" && line.Line == 0 {
+		return name
+	}
+	return name + "@" + escapeFolded(line.Function.Filename, frameReserved) + ":" + strconv.FormatInt(line.Line, 10)
+}
+
+// formatExtendedLabels returns the labels of the given sample as a label block
+// of the extended folded format, or an empty string if there are no labels.
+func formatExtendedLabels(sample *profile.Sample) string {
+	var labels []string
+	for _, k := range SortedKeys(sample.Label) {
+		for _, v := range sample.Label[k] {
+			labels = append(labels, escapeFolded(k, labelReserved)+"="+escapeFolded(v, labelReserved))
+		}
+	}
+	for _, k := range SortedKeys(sample.NumLabel) {
+		for i, v := range sample.NumLabel[k] {
+			label := escapeFolded(k, labelReserved) + "=#" + strconv.FormatInt(v, 10)
+			if units := sample.NumUnit[k]; i < len(units) && units[i] != "" {
+				label += ":" + escapeFolded(units[i], labelReserved)
+			}
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// SortedKeys returns the keys of m in sorted order.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,9 +1,10 @@
 package legacy
 
 import (
+	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +13,19 @@ import (
 )
 
 // Text converts from folded text to protobuf format.
+//
+// In addition to Brendan Gregg's folded format, the first line can contain a
+// custom header that lists the sample types, e.g. "samples/count
+// cpu/nanoseconds". If the header also contains the "@extended" token, the
+// remaining lines use the extended folded format which looks like this:
+//
+//	{region=us,bytes=#1024:bytes} main@/app/main.go:12;foo@/app/foo.go:7 5 10
+//
+// The optional label block at the beginning of a line holds the string and
+// numeric labels of the sample. Numeric values are prefixed with "#" and may be
+// followed by a ":" and their unit. Frames may carry a file name and line
+// number after an "@". Reserved characters in names and labels are escaped
+// using percent encoding, e.g. ";" becomes "%3B".
 type Text struct{}
 
 // Convert parses the given text and returns it as protobuf profile.
@@ -34,11 +48,29 @@ func (c Text) Convert(text io.Reader) (*profile.Profile, error) {
 	m := &profile.Mapping{ID: 1, HasFunctions: true}
 	p.Mapping = []*profile.Mapping{m}
 
-	lines, err := ioutil.ReadAll(text)
-	if err != nil {
-		return nil, err
+	// Frames are interned so that each distinct function and location is only
+	// allocated once, no matter how often it occurs in the input.
+	type functionKey struct{ name, filename string }
+	type locationKey struct {
+		function *profile.Function
+		line     int64
 	}
-	for n, line := range strings.Split(string(lines), "\n") {
+	var (
+		functions = map[functionKey]*profile.Function{}
+		locations = map[locationKey]*profile.Location{}
+		frames    = map[string]*profile.Location{}
+	)
+
+	r := bufio.NewReader(text)
+	extended := false
+	for n := 0; ; n++ {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
@@ -48,6 +80,10 @@ func (c Text) Convert(text io.Reader) (*profile.Profile, error) {
 		if n == 0 && looksLikeHeader(line) {
 			p.SampleType = nil
 			for _, sampleType := range strings.Split(line, " ") {
+				if sampleType == extendedHeader {
+					extended = true
+					continue
+				}
 				parts := strings.Split(sampleType, "/")
 				if len(parts) != 2 {
 					return nil, fmt.Errorf("bad header: %d: %q", n, line)
@@ -57,16 +93,30 @@ func (c Text) Convert(text io.Reader) (*profile.Profile, error) {
 					Unit: parts[1],
 				})
 			}
+			if len(p.SampleType) == 0 {
+				p.SampleType = []*profile.ValueType{{Type: "samples", Unit: "count"}}
+			}
 			continue
 		}
 
+		sample := &profile.Sample{}
+		if extended && strings.HasPrefix(line, "{") {
+			end := strings.Index(line, "} ")
+			if end < 0 {
+				return nil, fmt.Errorf("bad line: %d: %q: unterminated label block", n, line)
+			}
+			if err := parseExtendedLabels(line[1:end], sample); err != nil {
+				return nil, fmt.Errorf("bad line: %d: %q: %s", n, line, err)
+			}
+			line = line[end+2:]
+		}
+
 		parts, err := splitLastN(line, len(p.SampleType))
 		if err != nil {
 			return nil, err
 		}
 
 		stack := strings.Split(parts[0], ";")
-		sample := &profile.Sample{}
 		for _, valS := range parts[1:] {
 			val, err := strconv.ParseInt(valS, 10, 64)
 			if err != nil {
@@ -77,20 +127,45 @@ func (c Text) Convert(text io.Reader) (*profile.Profile, error) {
 
 		for i := range stack {
 			frame := stack[len(stack)-i-1]
-			function := &profile.Function{
-				ID:   functionID,
-				Name: frame,
-			}
-			p.Function = append(p.Function, function)
-			functionID++
+			location := frames[frame]
+			if location == nil {
+				var (
+					fnKey      = functionKey{name: frame}
+					lineNumber int64
+				)
+				if extended {
+					fnKey.name, fnKey.filename, lineNumber, err = parseExtendedFrame(frame)
+					if err != nil {
+						return nil, fmt.Errorf("bad line: %d: %q: %s", n, line, err)
+					}
+				}
 
-			location := &profile.Location{
-				ID:      locationID,
-				Mapping: m,
-				Line:    []profile.Line{{Function: function}},
+				function := functions[fnKey]
+				if function == nil {
+					function = &profile.Function{
+						ID:       functionID,
+						Name:     fnKey.name,
+						Filename: fnKey.filename,
+					}
+					p.Function = append(p.Function, function)
+					functions[fnKey] = function
+					functionID++
+				}
+
+				locKey := locationKey{function: function, line: lineNumber}
+				location = locations[locKey]
+				if location == nil {
+					location = &profile.Location{
+						ID:      locationID,
+						Mapping: m,
+						Line:    []profile.Line{{Function: function, Line: lineNumber}},
+					}
+					p.Location = append(p.Location, location)
+					locations[locKey] = location
+					locationID++
+				}
+				frames[frame] = location
 			}
-			p.Location = append(p.Location, location)
-			locationID++
 
 			sample.Location = append(sample.Location, location)
 		}
@@ -100,12 +175,115 @@ func (c Text) Convert(text io.Reader) (*profile.Profile, error) {
 	return p, p.CheckValid()
 }
 
+const (
+	// extendedHeader is the header token that enables the extended folded
+	// format.
+	extendedHeader = "@extended"
+	// frameReserved are the characters that are escaped in frames of the
+	// extended folded format.
+	frameReserved = "%;@{}\n"
+	// labelReserved are the characters that are escaped in the label block of
+	// the extended folded format.
+	labelReserved = "%,={}#:\n"
+)
+
+// parseExtendedFrame parses a frame of the extended folded format that looks
+// like "name@file:line" or just "name".
+func parseExtendedFrame(frame string) (name, file string, line int64, err error) {
+	name, fileLine, found := strings.Cut(frame, "@")
+	if name, err = unescapeFolded(name); err != nil {
+		return "", "", 0, err
+	} else if !found {
+		return name, "", 0, nil
+	}
+
+	i := strings.LastIndex(fileLine, ":")
+	if i < 0 {
+		return "", "", 0, fmt.Errorf("bad frame: %q: missing line number", frame)
+	}
+	if line, err = strconv.ParseInt(fileLine[i+1:], 10, 64); err != nil {
+		return "", "", 0, fmt.Errorf("bad frame: %q: %s", frame, err)
+	}
+	if file, err = unescapeFolded(fileLine[:i]); err != nil {
+		return "", "", 0, err
+	}
+	return name, file, line, nil
+}
+
+// parseExtendedLabels parses the content of a label block of the extended
+// folded format, e.g. "region=us,bytes=#1024:bytes", and adds the labels to
+// the sample.
+func parseExtendedLabels(block string, sample *profile.Sample) error {
+	for _, label := range strings.Split(block, ",") {
+		k, v, ok := strings.Cut(label, "=")
+		if !ok {
+			return fmt.Errorf("bad label: %q", label)
+		}
+		k, err := unescapeFolded(k)
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(v, "#") {
+			if v, err = unescapeFolded(v); err != nil {
+				return err
+			}
+			if sample.Label == nil {
+				sample.Label = map[string][]string{}
+			}
+			sample.Label[k] = append(sample.Label[k], v)
+			continue
+		}
+
+		numS, unit, _ := strings.Cut(v[1:], ":")
+		num, err := strconv.ParseInt(numS, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad label: %q: %s", label, err)
+		}
+		if unit, err = unescapeFolded(unit); err != nil {
+			return err
+		}
+		if sample.NumLabel == nil {
+			sample.NumLabel = map[string][]int64{}
+			sample.NumUnit = map[string][]string{}
+		}
+		sample.NumLabel[k] = append(sample.NumLabel[k], num)
+		sample.NumUnit[k] = append(sample.NumUnit[k], unit)
+	}
+	return nil
+}
+
+// escapeFolded percent encodes all reserved characters in s.
+func escapeFolded(s string, reserved string) string {
+	if !strings.ContainsAny(s, reserved) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(reserved, s[i]) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// unescapeFolded reverses escapeFolded.
+func unescapeFolded(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+	return url.PathUnescape(s)
+}
+
 // looksLikeHeader returns true if the line looks like this:
 // "samples/count duration/nanoseconds". The heuristic used for detecting this
-// is to check if every space separated value contains a "/" character.
+// is to check if every space separated value contains a "/" character or is
+// the extendedHeader token.
 func looksLikeHeader(line string) bool {
 	for _, sampleType := range strings.Split(line, " ") {
-		if !strings.Contains(sampleType, "/") {
+		if sampleType != extendedHeader && !strings.Contains(sampleType, "/") {
 			return false
 		}
 	}
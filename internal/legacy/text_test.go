@@ -54,6 +54,67 @@ main;foo;bar 3 30000000
 		is.NoErr(Protobuf{SampleTypes: true}.Convert(proto, &textOut))
 		is.Equal(textIn+"\n", textOut.String())
 	})
+
+	t.Run("extended", func(t *testing.T) {
+		is := is.New(t)
+		textIn := strings.TrimSpace(`
+samples/count duration/nanoseconds @extended
+{endpoint=/foo,region=us,bytes=#1024:bytes} main@/app/main.go:12;foo%3Bbar@C:/app/foo.go:7 5 50
+main;std::vector<int>@:3 4 40
+{region=%7Beu%7D} main@/app/main.go:12;foo%3Bbar@C:/app/foo.go:8 3 30
+`)
+		proto, err := Text{}.Convert(strings.NewReader(textIn))
+		is.NoErr(err)
+
+		s := proto.Sample[0]
+		is.Equal(s.Label, map[string][]string{"endpoint": {"/foo"}, "region": {"us"}})
+		is.Equal(s.NumLabel, map[string][]int64{"bytes": {1024}})
+		is.Equal(s.NumUnit, map[string][]string{"bytes": {"bytes"}})
+		is.Equal(s.Location[0].Line[0].Function.Name, "foo;bar")
+		is.Equal(s.Location[0].Line[0].Function.Filename, "C:/app/foo.go")
+		is.Equal(s.Location[0].Line[0].Line, int64(7))
+
+		textOut := bytes.Buffer{}
+		is.NoErr(Protobuf{Extended: true}.Convert(proto, &textOut))
+		is.Equal(textIn+"\n", textOut.String())
+	})
+
+	t.Run("functions and locations are interned", func(t *testing.T) {
+		is := is.New(t)
+		textIn := strings.TrimSpace(`
+main;foo 5
+main;foo;foo 4
+main;bar;foo 3
+`)
+		proto, err := Text{}.Convert(strings.NewReader(textIn))
+		is.NoErr(err)
+		is.Equal(len(proto.Function), 3)
+		is.Equal(len(proto.Location), 3)
+		is.Equal(proto.Sample[1].Location[0], proto.Sample[1].Location[1])
+	})
+
+	t.Run("repeated lines", func(t *testing.T) {
+		is := is.New(t)
+		for _, n := range []int{1, 1000} {
+			textIn := strings.Repeat("main;foo;bar 1\nmain;foo 2\nmain;baz;foo 3\n", n)
+			proto, err := Text{}.Convert(strings.NewReader(textIn))
+			is.NoErr(err)
+			is.Equal(len(proto.Sample), 3*n)
+			is.Equal(len(proto.Function), 4)
+			is.Equal(len(proto.Location), 4)
+		}
+	})
+}
+
+func BenchmarkTextConvert(b *testing.B) {
+	textIn := strings.Repeat("main;foo;bar 1\nmain;foo 2\nmain;baz;foo 3\n", 10000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(textIn)))
+	for i := 0; i < b.N; i++ {
+		if _, err := (Text{}).Convert(strings.NewReader(textIn)); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
 func TestSplitLastN(t *testing.T) {
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sort"
 	"strings"
@@ -14,20 +15,155 @@ const commonSuffix = "\n\n" + `The input and output file default to "-" which me
 
 var Utils = []Util{
 	{
-		Name:       "json",
-		Flags:      map[string]UtilFlag{},
-		ShortUsage: "<input file> <output file>",
+		Name: "json",
+		Flags: map[string]UtilFlag{
+			"simple": {false, "Use a simple json format with one object per sample"},
+		},
+		ShortUsage: "[-simple] <input file> <output file>",
 		ShortHelp:  "Converts from pprof to json and vice versa",
 		LongHelp: strings.TrimSpace(`
 	Converts from pprof to json and vice vera. The input format is automatically
 	detected and used to determine the output format.
+
+	By default the json mirrors the structure of the pprof format. The simple
+	flag selects a denormalized format instead that contains one object per
+	sample with its stack frames (function, file, line, inlined), labels and a
+	values object keyed by "type/unit". Frames with inlined set to true were
+	inlined into the frame that follows them. This format is easier to query
+	with tools like jq. The simple flag must also be given when converting such
+	json back to pprof. Profiles with two sample types of the same type/unit
+	can't be converted to the simple format.
 	`) + commonSuffix,
 		Examples: []Example{
 			{Name: "Convert pprof to json", In: []string{"pprof"}, Out: []string{"json"}},
 			{Name: "Convert json to pprof", In: []string{"json"}, Out: []string{"pprof"}},
 		},
 		Execute: func(ctx context.Context, a *UtilArgs) error {
-			return (&utils.JSON{Input: a.Inputs[0], Output: a.Output}).Execute(ctx)
+			return (&utils.JSON{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+				Simple: a.Flags["simple"].(bool),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "delta",
+		Flags: map[string]UtilFlag{
+			"sample_types": {"", "Space separated list of type/unit sample types to compute the delta for"},
+		},
+		Inputs:     2,
+		ShortUsage: "[-sample_types=<types>] <old file> <new file> <output file>",
+		ShortHelp:  "Subtracts the values of an old profile from a new profile",
+		LongHelp: strings.TrimSpace(`
+Takes two cumulative profiles, e.g. heap, mutex or block profiles taken at
+different points in time, and subtracts the values of the old profile from the
+new profile. The result is a profile that only covers the time between the two
+snapshots. Samples that end up with a value of 0 are dropped.
+
+By default the delta is computed for all sample types. The sample_types flag
+can be used to limit this to the given sample types, e.g.
+"alloc_objects/count alloc_space/bytes" for heap profiles. Other sample types
+retain their value from the new profile.
+
+The output file defaults to "-" which means stdout.
+`),
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Delta{
+				Old:         a.Inputs[0],
+				New:         a.Inputs[1],
+				Output:      a.Output,
+				SampleTypes: a.Flags["sample_types"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "merge",
+		Flags: map[string]UtilFlag{
+			"source_label": {"", "Label key for tagging each sample with the name of its input file"},
+			"force":        {false, "Merge profiles with different sample types by adding zero values"},
+		},
+		Inputs:         2,
+		VariadicInputs: true,
+		ShortUsage:     "[-source_label=<label>] [-force] <input file>... <output file>",
+		ShortHelp:      "Merges multiple profiles into a single profile",
+		LongHelp: strings.TrimSpace(`
+Merges two or more profiles, e.g. one profile per replica of a service, into a
+single profile. The profiles must have the same sample types, unless the force
+flag is given. In that case the missing sample types of each profile are added
+with a value of 0.
+
+The source_label flag adds a label with the given key to every sample. Its
+value is the path of the file the sample came from as given on the command
+line, which allows to break down the merged profile by source. The paths must
+be unique.
+
+The output file defaults to "-" which means stdout.
+`),
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Merge{
+				Inputs:      a.Inputs,
+				InputNames:  a.InputNames,
+				Output:      a.Output,
+				SourceLabel: a.Flags["source_label"].(string),
+				Force:       a.Flags["force"].(bool),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name:       "perfdata",
+		ShortUsage: "<input file> <output file>",
+		ShortHelp:  "Converts between Linux perf.data files and pprof",
+		LongHelp: strings.TrimSpace(`
+Converts the binary perf.data files recorded by Linux perf record to pprof and
+vice versa without requiring the perf binary. The input format is
+automatically detected and used to determine the output format. The profile
+should be recorded with call graphs, e.g. perf record -g.
+
+Each perf event becomes a sample type, and the pid, tid and comm of each
+sample become labels. The memory mappings recorded by perf become the mappings
+of the profile, including their build ids. perf.data files do not contain
+symbols, so the resulting profile contains addresses that can be symbolized by
+pprof if the binaries are available. Use the perfscript utility if you need
+perf to symbolize the profile.
+
+When converting pprof to perf.data, each sample type becomes an event and each
+non-zero value of a sample becomes a sample record with the value as its
+period. Sample types measured in nanoseconds become cpu-clock events, all
+other units are lost. The pid, tid and comm labels and the mappings of the
+locations are retained, but perf.data files can't hold symbols, so the
+profile must contain addresses. Stacks deeper than 8185 frames are truncated
+at the root.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.PerfData{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name:       "perfscript",
+		ShortUsage: "<input file> <output file>",
+		ShortHelp:  "Converts the output of Linux perf script to pprof",
+		LongHelp: strings.TrimSpace(`
+Converts the text output of Linux perf script to pprof without the need for
+Brendan Gregg's stackcollapse-perf.pl script. The profile needs to be recorded
+with call graphs, e.g. perf record -g.
+
+Each perf event becomes a sample type counting its samples. Event modifiers
+like the u in cycles:u are dropped, tracepoints like sched:sched_switch keep
+their subsystem. If perf prints the period of the samples, the sum of the
+periods becomes a second sample type for the event, measured in nanoseconds
+for cpu-clock and task-clock and in events otherwise. The comm, pid and tid of
+each sample become labels. If perf only prints a single id, it is treated as
+the tid. The dso of each frame becomes a mapping and kernel frames are
+annotated with a "_[k]" suffix.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.PerfScript{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+			}).Execute(ctx)
 		},
 	},
 	{
@@ -73,15 +209,31 @@ TODO: Ignore all stdlib packages by default and maybe also popular OSS libs.
 		},
 	},
 	{
-		Name:       "avg",
-		ShortUsage: "<input file> <output file>",
+		Name: "avg",
+		Flags: map[string]UtilFlag{
+			"value":  {"", "The type/unit of the sample type to divide, e.g. alloc_space/bytes"},
+			"count":  {"", "The type/unit of the sample type to divide by, e.g. alloc_objects/count"},
+			"preset": {"", "The sample types of a mutex, block or heap profile, detected if empty"},
+			"add":    {false, "Add the averages as new avg_ sample types instead of overwriting the values"},
+		},
+		ShortUsage: "[-value=<type/unit> -count=<type/unit>] [-preset=mutex|block|heap] [-add] <input file> <output file>",
 		ShortHelp:  "Creates a profile with the average value per sample",
 		LongHelp: strings.TrimSpace(`
-Takes a block or mutex profile and creates a profile that contains the average
-time per contention by dividing the nanoseconds or value in the profile by the
-sample count value.
+Creates a profile that contains the average value per sample by dividing the
+values of one sample type by the values of another. Samples with a count of 0
+get an average of 0.
 
-TODO: Support memory profiles.
+The value and count flags select the sample types, e.g.
+-value=alloc_space/bytes -count=alloc_objects/count for the average size of
+the allocated objects. Alternatively the preset flag selects them for mutex and
+block profiles (delay per contention) or heap profiles (bytes per object for
+the inuse and alloc sample types), it can't be combined with the value and
+count flags. By default the preset is detected from the sample types of the
+profile.
+
+The averages overwrite the values of the divided sample types. The add flag
+adds them as new sample types with an avg_ prefix instead, e.g.
+avg_delay/nanoseconds. It fails if the profile already has such a sample type.
 `) + commonSuffix,
 		Examples: []Example{
 			{Name: "Convert block profile to avg time", In: []string{"pprof", "png"}, Out: []string{"pprof", "png"}},
@@ -90,6 +242,87 @@ TODO: Support memory profiles.
 			return (&utils.Avg{
 				Input:  a.Inputs[0],
 				Output: a.Output,
+				Value:  a.Flags["value"].(string),
+				Count:  a.Flags["count"].(string),
+				Preset: a.Flags["preset"].(string),
+				Add:    a.Flags["add"].(bool),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "diff",
+		Flags: map[string]UtilFlag{
+			"normalize": {false, "Scale the base profile to the totals of the new profile"},
+			"threshold": {0, "Drop stacks with an absolute change below this value"},
+		},
+		Inputs:     2,
+		ShortUsage: "[-normalize] [-threshold=<value>] <base file> <new file> <output file>",
+		ShortHelp:  "Creates a profile showing the difference between two profiles",
+		LongHelp: strings.TrimSpace(`
+Creates a comparison profile by negating the values of the base profile and
+merging them into the new profile like go tool pprof -diff_base. Stacks that
+grew have positive values and stacks that shrunk have negative values.
+
+As with go tool pprof -diff_base, the negated base samples are tagged with a
+pprof::base label, so pprof excludes them from the totals of the profile and
+its percentages are relative to the new profile. The time and duration of the
+output are those of the new profile.
+
+The normalize flag scales the base profile so that its totals match the new
+profile before computing the diff. The threshold flag drops the base and new
+samples of all stacks whose values changed by less than the given absolute
+amount.
+
+The output file defaults to "-" which means stdout.
+`),
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Diff{
+				Base:      a.Inputs[0],
+				New:       a.Inputs[1],
+				Output:    a.Output,
+				Normalize: a.Flags["normalize"].(bool),
+				Threshold: a.Flags["threshold"].(int),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "diffgraph",
+		Flags: map[string]UtilFlag{
+			"sample_type": {"", "The type/unit of the sample type to render, defaults to the default sample type"},
+			"normalize":   {false, "Scale the base profile to the total of the new profile"},
+			"inverted":    {false, "Render an icicle graph with the root frame at the top"},
+			"title":       {"Differential Flame Graph", "The title of the flame graph"},
+			"width":       {1200, "The width of the flame graph in pixels"},
+		},
+		Inputs:      2,
+		ShortUsage:  "[-sample_type=<type/unit>] [-normalize] [-inverted] [-title=<title>] [-width=<pixels>] <base file> <new file> <output file>",
+		ShortHelp:   "Renders a differential flame graph SVG for two profiles",
+		ContentType: "image/svg+xml",
+		LongHelp: strings.TrimSpace(`
+Renders an interactive differential flame graph SVG comparing a new profile to
+a base profile. The width of each frame is given by its value in the new
+profile. Frames that grew are colored red and frames that shrunk are colored
+blue, the intensity of the color is given by the size of the change relative
+to the largest change in the graph. Hovering a frame shows its values in both
+profiles.
+
+The normalize flag scales the selected sample type of the base profile so that
+its total matches the new profile before comparing them. This is useful for
+comparing profiles that cover different durations. The other flags work like
+for the flamegraph utility.
+
+The output file defaults to "-" which means stdout.
+`),
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Diffgraph{
+				Base:       a.Inputs[0],
+				New:        a.Inputs[1],
+				Output:     a.Output,
+				SampleType: a.Flags["sample_type"].(string),
+				Normalize:  a.Flags["normalize"].(bool),
+				Inverted:   a.Flags["inverted"].(bool),
+				Title:      a.Flags["title"].(string),
+				Width:      a.Flags["width"].(int),
 			}).Execute(ctx)
 		},
 	},
@@ -98,12 +331,22 @@ TODO: Support memory profiles.
 		Flags: map[string]UtilFlag{
 			"headers":      {false, "Add header column for each sample type"},
 			"line_numbers": {false, "Add line numbers to the name of each frame"},
+			"extended":     {false, "Use the extended format that includes files, lines and labels"},
 		},
-		ShortUsage: "[-headers] [-line_numbers] <input file> <output file>",
+		ShortUsage: "[-headers] [-line_numbers] [-extended] <input file> <output file>",
 		ShortHelp:  "Converts pprof to Brendan Gregg's folded text format and vice versa",
 		LongHelp: strings.TrimSpace(`
 Converts pprof to Brendan Gregg's folded text format and vice versa. The input
 format is automatically detected and used to determine the output format.
+
+The extended flag produces an extended folded format that can be converted
+back to pprof without losing function names, file names, line numbers and
+labels. It starts with a header line like "samples/count @extended" and looks
+like this:
+
+{region=us,bytes=#1024:bytes} main@/app/main.go:12;foo@/app/foo.go:7 5
+
+Reserved characters in names and labels are percent encoded.
 `) + commonSuffix,
 		Examples: []Example{
 			{Name: "Convert folded text to pprof", In: []string{"txt"}, Out: []string{"pprof", "png"}},
@@ -115,30 +358,78 @@ format is automatically detected and used to determine the output format.
 				Output:      a.Output,
 				Headers:     a.Flags["headers"].(bool),
 				LineNumbers: a.Flags["line_numbers"].(bool),
+				Extended:    a.Flags["extended"].(bool),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "labels",
+		Flags: map[string]UtilFlag{
+			"set":         {[]string(nil), "Set a key=value label on every sample, can be repeated"},
+			"delete":      {[]string(nil), "Comma separated label keys to delete, can be repeated"},
+			"rename":      {[]string(nil), "Rename a label key given as old=new, can be repeated"},
+			"keep":        {[]string(nil), "Comma separated label keys to keep, all other labels are deleted"},
+			"tag_numeric": {[]string(nil), "Convert the integer values of a string label into a numeric label given as key=unit, can be repeated"},
+		},
+		ShortUsage: "[-set=<key>=<value>] [-delete=<keys>] [-rename=<old>=<new>] [-keep=<keys>] [-tag_numeric=<key>=<unit>] <input file> <output file>",
+		ShortHelp:  "Sets, deletes, renames or keeps the labels of a profile",
+		LongHelp: strings.TrimSpace(`
+Modifies the labels of all samples in a profile, e.g. to strip high cardinality
+span labels before archiving it. All flags can be repeated, over http the query
+params can be repeated as well.
+
+The operations are applied in the order rename, delete, keep, set and
+tag_numeric. The delete and keep flags apply to string and numeric labels and
+take comma separated lists of keys. The tag_numeric flag converts string labels
+whose values are all integers into numeric labels, the unit is optional.
+Samples that end up with identical labels and stacks are merged.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Labels{
+				Input:      a.Inputs[0],
+				Output:     a.Output,
+				Set:        a.Flags["set"].([]string),
+				Delete:     a.Flags["delete"].([]string),
+				Rename:     a.Flags["rename"].([]string),
+				Keep:       a.Flags["keep"].([]string),
+				TagNumeric: a.Flags["tag_numeric"].([]string),
 			}).Execute(ctx)
 		},
 	},
 	{
 		Name: "labelframes",
 		Flags: map[string]UtilFlag{
-			"label": {"mylabel", "The label key to turn into virtual frames."},
+			"label":    {"mylabel", "Comma separated label keys to turn into virtual frames."},
+			"position": {"root", "Add the frames at the root or leaf of the stacks."},
+			"buckets":  {[]string(nil), "Bucket boundaries for a numeric label given as key=1KB,4KB,64KB, can be repeated."},
 		},
-		ShortUsage: "-label=<label> <input file> <output file>",
-		ShortHelp:  "Adds virtual root frames for the given pprof label",
+		ShortUsage: "-label=<label>[,<label>...] [-position=root|leaf] [-buckets=<key>=<boundary>,...] <input file> <output file>",
+		ShortHelp:  "Adds virtual root or leaf frames for the given pprof labels",
 		LongHelp: strings.TrimSpace(`
-DEPRECATED: Use pprof -tagleaf or -tagroot instead.
+Adds virtual frames for the values of the given pprof labels to every sample
+like pprof -tagroot and -tagleaf. This is useful to visualize label values in
+a flamegraph. Samples without a label get a frame with the value N/A.
 
-Adds virtual root frames for the each value of the selected pprof label. This
-is useful to visualize label values in a flamegraph.
+The frames are added in the order of the given label keys from caller to
+callee, either at the root or at the leaf of the stacks. Numeric labels are
+formatted according to their unit. The buckets flag groups the values of a
+numeric label into buckets, e.g. -buckets=bytes=1KB,4KB,64KB creates the
+frames bytes<1KB, 1KB<=bytes<4KB, 4KB<=bytes<64KB and bytes>=64KB. Boundaries
+are either all byte sizes, all durations or all integers, and only values with
+a matching unit (bytes, nanoseconds or none/count) are bucketed. Other values
+are formatted according to their unit. Frames with the same label value share
+the same virtual function.
 `) + commonSuffix,
 		Examples: []Example{
 			{Name: "Add root frames for pprof label values", In: []string{"pprof", "png"}, Out: []string{"pprof", "png"}},
 		},
 		Execute: func(ctx context.Context, a *UtilArgs) error {
 			return (&utils.Labelframes{
-				Input:  a.Inputs[0],
-				Output: a.Output,
-				Label:  a.Flags["label"].(string),
+				Input:    a.Inputs[0],
+				Output:   a.Output,
+				Label:    a.Flags["label"].(string),
+				Position: a.Flags["position"].(string),
+				Buckets:  a.Flags["buckets"].([]string),
 			}).Execute(ctx)
 		},
 	},
@@ -177,6 +468,365 @@ Converts jemalloc heap profile to pprof format.
 			}).Execute(ctx)
 		},
 	},
+	{
+		Name:       "speedscope",
+		ShortUsage: "<input file> <output file>",
+		ShortHelp:  "Converts from pprof to speedscope and vice versa",
+		LongHelp: strings.TrimSpace(`
+Converts from pprof to the speedscope file format and vice versa. The input
+format is automatically detected and used to determine the output format.
+
+When converting to speedscope, each sample type becomes a "sampled" profile
+named after the sample type. When converting to pprof, "sampled" and "evented"
+profiles are supported. Profiles that are not named after a sample type are
+converted using their unit, e.g. wall/nanoseconds for time units, and their
+name is added as a "profile" label to each sample.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Speedscope{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name:       "cpuprofile",
+		ShortUsage: "<input file> <output file>",
+		ShortHelp:  "Converts from V8 .cpuprofile to pprof and vice versa",
+		LongHelp: strings.TrimSpace(`
+Converts from the V8 .cpuprofile format used by Node.js and Chrome DevTools to
+pprof and vice versa. The input format is automatically detected and used to
+determine the output format.
+
+When converting to pprof, the url, lineNumber and columnNumber of each call
+frame become the filename, line and column of the function. The resulting
+profile has a samples/count and a cpu/nanoseconds sample type, the latter is
+derived from the timeDeltas of the samples.
+
+When converting to .cpuprofile, the input profile must have a samples/count
+sample type. The time of each sample is taken from the first sample type with
+a nanoseconds unit, e.g. cpu/nanoseconds for Go CPU profiles. Each sample is
+written once per count, splitting its time evenly. If the counts add up to
+more than 1048576, the number of times each sample is written is scaled down
+proportionally, but the hitCount of each node retains the full count. The
+output can be loaded in the Performance panel of Chrome DevTools.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.CPUProfile{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "firefox",
+		Flags: map[string]UtilFlag{
+			"thread_label": {"goroutine", "Label key for splitting samples into threads"},
+			"sample_type":  {"", "The type/unit of the sample type to export, defaults to the default sample type"},
+		},
+		ShortUsage: "[-thread_label=<key>] [-sample_type=<type/unit>] <input file> <output file>",
+		ShortHelp:  "Converts from pprof to the Firefox Profiler format",
+		LongHelp: strings.TrimSpace(`
+Converts from pprof to the processed profile format of the Firefox Profiler
+(https://profiler.firefox.com). The output can be loaded into the profiler via
+"Load a profile from file" without uploading it anywhere.
+
+Samples are split into threads by the value of the label given by the
+thread_label flag, e.g. "goroutine" or "thread". Samples without this label
+end up in the main thread.
+
+Only one sample type is exported, it can be selected with the sample_type
+flag. pprof doesn't record when samples were taken, so the samples of each
+thread are laid out one sampling interval apart in the timeline.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Firefox{
+				Input:       a.Inputs[0],
+				Output:      a.Output,
+				ThreadLabel: a.Flags["thread_label"].(string),
+				SampleType:  a.Flags["sample_type"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "otlp",
+		Flags: map[string]UtilFlag{
+			"resource_attributes": {"", "Comma separated key=value list of resource attributes, e.g. service.name=api"},
+		},
+		ShortUsage: "[-resource_attributes=<key=value,...>] <input file> <output file>",
+		ShortHelp:  "Converts from pprof to OpenTelemetry profiles and vice versa",
+		LongHelp: strings.TrimSpace(`
+Converts from pprof to the OpenTelemetry profiles signal and vice versa. The
+input format is automatically detected and used to determine the output
+format. The OpenTelemetry format is a ProfilesData protobuf message as defined
+by the v1development profiles proto of opentelemetry-proto v1.5.0. Later
+releases of the proto that move the tables into a ProfilesDictionary are not
+supported yet.
+
+Sample types, mappings, locations and functions are mapped one to one. Labels
+become attributes, numeric labels become int attributes with their units
+recorded in the attribute units of the profile.
+
+When converting to OpenTelemetry, the resource_attributes flag can be used to
+attach attributes such as service.name to the resource of the profile. When
+converting to pprof, all profiles contained in the input are merged into one
+and resource attributes are added as labels to their samples.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.OTLP{
+				Input:              a.Inputs[0],
+				Output:             a.Output,
+				ResourceAttributes: a.Flags["resource_attributes"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name:       "callgrind",
+		ShortUsage: "<input file> <output file>",
+		ShortHelp:  "Converts from pprof to callgrind and vice versa",
+		LongHelp: strings.TrimSpace(`
+Converts from pprof to the callgrind format used by valgrind, KCachegrind and
+QCachegrind and vice versa. The input format is automatically detected and used
+to determine the output format.
+
+When converting to callgrind, each sample type becomes an event. The self cost
+of each function is recorded per line if the profile has line numbers, and
+each call between two functions is recorded with its inclusive cost. pprof
+doesn't record how often a function was called, so the call count is the
+number of samples that contain the call.
+
+When converting to pprof, each event becomes a sample type, and the first event
+of the events line becomes the period type. Callgrind files don't contain full
+stacks, so they are reconstructed by distributing the self cost of each
+function over its callers in proportion to the inclusive cost of their calls.
+The result is exact if the call graph is a tree, and an approximation
+otherwise.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Callgrind{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "flamegraph",
+		Flags: map[string]UtilFlag{
+			"sample_type": {"", "The type/unit of the sample type to render, defaults to the default sample type"},
+			"inverted":    {false, "Render an icicle graph with the root frame at the top"},
+			"title":       {"Flame Graph", "The title of the flame graph"},
+			"width":       {1200, "The width of the flame graph in pixels"},
+			"colors":      {"package", "The color scheme, package or hot"},
+			"search":      {"", "Regular expression for highlighting matching frames"},
+		},
+		ShortUsage:  "[-sample_type=<type/unit>] [-inverted] [-title=<title>] [-width=<pixels>] [-colors=package|hot] [-search=<regexp>] <input file> <output file>",
+		ShortHelp:   "Renders a flame graph SVG for a profile",
+		ContentType: "image/svg+xml",
+		LongHelp: strings.TrimSpace(`
+Renders an interactive flame graph SVG for a profile. Clicking a frame zooms
+into it and the search button highlights frames matching a regular expression.
+The SVG can be opened directly in a browser.
+
+Only one sample type is rendered, it can be selected with the sample_type
+flag. The inverted flag renders an icicle graph with the root frame at the top
+instead of the bottom. By default frames are colored by package so that all
+functions of a package have the same color. The hot color scheme uses random
+warm colors instead. The search flag highlights matching frames when the
+flame graph is opened.
+`) + commonSuffix,
+		Examples: []Example{
+			{Name: "Render a CPU profile", In: []string{"pprof"}, Out: []string{"svg"}},
+		},
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Flamegraph{
+				Input:      a.Inputs[0],
+				Output:     a.Output,
+				SampleType: a.Flags["sample_type"].(string),
+				Inverted:   a.Flags["inverted"].(bool),
+				Title:      a.Flags["title"].(string),
+				Width:      a.Flags["width"].(int),
+				Colors:     a.Flags["colors"].(string),
+				Search:     a.Flags["search"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "dot",
+		Flags: map[string]UtilFlag{
+			"sample_type":   {"", "The type/unit of the sample type to render, defaults to the default sample type"},
+			"node_fraction": {0.005, "Drop nodes with a cumulative value below this fraction of the total"},
+			"edge_fraction": {0.001, "Drop edges with a value below this fraction of the total"},
+			"cluster_label": {"", "Group the call graph into one cluster per value of this label"},
+		},
+		ShortUsage:  "[-sample_type=<type/unit>] [-node_fraction=<float>] [-edge_fraction=<float>] [-cluster_label=<key>] <input file> <output file>",
+		ShortHelp:   "Renders the call graph of a profile in the graphviz DOT format",
+		ContentType: "text/vnd.graphviz",
+		LongHelp: strings.TrimSpace(`
+Renders the call graph of a profile in the graphviz DOT format. Each node is a
+function labeled with its flat and cumulative value, each edge is a call
+labeled with the value of the samples it appears in. The output can be turned
+into an image with e.g. "dot -Tsvg".
+
+Nodes and edges below node_fraction and edge_fraction of the total are dropped
+like in "go tool pprof". The cluster_label flag groups the graph into one
+cluster per value of the given label, samples without the label are grouped
+into a N/A cluster. The output is deterministic for a given input.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Dot{
+				Input:        a.Inputs[0],
+				Output:       a.Output,
+				SampleType:   a.Flags["sample_type"].(string),
+				NodeFraction: a.Flags["node_fraction"].(float64),
+				EdgeFraction: a.Flags["edge_fraction"].(float64),
+				ClusterLabel: a.Flags["cluster_label"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "filter",
+		Flags: map[string]UtilFlag{
+			"focus":     {"", "Keep only samples with a frame matching this regexp"},
+			"ignore":    {"", "Drop samples with a frame matching this regexp"},
+			"hide":      {"", "Remove frames matching this regexp but keep their samples"},
+			"show_from": {"", "Drop frames above the highest frame matching this regexp"},
+		},
+		ShortUsage: "[-focus=<regexp>] [-ignore=<regexp>] [-hide=<regexp>] [-show_from=<regexp>] <input file> <output file>",
+		ShortHelp:  "Filters the samples and frames of a profile like go tool pprof",
+		LongHelp: strings.TrimSpace(`
+Filters the samples and frames of a profile with the same semantics as the
+-focus, -ignore, -hide and -show_from flags of go tool pprof, so the results
+agree with it. The regular expressions are matched against the function names
+and file names of the frames. Packages can be matched by the prefix of the
+function names, e.g. "^encoding/json\.".
+
+The focus flag keeps only the samples with a matching frame and the ignore
+flag drops them. The hide flag removes the matching frames from the stacks but
+keeps the samples. The show_from flag drops all frames above the highest
+matching frame and drops the samples without a matching frame.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Filter{
+				Input:    a.Inputs[0],
+				Output:   a.Output,
+				Focus:    a.Flags["focus"].(string),
+				Ignore:   a.Flags["ignore"].(string),
+				Hide:     a.Flags["hide"].(string),
+				ShowFrom: a.Flags["show_from"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "report",
+		Flags: map[string]UtilFlag{
+			"title": {"Profile Report", "The title of the report"},
+			"top":   {100, "Number of functions in the top table, 0 for all"},
+		},
+		ShortUsage:  "[-title=<title>] [-top=<n>] <input file> <output file>",
+		ShortHelp:   "Renders a self-contained HTML report for a profile",
+		ContentType: "text/html; charset=utf-8",
+		LongHelp: strings.TrimSpace(`
+Renders a self-contained HTML report for a profile that can be viewed offline,
+e.g. as an attachment of an incident ticket. The report contains a summary of
+the profile with its time, duration and sample type totals. For each sample
+type it contains a sortable table of the top functions by flat and cumulative
+value, an interactive flame graph and a breakdown of the values by label.
+
+The top flag limits the number of functions in the top table.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Report{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+				Title:  a.Flags["title"].(string),
+				Top:    a.Flags["top"].(int),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "top",
+		Flags: map[string]UtilFlag{
+			"n":           {0, "Number of entries to report, 0 for all"},
+			"sample_type": {"", "The type/unit of the sample type to report, defaults to the default sample type"},
+			"by":          {"function", "Granularity of the entries, function, file or package"},
+			"format":      {"text", "Output format, text, csv or json"},
+		},
+		ShortUsage: "[-n=<n>] [-sample_type=<type/unit>] [-by=function|file|package] [-format=text|csv|json] <input file> <output file>",
+		ShortHelp:  "Prints the top entries of a profile like go tool pprof -top",
+		LongHelp: strings.TrimSpace(`
+Prints the functions, files or packages of a profile with the highest flat
+value like go tool pprof -top. Each entry is reported with its flat and
+cumulative value, their percentage of the total and the running sum of the
+flat percentages.
+
+Only one sample type is reported, it can be selected with the sample_type
+flag. The output is a text table, csv or json.
+`) + commonSuffix,
+		Examples: []Example{
+			{Name: "Print the top functions of a CPU profile", In: []string{"pprof"}, Out: []string{"txt"}},
+		},
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Top{
+				Input:      a.Inputs[0],
+				Output:     a.Output,
+				N:          a.Flags["n"].(int),
+				SampleType: a.Flags["sample_type"].(string),
+				By:         a.Flags["by"].(string),
+				Format:     a.Flags["format"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "split",
+		Flags: map[string]UtilFlag{
+			"label": {"mylabel", "The label key to split the profile by"},
+		},
+		ShortUsage:  "-label=<label> <input file> <output file>",
+		ShortHelp:   "Splits a profile into one profile per label value",
+		ContentType: "application/zip",
+		LongHelp: strings.TrimSpace(`
+Splits a profile into one profile per value of the given label and writes them
+into a zip archive. Numeric labels are grouped by their value formatted
+according to their unit, e.g. 2.00kB. This is the inverse of merging profiles
+with a source label.
+
+The files in the archive are named <label>=<value>.pprof with the label and
+value escaped like an URL path segment. Samples without the label are written
+into <label>.pprof. Functions, locations and mappings that are not used by the
+samples of a profile are removed from it.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Split{
+				Input:  a.Inputs[0],
+				Output: a.Output,
+				Label:  a.Flags["label"].(string),
+			}).Execute(ctx)
+		},
+	},
+	{
+		Name: "stats",
+		Flags: map[string]UtilFlag{
+			"format":     {"text", "Output format, text or json"},
+			"recursions": {10, "Number of top recursive locations to report"},
+		},
+		ShortUsage: "[-format=text|json] [-recursions=<n>] <input file> <output file>",
+		ShortHelp:  "Prints statistics about a profile",
+		LongHelp: strings.TrimSpace(`
+Prints statistics about a profile as a text table or as json. This includes the
+number of samples, locations, functions and mappings, the total value of each
+sample type, stack depth percentiles, the number of unsymbolized locations and
+duplicate functions, the number of distinct values of each label key and the
+top recursive locations.
+`) + commonSuffix,
+		Execute: func(ctx context.Context, a *UtilArgs) error {
+			return (&utils.Stats{
+				Input:      a.Inputs[0],
+				Output:     a.Output,
+				Format:     a.Flags["format"].(string),
+				Recursions: a.Flags["recursions"].(int),
+			}).Execute(ctx)
+		},
+	},
 }
 
 func init() {
@@ -199,12 +849,44 @@ type Util struct {
 	LongHelp   string
 	Examples   []Example
 	Execute    func(context.Context, *UtilArgs) error
+
+	// Inputs is the number of input files expected by the util. Zero means
+	// one.
+	Inputs int
+	// VariadicInputs allows the util to take more than Inputs input files.
+	VariadicInputs bool
+	// ContentType is the media type of the output returned by the web service.
+	// If empty, it is detected from the output.
+	ContentType string
+}
+
+// NumInputs returns the number of input files expected by the util.
+func (u Util) NumInputs() int {
+	if u.Inputs == 0 {
+		return 1
+	}
+	return u.Inputs
+}
+
+// CheckInputs returns an error if n is not an acceptable number of input files
+// for the util.
+func (u Util) CheckInputs(n int) error {
+	if u.VariadicInputs && n < u.NumInputs() {
+		return fmt.Errorf("expected at least %d input files, got %d", u.NumInputs(), n)
+	} else if !u.VariadicInputs && n != u.NumInputs() {
+		return fmt.Errorf("expected %d input files, got %d", u.NumInputs(), n)
+	}
+	return nil
 }
 
 type UtilArgs struct {
 	Inputs [][]byte
-	Output io.Writer
-	Flags  map[string]interface{}
+	// InputNames holds the path of each input as given on the command line or
+	// the file name of each uploaded input, or an empty string if the name is
+	// unknown.
+	InputNames []string
+	Output     io.Writer
+	Flags      map[string]interface{}
 }
 
 type UtilFlag struct {
@@ -34,6 +34,7 @@ func run() error {
 		"queryflags": queryflags,
 		"defaultval": defaultval,
 		"examples":   examples,
+		"curlinputs": curlinputs,
 	}
 
 	tmpl, err := template.New("README.md").Funcs(fns).Parse(input.String())
@@ -46,6 +47,9 @@ func run() error {
 
 func defaultval(val interface{}) string {
 	defaultVal := fmt.Sprintf("%v", val)
+	if vals, ok := val.([]string); ok {
+		defaultVal = strings.Join(vals, ",")
+	}
 	if defaultVal == "" {
 		defaultVal = "..."
 	}
@@ -65,6 +69,22 @@ func queryflags(flags map[string]internal.UtilFlag) string {
 	return "?" + strings.Join(params, "&")
 }
 
+func curlinputs(util internal.Util) string {
+	n := util.NumInputs()
+	if n == 1 && !util.VariadicInputs {
+		return "--data-binary @<input file>"
+	}
+
+	var params []string
+	for i := 1; i <= n; i++ {
+		params = append(params, fmt.Sprintf("-F file%d=@<input file %d>", i, i))
+	}
+	if util.VariadicInputs {
+		params = append(params, "...")
+	}
+	return strings.Join(params, " ")
+}
+
 func examples(util internal.Util) (string, error) {
 	var (
 		b = &strings.Builder{}
@@ -2,16 +2,38 @@ package utils
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 
 	"github.com/google/pprof/profile"
 )
 
+// Avg divides the values of one sample type by the values of another, e.g.
+// to get the average delay per contention of a block profile.
 type Avg struct {
 	Input  []byte
 	Output io.Writer
+	// Value is the type/unit of the sample type to divide. It must be given
+	// together with Count and can't be combined with Preset.
+	Value string
+	// Count is the type/unit of the sample type to divide by.
+	Count string
+	// Preset selects the sample types for a kind of profile, either "mutex",
+	// "block" or "heap". It is detected from the sample types if empty.
+	Preset string
+	// Add adds the averages as new sample types with an "avg_" prefix instead
+	// of overwriting the values.
+	Add bool
+}
+
+// avgPresets holds the value and count sample types for each preset.
+var avgPresets = map[string][][2]string{
+	"mutex": {{"delay/nanoseconds", "contentions/count"}},
+	"block": {{"delay/nanoseconds", "contentions/count"}},
+	"heap": {
+		{"inuse_space/bytes", "inuse_objects/count"},
+		{"alloc_space/bytes", "alloc_objects/count"},
+	},
 }
 
 func (a *Avg) Execute(ctx context.Context) error {
@@ -20,34 +42,68 @@ func (a *Avg) Execute(ctx context.Context) error {
 		return err
 	}
 
-	var (
-		countIDX = -1
-		delayIDX = -1
-	)
-	for i, st := range prof.SampleType {
-		if st.Type == "contentions" && st.Unit == "count" {
-			countIDX = i
+	var pairs [][2]string
+	switch {
+	case a.Value != "" || a.Count != "":
+		if a.Value == "" || a.Count == "" {
+			return fmt.Errorf("value and count must be given together")
+		} else if a.Preset != "" {
+			return fmt.Errorf("value and count can't be combined with preset")
 		}
-		if st.Type == "delay" && st.Unit == "nanoseconds" {
-			delayIDX = i
+		pairs = [][2]string{{a.Value, a.Count}}
+	case a.Preset != "":
+		if pairs = avgPresets[a.Preset]; pairs == nil {
+			return fmt.Errorf("unknown preset: %q: must be mutex, block or heap", a.Preset)
+		}
+	default:
+		pairs = avgPresets["block"]
+		heap, _ := parseValueType(avgPresets["heap"][0][0])
+		if sampleTypeIndex(prof, heap) != -1 {
+			pairs = avgPresets["heap"]
 		}
 	}
-	if countIDX == -1 {
-		return errors.New("profile lacks a contentions/count sample type")
-	}
-	if delayIDX == -1 {
-		return errors.New("profile lacks a delay/nanoseconds sample type")
-	}
 
-	for i, s := range prof.Sample {
-		if countIDX >= len(s.Value) {
-			return fmt.Errorf("sample %d has no contentions/count value", i)
+	for _, pair := range pairs {
+		var idx [2]int
+		for i, s := range pair {
+			vt, err := parseValueType(s)
+			if err != nil {
+				return err
+			}
+			if idx[i] = sampleTypeIndex(prof, vt); idx[i] == -1 {
+				return fmt.Errorf("profile lacks a %s sample type", s)
+			}
+		}
+		valueIDX, countIDX := idx[0], idx[1]
+
+		dst := valueIDX
+		if a.Add {
+			st := &profile.ValueType{Type: "avg_" + prof.SampleType[valueIDX].Type, Unit: prof.SampleType[valueIDX].Unit}
+			if sampleTypeIndex(prof, *st) != -1 {
+				return fmt.Errorf("profile already has the sample type %s/%s", st.Type, st.Unit)
+			}
+			dst = len(prof.SampleType)
+			prof.SampleType = append(prof.SampleType, st)
 		}
-		if delayIDX >= len(s.Value) {
-			return fmt.Errorf("sample %d has no delay/nanoseconds value", i)
+		for i, s := range prof.Sample {
+			if countIDX >= len(s.Value) {
+				return fmt.Errorf("sample %d has no %s value", i, pair[1])
+			}
+			if valueIDX >= len(s.Value) {
+				return fmt.Errorf("sample %d has no %s value", i, pair[0])
+			}
+			// Samples with a count of 0 get an average of 0.
+			var avg int64
+			if count := s.Value[countIDX]; count != 0 {
+				avg = s.Value[valueIDX] / count
+			}
+			if a.Add {
+				n := len(s.Value)
+				s.Value = append(s.Value[:n:n], avg)
+			} else {
+				s.Value[dst] = avg
+			}
 		}
-		count, delay := s.Value[countIDX], s.Value[delayIDX]
-		s.Value[delayIDX] = delay / count
 	}
 
 	return prof.Write(a.Output)
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvg(t *testing.T) {
+	block := foldedProfile(t, `contentions/count delay/nanoseconds
+main;lock 4 100
+main;unlock 0 50
+`)
+	heap := foldedProfile(t, `alloc_objects/count alloc_space/bytes inuse_objects/count inuse_space/bytes
+main;alloc 4 4096 2 1024
+main;free 3 300 0 0
+`)
+
+	tests := []struct {
+		name  string
+		input []byte
+		avg   Avg
+		types string
+		want  map[string][]int64
+	}{
+		{
+			name:  "detect block",
+			input: block,
+			avg:   Avg{},
+			types: "contentions/count delay/nanoseconds",
+			want:  map[string][]int64{"main;lock": {4, 25}, "main;unlock": {0, 0}},
+		},
+		{
+			name:  "detect heap",
+			input: heap,
+			avg:   Avg{},
+			types: "alloc_objects/count alloc_space/bytes inuse_objects/count inuse_space/bytes",
+			want:  map[string][]int64{"main;alloc": {4, 1024, 2, 512}, "main;free": {3, 100, 0, 0}},
+		},
+		{
+			name:  "value count add",
+			input: heap,
+			avg:   Avg{Value: "alloc_space/bytes", Count: "alloc_objects/count", Add: true},
+			types: "alloc_objects/count alloc_space/bytes inuse_objects/count inuse_space/bytes avg_alloc_space/bytes",
+			want:  map[string][]int64{"main;alloc": {4, 4096, 2, 1024, 1024}, "main;free": {3, 300, 0, 0, 100}},
+		},
+		{
+			name:  "mutex preset add",
+			input: block,
+			avg:   Avg{Preset: "mutex", Add: true},
+			types: "contentions/count delay/nanoseconds avg_delay/nanoseconds",
+			want:  map[string][]int64{"main;lock": {4, 100, 25}, "main;unlock": {0, 50, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.avg.Input, tt.avg.Output = tt.input, out
+			require.NoError(t, tt.avg.Execute(context.Background()))
+			prof, err := profile.ParseData(out.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, tt.types, formatSampleTypes(prof))
+			got := map[string][]int64{}
+			for i := range prof.SampleType {
+				for stack, v := range sampleValues(prof, "", i) {
+					got[stack] = append(got[stack], v)
+				}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("missing sample type", func(t *testing.T) {
+		err := (&Avg{Input: heap, Output: &bytes.Buffer{}, Preset: "block"}).Execute(context.Background())
+		require.EqualError(t, err, "profile lacks a delay/nanoseconds sample type")
+	})
+
+	t.Run("value and preset", func(t *testing.T) {
+		err := (&Avg{Input: heap, Output: &bytes.Buffer{}, Value: "alloc_space/bytes", Count: "alloc_objects/count", Preset: "heap"}).Execute(context.Background())
+		require.EqualError(t, err, "value and count can't be combined with preset")
+	})
+
+	t.Run("add twice", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Avg{Input: block, Output: out, Add: true}).Execute(context.Background()))
+		err := (&Avg{Input: out.Bytes(), Output: &bytes.Buffer{}, Add: true}).Execute(context.Background())
+		require.EqualError(t, err, "profile already has the sample type avg_delay/nanoseconds")
+	})
+}
@@ -0,0 +1,632 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Callgrind converts pprof to the callgrind format used by valgrind and
+// KCachegrind and vice versa. The direction is determined by the input format.
+type Callgrind struct {
+	Input  []byte
+	Output io.Writer
+}
+
+func (c *Callgrind) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(c.Input)
+	if err == nil {
+		return writeCallgrind(prof, c.Output)
+	}
+
+	if !callgrindEventsRegexp.Match(c.Input) {
+		return errors.New("input format is neither pprof nor callgrind")
+	}
+	prof, err = parseCallgrind(bytes.NewReader(c.Input))
+	if err != nil {
+		return err
+	}
+	return prof.Write(c.Output)
+}
+
+var (
+	callgrindEventsRegexp = regexp.MustCompile(`(?m)^events:`)
+	callgrindEventName    = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// callgrindFunc identifies a function in a callgrind file.
+type callgrindFunc struct {
+	name string
+	file string
+}
+
+type callgrindCallKey struct {
+	line   int64
+	callee callgrindFunc
+}
+
+type callgrindCall struct {
+	count int64
+	cost  []int64
+}
+
+// callgrindFuncCosts holds the self cost by line and the calls of a function.
+type callgrindFuncCosts struct {
+	self  map[int64][]int64
+	calls map[callgrindCallKey]*callgrindCall
+}
+
+func writeCallgrind(prof *profile.Profile, out io.Writer) error {
+	var (
+		n         = len(prof.SampleType)
+		funcs     = map[callgrindFunc]*callgrindFuncCosts{}
+		startLine = map[callgrindFunc]int64{}
+		totals    = make([]int64, n)
+	)
+	costs := func(fn callgrindFunc) *callgrindFuncCosts {
+		c := funcs[fn]
+		if c == nil {
+			c = &callgrindFuncCosts{self: map[int64][]int64{}, calls: map[callgrindCallKey]*callgrindCall{}}
+			funcs[fn] = c
+		}
+		return c
+	}
+	add := func(dst []int64, src []int64) {
+		for i, v := range src {
+			dst[i] += v
+		}
+	}
+
+	type frame struct {
+		fn   callgrindFunc
+		line int64
+	}
+	for _, s := range prof.Sample {
+		var frames []frame
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				fn := callgrindFunc{name: line.Function.Name, file: line.Function.Filename}
+				startLine[fn] = line.Function.StartLine
+				frames = append(frames, frame{fn: fn, line: line.Line})
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		add(totals, s.Value)
+
+		leaf := costs(frames[0].fn)
+		if leaf.self[frames[0].line] == nil {
+			leaf.self[frames[0].line] = make([]int64, n)
+		}
+		add(leaf.self[frames[0].line], s.Value)
+
+		// The inclusive cost of a call is only counted once per sample, even
+		// if the call appears several times in a recursive stack.
+		seen := map[frame]map[callgrindFunc]bool{}
+		for i := 1; i < len(frames); i++ {
+			caller, callee := frames[i], frames[i-1].fn
+			if seen[caller][callee] {
+				continue
+			} else if seen[caller] == nil {
+				seen[caller] = map[callgrindFunc]bool{}
+			}
+			seen[caller][callee] = true
+
+			key := callgrindCallKey{line: caller.line, callee: callee}
+			c := costs(caller.fn).calls[key]
+			if c == nil {
+				c = &callgrindCall{cost: make([]int64, n)}
+				costs(caller.fn).calls[key] = c
+			}
+			c.count++
+			add(c.cost, s.Value)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "# callgrind format\nversion: 1\ncreator: pprofutils\npositions: line\n")
+	var events []string
+	for i, st := range prof.SampleType {
+		name := callgrindEventName.ReplaceAllString(st.Type, "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+		for _, e := range events {
+			if e == name {
+				name = fmt.Sprintf("%s_%d", name, i)
+			}
+		}
+		events = append(events, name)
+		fmt.Fprintf(w, "event: %s : %s/%s\n", name, st.Type, st.Unit)
+	}
+	fmt.Fprintf(w, "events: %s\n", strings.Join(events, " "))
+	fmt.Fprintf(w, "summary: %s\n", formatCallgrindCosts(totals))
+
+	// Names are compressed by assigning an id to each file and function the
+	// first time it is written.
+	fileIDs, fnIDs := map[string]int{}, map[string]int{}
+	name := func(ids map[string]int, s string) string {
+		if s == "" {
+			return ""
+		} else if id, ok := ids[s]; ok {
+			return fmt.Sprintf("(%d)", id)
+		}
+		ids[s] = len(ids) + 1
+		return fmt.Sprintf("(%d) %s", ids[s], s)
+	}
+
+	fns := make([]callgrindFunc, 0, len(funcs))
+	for fn := range funcs {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool { return callgrindFuncLess(fns[i], fns[j]) })
+	for _, fn := range fns {
+		c := funcs[fn]
+		fmt.Fprintf(w, "\nfl=%s\nfn=%s\n", name(fileIDs, fn.file), name(fnIDs, fn.name))
+
+		lines := make([]int64, 0, len(c.self))
+		for line := range c.self {
+			lines = append(lines, line)
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+		for _, line := range lines {
+			fmt.Fprintf(w, "%d %s\n", line, formatCallgrindCosts(c.self[line]))
+		}
+
+		keys := make([]callgrindCallKey, 0, len(c.calls))
+		for key := range c.calls {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].line != keys[j].line {
+				return keys[i].line < keys[j].line
+			}
+			return callgrindFuncLess(keys[i].callee, keys[j].callee)
+		})
+		for _, key := range keys {
+			call := c.calls[key]
+			if key.callee.file != fn.file {
+				fmt.Fprintf(w, "cfl=%s\n", name(fileIDs, key.callee.file))
+			}
+			fmt.Fprintf(w, "cfn=%s\n", name(fnIDs, key.callee.name))
+			// pprof doesn't record how often a function was called, so the
+			// call count is the number of samples that contain the call.
+			fmt.Fprintf(w, "calls=%d %d\n", call.count, startLine[key.callee])
+			fmt.Fprintf(w, "%d %s\n", key.line, formatCallgrindCosts(call.cost))
+		}
+	}
+	fmt.Fprintf(w, "\ntotals: %s\n", formatCallgrindCosts(totals))
+	return w.Flush()
+}
+
+func formatCallgrindCosts(costs []int64) string {
+	parts := make([]string, len(costs))
+	for i, c := range costs {
+		parts[i] = strconv.FormatInt(c, 10)
+	}
+	return strings.Join(parts, " ")
+}
+
+func callgrindFuncLess(a, b callgrindFunc) bool {
+	if a.file != b.file {
+		return a.file < b.file
+	}
+	return a.name < b.name
+}
+
+// callgrindLoc is a position within a function.
+type callgrindLoc struct {
+	fn   callgrindFunc
+	line int64
+}
+
+type callgrindEdge struct {
+	caller callgrindLoc
+	cost   []int64
+}
+
+// callgrindParser holds the state for parsing a callgrind file.
+type callgrindParser struct {
+	events    []*profile.ValueType
+	lineCol   int
+	numCols   int
+	names     map[string]map[string]string
+	lastPos   []int64
+	self      map[callgrindLoc][]int64
+	callers   map[callgrindFunc][]*callgrindEdge
+	objects   map[callgrindFunc]string
+	fn        callgrindFunc
+	file      string
+	object    string
+	callee    *callgrindFunc
+	calleeObj string
+	inCall    bool
+	calleeFl  string
+}
+
+func parseCallgrind(in io.Reader) (*profile.Profile, error) {
+	p := &callgrindParser{
+		numCols: 1,
+		names:   map[string]map[string]string{},
+		self:    map[callgrindLoc][]int64{},
+		callers: map[callgrindFunc][]*callgrindEdge{},
+		objects: map[callgrindFunc]string{},
+	}
+	descs := map[string]string{}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name, desc, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "event:")), ":")
+			descs[strings.TrimSpace(name)] = strings.TrimSpace(desc)
+		case strings.HasPrefix(line, "events:"):
+			for _, e := range strings.Fields(strings.TrimPrefix(line, "events:")) {
+				vt, err := parseValueType(descs[e])
+				if err != nil {
+					vt = profile.ValueType{Type: e, Unit: "count"}
+				}
+				p.events = append(p.events, &vt)
+			}
+		case strings.HasPrefix(line, "positions:"):
+			cols := strings.Fields(strings.TrimPrefix(line, "positions:"))
+			p.numCols, p.lineCol = len(cols), -1
+			for i, col := range cols {
+				if col == "line" {
+					p.lineCol = i
+				}
+			}
+		case line[0] >= '0' && line[0] <= '9' || line[0] == '+' || line[0] == '-' || line[0] == '*':
+			err = p.parseCostLine(line)
+		default:
+			key, val, ok := strings.Cut(line, "=")
+			if !ok || strings.ContainsAny(key, " :") {
+				// Other header lines such as "cmd:" or "totals:".
+				continue
+			}
+			err = p.parseSpec(key, val)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	} else if len(p.events) == 0 {
+		return nil, errors.New("missing events line")
+	}
+	return p.profile(), nil
+}
+
+// name resolves a compressed name like "(1) main" or "(1)".
+func (p *callgrindParser) name(kind, val string) (string, error) {
+	val = strings.TrimSpace(val)
+	if !strings.HasPrefix(val, "(") {
+		return val, nil
+	}
+	end := strings.Index(val, ")")
+	if end < 0 {
+		return "", fmt.Errorf("bad compressed name: %q", val)
+	}
+	id, name := val[:end+1], strings.TrimSpace(val[end+1:])
+	if p.names[kind] == nil {
+		p.names[kind] = map[string]string{}
+	}
+	if name == "" {
+		name, ok := p.names[kind][id]
+		if !ok {
+			return "", fmt.Errorf("unknown compressed name: %q", id)
+		}
+		return name, nil
+	}
+	p.names[kind][id] = name
+	return name, nil
+}
+
+func (p *callgrindParser) parseSpec(key, val string) error {
+	var kind string
+	switch key {
+	case "fl", "fi", "fe", "cfl", "cfi":
+		kind = "file"
+	case "fn", "cfn":
+		kind = "fn"
+	case "ob", "cob":
+		kind = "ob"
+	case "calls":
+		if p.callee == nil {
+			return errors.New("calls line without cfn line")
+		}
+		p.inCall = true
+		return nil
+	default:
+		// Jumps and unknown specifications are ignored.
+		return nil
+	}
+
+	name, err := p.name(kind, val)
+	if err != nil {
+		return err
+	}
+	switch key {
+	case "fl":
+		p.file, p.calleeFl = name, ""
+	case "fn":
+		p.fn = callgrindFunc{name: name, file: p.file}
+		p.objects[p.fn] = p.object
+		p.callee, p.calleeFl = nil, ""
+	case "cfl", "cfi":
+		p.calleeFl = name
+	case "cfn":
+		file := p.calleeFl
+		if file == "" {
+			file = p.file
+		}
+		p.callee = &callgrindFunc{name: name, file: file}
+		p.calleeObj = p.object
+	case "ob":
+		p.object = name
+	case "cob":
+		p.calleeObj = name
+	}
+	return nil
+}
+
+func (p *callgrindParser) parseCostLine(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < p.numCols {
+		return fmt.Errorf("bad cost line: %q", line)
+	}
+	if p.lastPos == nil {
+		p.lastPos = make([]int64, p.numCols)
+	}
+	for i := 0; i < p.numCols; i++ {
+		pos, err := parseCallgrindPosition(fields[i], p.lastPos[i])
+		if err != nil {
+			return err
+		}
+		p.lastPos[i] = pos
+	}
+	var lineNum int64
+	if p.lineCol >= 0 {
+		lineNum = p.lastPos[p.lineCol]
+	}
+
+	costs := make([]int64, len(p.events))
+	for i, f := range fields[p.numCols:] {
+		if i >= len(costs) {
+			break
+		}
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad cost: %q", f)
+		}
+		costs[i] = v
+	}
+
+	if p.inCall {
+		callee := *p.callee
+		if _, ok := p.objects[callee]; !ok {
+			p.objects[callee] = p.calleeObj
+		}
+		if callee != p.fn {
+			p.callers[callee] = append(p.callers[callee], &callgrindEdge{
+				caller: callgrindLoc{fn: p.fn, line: lineNum},
+				cost:   costs,
+			})
+		}
+		p.inCall, p.callee, p.calleeFl = false, nil, ""
+		return nil
+	}
+
+	loc := callgrindLoc{fn: p.fn, line: lineNum}
+	if p.self[loc] == nil {
+		p.self[loc] = make([]int64, len(p.events))
+	}
+	for i, v := range costs {
+		p.self[loc][i] += v
+	}
+	return nil
+}
+
+// parseCallgrindPosition parses an absolute or relative position.
+func parseCallgrindPosition(s string, last int64) (int64, error) {
+	var (
+		v   int64
+		err error
+	)
+	switch {
+	case s == "*":
+		return last, nil
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		v, err = strconv.ParseInt(s, 10, 64)
+		v += last
+	case strings.HasPrefix(s, "0x"):
+		var u uint64
+		u, err = strconv.ParseUint(s[2:], 16, 64)
+		v = int64(u)
+	default:
+		v, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bad position: %q", s)
+	}
+	return v, nil
+}
+
+// callgrindMaxDepth limits the depth of reconstructed stacks.
+const callgrindMaxDepth = 128
+
+// profile builds a pprof profile from the parsed costs. Callgrind files only
+// contain the callers of each function, not full stacks. Stacks are therefore
+// reconstructed by distributing the self cost of each function over its
+// callers in proportion to the inclusive cost of their calls. This is exact
+// for call graphs that are trees, and an approximation otherwise.
+func (p *callgrindParser) profile() *profile.Profile {
+	// The first event is the one callgrind tools show by default, so it
+	// becomes the period type.
+	prof := &profile.Profile{
+		SampleType: p.events,
+		PeriodType: &profile.ValueType{Type: p.events[0].Type, Unit: p.events[0].Unit},
+		Period:     1,
+	}
+	var (
+		n         = len(p.events)
+		mappings  = map[string]*profile.Mapping{}
+		functions = map[callgrindFunc]*profile.Function{}
+		locations = map[callgrindLoc]*profile.Location{}
+		inclusive = map[callgrindFunc][]int64{}
+		samples   = map[string]*profile.Sample{}
+		values    = map[*profile.Sample][]float64{}
+	)
+
+	location := func(cl callgrindLoc) *profile.Location {
+		if loc := locations[cl]; loc != nil {
+			return loc
+		}
+		fn := functions[cl.fn]
+		if fn == nil {
+			fn = &profile.Function{ID: uint64(len(prof.Function) + 1), Name: cl.fn.name, Filename: cl.fn.file}
+			functions[cl.fn] = fn
+			prof.Function = append(prof.Function, fn)
+		}
+		obj := p.objects[cl.fn]
+		m := mappings[obj]
+		if m == nil {
+			m = &profile.Mapping{ID: uint64(len(prof.Mapping) + 1), File: obj, HasFunctions: true}
+			mappings[obj] = m
+			prof.Mapping = append(prof.Mapping, m)
+		}
+		loc := &profile.Location{
+			ID:      uint64(len(prof.Location) + 1),
+			Mapping: m,
+			Line:    []profile.Line{{Function: fn, Line: cl.line}},
+		}
+		locations[cl] = loc
+		prof.Location = append(prof.Location, loc)
+		return loc
+	}
+	emit := func(stack []callgrindLoc, event int, value float64) {
+		var key strings.Builder
+		for _, cl := range stack {
+			fmt.Fprintf(&key, "%s\x00%s\x00%d\x00", cl.fn.name, cl.fn.file, cl.line)
+		}
+		s := samples[key.String()]
+		if s == nil {
+			s = &profile.Sample{Value: make([]int64, n)}
+			for _, cl := range stack {
+				s.Location = append(s.Location, location(cl))
+			}
+			samples[key.String()] = s
+			values[s] = make([]float64, n)
+			prof.Sample = append(prof.Sample, s)
+		}
+		values[s][event] += value
+	}
+
+	// The inclusive cost of a function is its self cost plus the cost of all
+	// calls it makes.
+	incl := func(fn callgrindFunc) []int64 {
+		if inclusive[fn] == nil {
+			inclusive[fn] = make([]int64, n)
+		}
+		return inclusive[fn]
+	}
+	var totals = make([]float64, n)
+	for loc, cost := range p.self {
+		for i, v := range cost {
+			incl(loc.fn)[i] += v
+			totals[i] += float64(v)
+		}
+	}
+	for _, edges := range p.callers {
+		for _, e := range edges {
+			for i, v := range e.cost {
+				incl(e.caller.fn)[i] += v
+			}
+		}
+	}
+
+	var attribute func(stack []callgrindLoc, event int, value float64)
+	attribute = func(stack []callgrindLoc, event int, value float64) {
+		fn := stack[len(stack)-1].fn
+		if len(stack) >= callgrindMaxDepth || value < totals[event]*1e-4 {
+			emit(stack, event, value)
+			return
+		}
+		var edges []*callgrindEdge
+		var in float64
+	edgeLoop:
+		for _, e := range p.callers[fn] {
+			for _, cl := range stack {
+				if cl.fn == e.caller.fn {
+					continue edgeLoop
+				}
+			}
+			if e.cost[event] > 0 {
+				edges = append(edges, e)
+				in += float64(e.cost[event])
+			}
+		}
+		total := math.Max(float64(incl(fn)[event]), in)
+		if total <= 0 {
+			emit(stack, event, value)
+			return
+		}
+		if rest := value * (total - in) / total; rest > 0 {
+			emit(stack, event, rest)
+		}
+		for _, e := range edges {
+			next := append(append([]callgrindLoc(nil), stack...), e.caller)
+			attribute(next, event, value*float64(e.cost[event])/total)
+		}
+	}
+
+	locs := make([]callgrindLoc, 0, len(p.self))
+	for loc := range p.self {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].fn != locs[j].fn {
+			return callgrindFuncLess(locs[i].fn, locs[j].fn)
+		}
+		return locs[i].line < locs[j].line
+	})
+	for _, loc := range locs {
+		for event, v := range p.self[loc] {
+			if v != 0 {
+				attribute([]callgrindLoc{loc}, event, float64(v))
+			}
+		}
+	}
+
+	var nonZero []*profile.Sample
+	for _, s := range prof.Sample {
+		keep := false
+		for i, v := range values[s] {
+			s.Value[i] = int64(math.Round(v))
+			keep = keep || s.Value[i] != 0
+		}
+		if keep {
+			nonZero = append(nonZero, s)
+		}
+	}
+	prof.Sample = nonZero
+	return prof
+}
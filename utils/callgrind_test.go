@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallgrind(t *testing.T) {
+	t.Run("roundtrip", func(t *testing.T) {
+		in := foldedProfile(t, `samples/count cpu/nanoseconds @extended
+main@main.go:3;foo@foo.go:7 5 50
+main@main.go:4;bar@main.go:9 3 30
+main@main.go:1 1 10
+`)
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Callgrind{Input: in, Output: out}).Execute(context.Background()))
+		require.Equal(t, strings.TrimSpace(`
+# callgrind format
+version: 1
+creator: pprofutils
+positions: line
+event: samples : samples/count
+event: cpu : cpu/nanoseconds
+events: samples cpu
+summary: 9 90
+
+fl=(1) foo.go
+fn=(1) foo
+7 5 50
+
+fl=(2) main.go
+fn=(2) bar
+9 3 30
+
+fl=(2)
+fn=(3) main
+1 1 10
+cfl=(1)
+cfn=(1)
+calls=1 0
+3 5 50
+cfn=(2)
+calls=1 0
+4 3 30
+
+totals: 9 90
+`), strings.TrimSpace(out.String()))
+
+		pprofOut := &bytes.Buffer{}
+		require.NoError(t, (&Callgrind{Input: out.Bytes(), Output: pprofOut}).Execute(context.Background()))
+		prof, err := profile.Parse(pprofOut)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count cpu/nanoseconds", formatSampleTypes(prof))
+		require.Equal(t, &profile.ValueType{Type: "samples", Unit: "count"}, prof.PeriodType)
+		require.Equal(t, map[string]int64{"main;foo": 5, "main;bar": 3, "main": 1}, sampleValues(prof, "", 0))
+		require.Equal(t, map[string]int64{"main;foo": 50, "main;bar": 30, "main": 10}, sampleValues(prof, "", 1))
+	})
+
+	t.Run("valgrind", func(t *testing.T) {
+		in := `# callgrind format
+version: 1
+positions: instr line
+events: Ir
+
+ob=(1) /bin/app
+fl=(1) app.c
+fn=(1) main
+0x10 3 2
++4 +1 1
+cfn=(2) work
+calls=2 0x20 10
+* * 12
+
+fn=(2)
+0x20 10 12
+`
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Callgrind{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, "Ir/count", formatSampleTypes(prof))
+		require.Equal(t, &profile.ValueType{Type: "Ir", Unit: "count"}, prof.PeriodType)
+		require.Equal(t, int64(1), prof.Period)
+		require.Equal(t, map[string]int64{"main": 3, "main;work": 12}, sampleValues(prof, "", 0))
+		require.Equal(t, "/bin/app", prof.Mapping[0].File)
+		for _, s := range prof.Sample {
+			if len(s.Location) == 2 {
+				require.Equal(t, int64(4), s.Location[1].Line[0].Line)
+			}
+		}
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		err := (&Callgrind{Input: []byte("hello"), Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor callgrind")
+	})
+}
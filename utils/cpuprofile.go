@@ -0,0 +1,318 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/pprof/profile"
+)
+
+// CPUProfile converts V8 .cpuprofile files as produced by Node.js and Chrome
+// DevTools to pprof and vice versa. The direction is determined by the input
+// format.
+type CPUProfile struct {
+	Input  []byte
+	Output io.Writer
+}
+
+func (c *CPUProfile) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(c.Input)
+	if err == nil {
+		return toCPUProfile(prof, c.Output)
+	}
+
+	var cp v8CPUProfile
+	if err := json.Unmarshal(c.Input, &cp); err != nil || len(cp.Nodes) == 0 {
+		return errors.New("input format is neither pprof nor cpuprofile json")
+	}
+	prof, err = fromCPUProfile(&cp)
+	if err != nil {
+		return err
+	}
+	return prof.Write(c.Output)
+}
+
+// v8CPUProfile is the format of .cpuprofile files. All times are in
+// microseconds.
+type v8CPUProfile struct {
+	Nodes      []v8Node `json:"nodes"`
+	StartTime  int64    `json:"startTime"`
+	EndTime    int64    `json:"endTime"`
+	Samples    []int    `json:"samples"`
+	TimeDeltas []int64  `json:"timeDeltas"`
+}
+
+type v8Node struct {
+	ID        int         `json:"id"`
+	CallFrame v8CallFrame `json:"callFrame"`
+	HitCount  int64       `json:"hitCount"`
+	Children  []int       `json:"children,omitempty"`
+}
+
+// v8CallFrame describes a function. Line and column numbers are zero-based
+// and -1 if unknown.
+type v8CallFrame struct {
+	FunctionName string `json:"functionName"`
+	ScriptID     string `json:"scriptId"`
+	URL          string `json:"url"`
+	LineNumber   int64  `json:"lineNumber"`
+	ColumnNumber int64  `json:"columnNumber"`
+}
+
+func fromCPUProfile(cp *v8CPUProfile) (*profile.Profile, error) {
+	var (
+		prof = &profile.Profile{
+			SampleType: []*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "cpu", Unit: "nanoseconds"},
+			},
+			PeriodType:    &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			TimeNanos:     cp.StartTime * 1000,
+			DurationNanos: (cp.EndTime - cp.StartTime) * 1000,
+		}
+		m         = &profile.Mapping{ID: 1, HasFunctions: true}
+		nodes     = map[int]*v8Node{}
+		parents   = map[int]int{}
+		functions = map[v8CallFrame]*profile.Function{}
+		locations = map[v8CallFrame]*profile.Location{}
+		samples   = map[int]*profile.Sample{}
+	)
+	prof.Mapping = []*profile.Mapping{m}
+
+	for i := range cp.Nodes {
+		n := &cp.Nodes[i]
+		nodes[n.ID] = n
+		for _, child := range n.Children {
+			parents[child] = n.ID
+		}
+	}
+
+	// location returns the location for the call frame of the given node or
+	// nil if the node is the artificial root node. Nodes with the same call
+	// frame under different parents share their location.
+	location := func(n *v8Node) *profile.Location {
+		if n.CallFrame.FunctionName == "(root)" {
+			return nil
+		} else if loc := locations[n.CallFrame]; loc != nil {
+			return loc
+		}
+
+		fnKey := n.CallFrame
+		fnKey.LineNumber, fnKey.ColumnNumber = 0, 0
+		fn := functions[fnKey]
+		if fn == nil {
+			name := n.CallFrame.FunctionName
+			if name == "" {
+				name = "(anonymous)"
+			}
+			fn = &profile.Function{
+				ID:       uint64(len(prof.Function) + 1),
+				Name:     name,
+				Filename: n.CallFrame.URL,
+			}
+			functions[fnKey] = fn
+			prof.Function = append(prof.Function, fn)
+		}
+		loc := &profile.Location{
+			ID:      uint64(len(prof.Location) + 1),
+			Mapping: m,
+			Line: []profile.Line{{
+				Function: fn,
+				Line:     n.CallFrame.LineNumber + 1,
+				Column:   n.CallFrame.ColumnNumber + 1,
+			}},
+		}
+		locations[n.CallFrame] = loc
+		prof.Location = append(prof.Location, loc)
+		return loc
+	}
+
+	// sample returns the sample for the stack ending in the given node.
+	sample := func(id int) (*profile.Sample, error) {
+		if s := samples[id]; s != nil {
+			return s, nil
+		}
+		s := &profile.Sample{Value: make([]int64, 2)}
+		for cur, ok := id, true; ok; cur, ok = parents[cur] {
+			n := nodes[cur]
+			if n == nil {
+				return nil, fmt.Errorf("unknown node id: %d", cur)
+			} else if len(s.Location) > len(nodes) {
+				return nil, errors.New("cycle in node tree")
+			}
+			if loc := location(n); loc != nil {
+				s.Location = append(s.Location, loc)
+			}
+		}
+		samples[id] = s
+		prof.Sample = append(prof.Sample, s)
+		return s, nil
+	}
+
+	if len(cp.Samples) > 0 {
+		if len(cp.TimeDeltas) != len(cp.Samples) {
+			return nil, fmt.Errorf("got %d samples but %d timeDeltas", len(cp.Samples), len(cp.TimeDeltas))
+		}
+		// The time delta of a sample is the time since the previous sample,
+		// so the time spent in a sample is given by the next delta.
+		ts := cp.StartTime
+		for i, id := range cp.Samples {
+			ts += cp.TimeDeltas[i]
+			var duration int64
+			if i+1 < len(cp.TimeDeltas) {
+				duration = cp.TimeDeltas[i+1]
+			} else if cp.EndTime > ts {
+				duration = cp.EndTime - ts
+			}
+			s, err := sample(id)
+			if err != nil {
+				return nil, err
+			}
+			s.Value[0]++
+			s.Value[1] += duration * 1000
+		}
+		prof.Period = (cp.EndTime - cp.StartTime) * 1000 / int64(len(cp.Samples))
+	} else {
+		// Some tools only populate the hit counts of the nodes.
+		var hits int64
+		for _, n := range cp.Nodes {
+			hits += n.HitCount
+		}
+		if hits > 0 {
+			prof.Period = (cp.EndTime - cp.StartTime) * 1000 / hits
+		}
+		for _, n := range cp.Nodes {
+			if n.HitCount == 0 {
+				continue
+			}
+			s, err := sample(n.ID)
+			if err != nil {
+				return nil, err
+			}
+			s.Value[0] += n.HitCount
+			s.Value[1] += n.HitCount * prof.Period
+		}
+	}
+	return prof, prof.CheckValid()
+}
+
+// cpuProfileMaxSamples limits the number of samples written by toCPUProfile.
+// It can be exceeded by at most one sample per pprof sample.
+const cpuProfileMaxSamples = 1 << 20
+
+// toCPUProfile writes prof in the .cpuprofile format. The format has no
+// counts, so each pprof sample is expanded into one entry per count. If the
+// counts add up to more than cpuProfileMaxSamples, the number of entries of
+// each pprof sample is scaled down proportionally, but is at least one. The
+// duration of a pprof sample is split evenly among its entries, and the
+// hitCount of each node holds the full count.
+func toCPUProfile(prof *profile.Profile, out io.Writer) error {
+	countIdx := sampleTypeIndex(prof, profile.ValueType{Type: "samples", Unit: "count"})
+	if countIdx < 0 {
+		return errors.New("profile lacks a samples/count sample type")
+	}
+	timeIdx := -1
+	for i, st := range prof.SampleType {
+		if st.Unit == "nanoseconds" {
+			timeIdx = i
+			break
+		}
+	}
+
+	type nodeKey struct {
+		parent int
+		frame  v8CallFrame
+	}
+	var (
+		cp = &v8CPUProfile{
+			Nodes:     []v8Node{{ID: 1, CallFrame: v8CallFrame{FunctionName: "(root)", ScriptID: "0", LineNumber: -1, ColumnNumber: -1}}},
+			StartTime: prof.TimeNanos / 1000,
+		}
+		nodeIdx   = map[nodeKey]int{}
+		scripts   = map[string]string{"": "0"}
+		durations []int64
+		counts    float64
+	)
+	for _, s := range prof.Sample {
+		if count := s.Value[countIdx]; count > 0 {
+			counts += float64(count)
+		}
+	}
+
+	for _, s := range prof.Sample {
+		count := s.Value[countIdx]
+		if count <= 0 {
+			continue
+		}
+
+		parent := 0
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			loc := s.Location[i]
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				line := loc.Line[j]
+				script, ok := scripts[line.Function.Filename]
+				if !ok {
+					script = fmt.Sprint(len(scripts))
+					scripts[line.Function.Filename] = script
+				}
+				key := nodeKey{parent: parent, frame: v8CallFrame{
+					FunctionName: line.Function.Name,
+					ScriptID:     script,
+					URL:          line.Function.Filename,
+					LineNumber:   line.Line - 1,
+					ColumnNumber: line.Column - 1,
+				}}
+				idx, ok := nodeIdx[key]
+				if !ok {
+					idx = len(cp.Nodes)
+					nodeIdx[key] = idx
+					cp.Nodes = append(cp.Nodes, v8Node{ID: idx + 1, CallFrame: key.frame})
+					cp.Nodes[parent].Children = append(cp.Nodes[parent].Children, idx+1)
+				}
+				parent = idx
+			}
+		}
+
+		cp.Nodes[parent].HitCount += count
+		var total int64
+		if timeIdx >= 0 {
+			total = s.Value[timeIdx] / 1000
+		} else if prof.PeriodType != nil && prof.PeriodType.Unit == "nanoseconds" {
+			total = count * prof.Period / 1000
+		}
+		entries := count
+		if counts > cpuProfileMaxSamples {
+			entries = int64(float64(count) / counts * cpuProfileMaxSamples)
+			if entries < 1 {
+				entries = 1
+			}
+		}
+		for i := int64(0); i < entries; i++ {
+			duration := total / entries
+			if i < total%entries {
+				duration++
+			}
+			cp.Samples = append(cp.Samples, parent+1)
+			durations = append(durations, duration)
+		}
+	}
+
+	// The time delta of a sample is the time since the previous sample, so
+	// the duration of each sample goes into the delta of the next sample.
+	cp.Samples = append([]int{}, cp.Samples...)
+	cp.TimeDeltas = []int64{}
+	cp.EndTime = cp.StartTime
+	for i, d := range durations {
+		if i == 0 {
+			cp.TimeDeltas = append(cp.TimeDeltas, 0)
+		}
+		if i+1 < len(durations) {
+			cp.TimeDeltas = append(cp.TimeDeltas, d)
+		}
+		cp.EndTime += d
+	}
+	return json.NewEncoder(out).Encode(cp)
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUProfile(t *testing.T) {
+	t.Run("to pprof", func(t *testing.T) {
+		in := `{
+  "nodes": [
+    {"id": 1, "callFrame": {"functionName": "(root)", "scriptId": "0", "url": "", "lineNumber": -1, "columnNumber": -1}, "children": [2]},
+    {"id": 2, "callFrame": {"functionName": "main", "scriptId": "1", "url": "file:///app.js", "lineNumber": 0, "columnNumber": 0}, "children": [3]},
+    {"id": 3, "callFrame": {"functionName": "foo", "scriptId": "1", "url": "file:///app.js", "lineNumber": 9, "columnNumber": 4}}
+  ],
+  "startTime": 1000,
+  "endTime": 1100,
+  "samples": [3, 2, 3],
+  "timeDeltas": [0, 20, 30]
+}`
+		out := &bytes.Buffer{}
+		require.NoError(t, (&CPUProfile{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count cpu/nanoseconds", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{"main;foo": 2, "main": 1}, sampleValues(prof, "", 0))
+		require.Equal(t, map[string]int64{"main;foo": 70000, "main": 30000}, sampleValues(prof, "", 1))
+		require.Equal(t, int64(100000), prof.DurationNanos)
+
+		for _, loc := range prof.Location {
+			if line := loc.Line[0]; line.Function.Name == "foo" {
+				require.Equal(t, "file:///app.js", line.Function.Filename)
+				require.Equal(t, int64(10), line.Line)
+				require.Equal(t, int64(5), line.Column)
+			}
+		}
+	})
+
+	t.Run("shared call frames", func(t *testing.T) {
+		in := `{
+  "nodes": [
+    {"id": 1, "callFrame": {"functionName": "(root)", "scriptId": "0", "url": "", "lineNumber": -1, "columnNumber": -1}, "children": [2, 3]},
+    {"id": 2, "callFrame": {"functionName": "a", "scriptId": "1", "url": "file:///app.js", "lineNumber": 0, "columnNumber": 0}, "children": [4]},
+    {"id": 3, "callFrame": {"functionName": "b", "scriptId": "1", "url": "file:///app.js", "lineNumber": 5, "columnNumber": 0}, "children": [5]},
+    {"id": 4, "callFrame": {"functionName": "util", "scriptId": "1", "url": "file:///app.js", "lineNumber": 9, "columnNumber": 4}},
+    {"id": 5, "callFrame": {"functionName": "util", "scriptId": "1", "url": "file:///app.js", "lineNumber": 9, "columnNumber": 4}}
+  ],
+  "startTime": 1000,
+  "endTime": 1100,
+  "samples": [4, 5],
+  "timeDeltas": [0, 50]
+}`
+		out := &bytes.Buffer{}
+		require.NoError(t, (&CPUProfile{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{"a;util": 1, "b;util": 1}, sampleValues(prof, "", 0))
+		require.Len(t, prof.Location, 3)
+		require.Same(t, prof.Sample[0].Location[0], prof.Sample[1].Location[0])
+	})
+
+	t.Run("roundtrip", func(t *testing.T) {
+		in := foldedProfile(t, "samples/count cpu/nanoseconds\nmain;foo 2 30000\nmain;bar 1 5000")
+
+		out := &bytes.Buffer{}
+		require.NoError(t, (&CPUProfile{Input: in, Output: out}).Execute(context.Background()))
+
+		var cp v8CPUProfile
+		require.NoError(t, json.Unmarshal(out.Bytes(), &cp))
+		require.Equal(t, "(root)", cp.Nodes[0].CallFrame.FunctionName)
+		require.Len(t, cp.Samples, 3)
+		require.Len(t, cp.TimeDeltas, 3)
+		require.Equal(t, cp.StartTime+35, cp.EndTime)
+
+		pprofOut := &bytes.Buffer{}
+		require.NoError(t, (&CPUProfile{Input: out.Bytes(), Output: pprofOut}).Execute(context.Background()))
+		prof, err := profile.Parse(pprofOut)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{"main;foo": 2, "main;bar": 1}, sampleValues(prof, "", 0))
+		require.Equal(t, map[string]int64{"main;foo": 30000, "main;bar": 5000}, sampleValues(prof, "", 1))
+	})
+
+	t.Run("large counts", func(t *testing.T) {
+		in := foldedProfile(t, "samples/count cpu/nanoseconds\nmain;foo 1099511627776 1000000\nmain;bar 1 1000")
+
+		out := &bytes.Buffer{}
+		require.NoError(t, (&CPUProfile{Input: in, Output: out}).Execute(context.Background()))
+
+		var cp v8CPUProfile
+		require.NoError(t, json.Unmarshal(out.Bytes(), &cp))
+		require.Len(t, cp.Samples, cpuProfileMaxSamples)
+		require.Equal(t, cp.StartTime+1001, cp.EndTime)
+		hits := map[string]int64{}
+		for _, n := range cp.Nodes {
+			hits[n.CallFrame.FunctionName] = n.HitCount
+		}
+		require.Equal(t, int64(1099511627776), hits["foo"])
+		require.Equal(t, int64(1), hits["bar"])
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		err := (&CPUProfile{Input: []byte("{}"), Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor cpuprofile json")
+	})
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+)
+
+// Delta subtracts the values of the Old profile from the New profile. This is
+// useful for turning cumulative heap, mutex or block profiles into profiles
+// that only cover the interval between the two snapshots.
+type Delta struct {
+	Old    []byte
+	New    []byte
+	Output io.Writer
+	// SampleTypes is a space separated list of type/unit sample types to
+	// compute the delta for. Other sample types retain their value from the New
+	// profile. If empty, the delta is computed for all sample types.
+	SampleTypes string
+}
+
+func (d *Delta) Execute(ctx context.Context) error {
+	oldProf, err := profile.ParseData(d.Old)
+	if err != nil {
+		return fmt.Errorf("old profile: %w", err)
+	}
+	newProf, err := profile.ParseData(d.New)
+	if err != nil {
+		return fmt.Errorf("new profile: %w", err)
+	}
+
+	var conf legacy.Delta
+	for _, st := range strings.Fields(d.SampleTypes) {
+		vt, err := parseValueType(st)
+		if err != nil {
+			return err
+		}
+		conf.SampleTypes = append(conf.SampleTypes, legacy.ValueType{Type: vt.Type, Unit: vt.Unit})
+	}
+
+	delta, err := conf.Convert(oldProf, newProf)
+	if err != nil {
+		return err
+	}
+	return delta.Write(d.Output)
+}
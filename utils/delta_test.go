@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelta(t *testing.T) {
+	var (
+		oldProf = foldedProfile(t, "alloc_objects/count inuse_objects/count\nmain;foo 2 1\nmain;bar 3 3")
+		newProf = foldedProfile(t, "alloc_objects/count inuse_objects/count\nmain;foo 5 2\nmain;bar 3 4")
+	)
+
+	tests := []struct {
+		name        string
+		sampleTypes string
+		want        []map[string]int64
+	}{
+		{
+			name: "all sample types",
+			want: []map[string]int64{
+				{"main;foo": 3, "main;bar": 0},
+				{"main;foo": 1, "main;bar": 1},
+			},
+		},
+		{
+			name:        "selected sample types",
+			sampleTypes: "alloc_objects/count",
+			want: []map[string]int64{
+				{"main;foo": 3, "main;bar": 0},
+				{"main;foo": 2, "main;bar": 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldCopy := append([]byte(nil), oldProf...)
+			newCopy := append([]byte(nil), newProf...)
+			out := &bytes.Buffer{}
+			d := &Delta{Old: oldProf, New: newProf, Output: out, SampleTypes: tt.sampleTypes}
+			require.NoError(t, d.Execute(context.Background()))
+			require.Equal(t, oldCopy, oldProf)
+			require.Equal(t, newCopy, newProf)
+
+			prof, err := profile.ParseData(out.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, "alloc_objects/count inuse_objects/count", formatSampleTypes(prof))
+			for i, want := range tt.want {
+				require.Equal(t, want, sampleValues(prof, "", i))
+			}
+		})
+	}
+
+	t.Run("zero samples dropped", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Delta{Old: oldProf, New: oldProf, Output: out}).Execute(context.Background()))
+		prof, err := profile.ParseData(out.Bytes())
+		require.NoError(t, err)
+		require.Empty(t, prof.Sample)
+	})
+
+	t.Run("missing sample type", func(t *testing.T) {
+		d := &Delta{Old: oldProf, New: newProf, Output: &bytes.Buffer{}, SampleTypes: "alloc_space/bytes"}
+		require.EqualError(t, d.Execute(context.Background()), "sample type not found in profile: alloc_space/bytes")
+	})
+
+	t.Run("missing sample type in new profile", func(t *testing.T) {
+		other := foldedProfile(t, "inuse_objects/count\nmain;foo 1")
+		d := &Delta{Old: oldProf, New: other, Output: &bytes.Buffer{}, SampleTypes: "alloc_objects/count"}
+		require.EqualError(t, d.Execute(context.Background()), "sample type not found in new profile: alloc_objects/count")
+	})
+
+	t.Run("time range", func(t *testing.T) {
+		withTime := func(data []byte, timeNanos, durationNanos int64) []byte {
+			prof, err := profile.ParseData(data)
+			require.NoError(t, err)
+			prof.TimeNanos = timeNanos
+			prof.DurationNanos = durationNanos
+			buf := &bytes.Buffer{}
+			require.NoError(t, prof.Write(buf))
+			return buf.Bytes()
+		}
+
+		out := &bytes.Buffer{}
+		d := &Delta{Old: withTime(oldProf, 10e9, 1e9), New: withTime(newProf, 25e9, 1e9), Output: out}
+		require.NoError(t, d.Execute(context.Background()))
+		prof, err := profile.ParseData(out.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, int64(25e9), prof.TimeNanos)
+		require.Equal(t, int64(15e9), prof.DurationNanos)
+
+		out.Reset()
+		d = &Delta{Old: withTime(oldProf, 0, 0), New: withTime(newProf, 0, 3e9), Output: out}
+		require.NoError(t, d.Execute(context.Background()))
+		prof, err = profile.ParseData(out.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, int64(3e9), prof.DurationNanos)
+	})
+
+	t.Run("bad sample type", func(t *testing.T) {
+		d := &Delta{Old: oldProf, New: newProf, Output: &bytes.Buffer{}, SampleTypes: "alloc_objects"}
+		require.EqualError(t, d.Execute(context.Background()), `bad sample type: "alloc_objects": must be type/unit`)
+	})
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+)
+
+// Diff creates a comparison profile by subtracting the values of the Base
+// profile from the New profile like go tool pprof -diff_base. The negated base
+// samples are tagged with a pprof::base label, which pprof uses to exclude
+// them from the totals of the profile.
+type Diff struct {
+	Base   []byte
+	New    []byte
+	Output io.Writer
+	// Normalize scales the base profile so that its totals match the totals of
+	// the new profile before computing the diff.
+	Normalize bool
+	// Threshold drops stacks if the absolute change of all their values is
+	// less than the given value.
+	Threshold int
+}
+
+func (d *Diff) Execute(ctx context.Context) error {
+	base, err := profile.ParseData(d.Base)
+	if err != nil {
+		return fmt.Errorf("base profile: %w", err)
+	}
+	newProf, err := profile.ParseData(d.New)
+	if err != nil {
+		return fmt.Errorf("new profile: %w", err)
+	}
+
+	if d.Normalize {
+		if err := base.Normalize(newProf); err != nil {
+			return err
+		}
+	}
+
+	base.Scale(-1)
+	for _, s := range base.Sample {
+		if s.Label == nil {
+			s.Label = map[string][]string{}
+		}
+		s.Label[diffBaseLabel] = []string{"true"}
+	}
+	diff, err := profile.Merge([]*profile.Profile{base, newProf})
+	if err != nil {
+		return err
+	}
+	// Merge sums up the durations, but the diff describes the new profile.
+	diff.TimeNanos = newProf.TimeNanos
+	diff.DurationNanos = newProf.DurationNanos
+
+	if d.Threshold > 0 {
+		// The change of a stack is the sum of its base and new samples.
+		changes := map[string][]int64{}
+		for _, s := range diff.Sample {
+			key := diffStackKey(s)
+			if changes[key] == nil {
+				changes[key] = make([]int64, len(s.Value))
+			}
+			for i, v := range s.Value {
+				changes[key][i] += v
+			}
+		}
+		var keep []*profile.Sample
+		for _, s := range diff.Sample {
+			for _, v := range changes[diffStackKey(s)] {
+				if abs(v) >= int64(d.Threshold) {
+					keep = append(keep, s)
+					break
+				}
+			}
+		}
+		diff.Sample = keep
+		diff = diff.Compact()
+	}
+	return diff.Write(d.Output)
+}
+
+// diffBaseLabel is the label pprof uses to tag the samples of the base
+// profile of a diff.
+const diffBaseLabel = "pprof::base"
+
+// diffStackKey returns a key identifying the stack and labels of s, ignoring
+// the diffBaseLabel.
+func diffStackKey(s *profile.Sample) string {
+	var b strings.Builder
+	for _, loc := range s.Location {
+		fmt.Fprintf(&b, "%d;", loc.ID)
+	}
+	for _, key := range legacy.SortedKeys(s.Label) {
+		if key != diffBaseLabel {
+			fmt.Fprintf(&b, "|%s=%q", key, s.Label[key])
+		}
+	}
+	for _, key := range legacy.SortedKeys(s.NumLabel) {
+		fmt.Fprintf(&b, "|%s=%v%q", key, s.NumLabel[key], s.NumUnit[key])
+	}
+	return b.String()
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	var (
+		base = foldedProfile(t, "main;foo 10\nmain;bar 10\nmain;baz 5")
+		curr = foldedProfile(t, "main;foo 30\nmain;bar 11\nmain;qux 9")
+	)
+
+	for _, tc := range []struct {
+		name string
+		diff Diff
+		want map[string]int64
+	}{
+		{
+			name: "default",
+			want: map[string]int64{"main;foo": 20, "main;bar": 1, "main;baz": -5, "main;qux": 9},
+		},
+		{
+			name: "threshold",
+			diff: Diff{Threshold: 5},
+			want: map[string]int64{"main;foo": 20, "main;baz": -5, "main;qux": 9},
+		},
+		{
+			name: "normalize",
+			diff: Diff{Normalize: true},
+			want: map[string]int64{"main;foo": 10, "main;bar": -9, "main;baz": -10, "main;qux": 9},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			d := tc.diff
+			d.Base, d.New, d.Output = base, curr, buf
+			require.NoError(t, d.Execute(context.Background()))
+
+			prof, err := profile.Parse(buf)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, sampleValues(prof, "", 0))
+			for _, s := range prof.Sample {
+				if s.Value[0] < 0 {
+					require.Equal(t, []string{"true"}, s.Label["pprof::base"])
+				} else {
+					require.NotContains(t, s.Label, "pprof::base")
+				}
+			}
+		})
+	}
+
+	t.Run("base label", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, (&Diff{Base: base, New: curr, Output: buf, Threshold: 5}).Execute(context.Background()))
+		prof, err := profile.Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{
+			"true main;foo": -10,
+			" main;foo":     30,
+			"true main;baz": -5,
+			" main;qux":     9,
+		}, sampleValues(prof, "pprof::base", 0))
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		d := &Diff{Base: withMetadata(t, base, 1e9), New: withMetadata(t, curr, 2e9), Output: buf}
+		require.NoError(t, d.Execute(context.Background()))
+
+		prof, err := profile.Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count", formatSampleTypes(prof))
+		require.Equal(t, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}, prof.PeriodType)
+		require.Equal(t, int64(10000000), prof.Period)
+		require.Equal(t, int64(2e9), prof.DurationNanos)
+		require.Len(t, prof.Mapping, 1)
+		require.Equal(t, "/usr/bin/app", prof.Mapping[0].File)
+		require.Equal(t, "abc", prof.Mapping[0].BuildID)
+	})
+}
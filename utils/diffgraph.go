@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/pprof/profile"
+)
+
+// Diffgraph renders a differential flame graph SVG comparing the New profile
+// to the Base profile. The width of each frame is given by its value in the
+// new profile and its color by the change relative to the base profile.
+type Diffgraph struct {
+	Base   []byte
+	New    []byte
+	Output io.Writer
+	// SampleType is the type/unit of the sample type to render. Defaults to
+	// the default sample type of the new profile.
+	SampleType string
+	// Normalize scales the values of the selected sample type of the base
+	// profile so that its total matches the total of the new profile before
+	// comparing them.
+	Normalize bool
+	Inverted  bool
+	Title     string
+	// Width is the width of the SVG in pixels.
+	Width int
+}
+
+func (d *Diffgraph) Execute(ctx context.Context) error {
+	base, err := profile.ParseData(d.Base)
+	if err != nil {
+		return fmt.Errorf("base profile: %w", err)
+	}
+	newProf, err := profile.ParseData(d.New)
+	if err != nil {
+		return fmt.Errorf("new profile: %w", err)
+	}
+
+	newIdx, err := selectSampleType(newProf, d.SampleType)
+	if err != nil {
+		return fmt.Errorf("new profile: %w", err)
+	}
+	st := newProf.SampleType[newIdx]
+	baseIdx := sampleTypeIndex(base, *st)
+	if baseIdx < 0 {
+		return fmt.Errorf("base profile: sample type not found in profile: %s/%s", st.Type, st.Unit)
+	}
+	if d.Normalize {
+		// Only the rendered sample type is scaled, so the profiles may have
+		// different sample types otherwise.
+		var baseTotal, newTotal int64
+		for _, s := range base.Sample {
+			baseTotal += s.Value[baseIdx]
+		}
+		for _, s := range newProf.Sample {
+			newTotal += s.Value[newIdx]
+		}
+		if baseTotal != 0 {
+			ratios := make([]float64, len(base.SampleType))
+			for i := range ratios {
+				ratios[i] = 1
+			}
+			ratios[baseIdx] = float64(newTotal) / float64(baseTotal)
+			if err := base.ScaleN(ratios); err != nil {
+				return err
+			}
+		}
+	}
+
+	root := newFlameTree(newProf, func(s *profile.Sample) int64 { return s.Value[newIdx] })
+	addFlameBase(root, base, func(s *profile.Sample) int64 { return s.Value[baseIdx] })
+
+	var maxDelta int64
+	var walk func(n *flameNode)
+	walk = func(n *flameNode) {
+		if n != root && abs(n.Value-n.Base) > maxDelta {
+			maxDelta = abs(n.Value - n.Base)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	title := d.Title
+	if title == "" {
+		title = "Differential Flame Graph"
+	}
+	return renderFlamegraph(d.Output, root, flameOptions{
+		Title:    title,
+		Subtitle: st.Type + "/" + st.Unit + ", red is growth and blue is shrinkage",
+		Width:    d.Width,
+		Inverted: d.Inverted,
+		Color: func(n *flameNode) string {
+			return deltaColor(n.Value-n.Base, maxDelta)
+		},
+		Details: func(n *flameNode) string {
+			delta := n.Value - n.Base
+			sign := ""
+			if delta >= 0 {
+				sign = "+"
+			}
+			change := fmt.Sprintf("%s%.2f%%", sign, percent(delta, n.Base))
+			if n.Base == 0 {
+				change = "new"
+			}
+			return fmt.Sprintf("%s (new: %s, base: %s, delta: %s%s, %s)",
+				n.Name, formatValue(n.Value, st.Unit), formatValue(n.Base, st.Unit),
+				sign, formatValue(delta, st.Unit), change)
+		},
+	})
+}
+
+// addFlameBase adds the given value of each sample of the base profile to the
+// stack tree. Stacks that don't exist in the tree are added with a value of 0,
+// so they are not visible but still count towards the deltas of their parents.
+func addFlameBase(root *flameNode, base *profile.Profile, value func(s *profile.Sample) int64) {
+	for _, s := range base.Sample {
+		v := value(s)
+		if v <= 0 {
+			continue
+		}
+		root.Base += v
+		node := root
+		for _, name := range stackNames(s) {
+			node = node.child(name)
+			node.Base += v
+		}
+	}
+	root.sort()
+}
+
+// deltaColor returns a shade of red for positive deltas and blue for negative
+// deltas. The intensity is given by the ratio of delta to max.
+func deltaColor(delta, max int64) string {
+	if max == 0 || delta == 0 {
+		return "rgb(250,250,250)"
+	}
+	ratio := float64(abs(delta)) / float64(max)
+	fade := 250 - int(190*ratio)
+	if delta > 0 {
+		return fmt.Sprintf("rgb(250,%d,%d)", fade, fade)
+	}
+	return fmt.Sprintf("rgb(%d,%d,250)", fade, fade)
+}
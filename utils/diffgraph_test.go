@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffgraph(t *testing.T) {
+	var (
+		base = foldedProfile(t, "main;foo 10\nmain;bar 10\nmain;baz 5")
+		curr = foldedProfile(t, "main;foo 30\nmain;bar 5\nmain;qux 5")
+	)
+
+	t.Run("default", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Diffgraph{Base: base, New: curr, Output: out, Width: 1200}).Execute(context.Background()))
+		svg := out.String()
+		require.Contains(t, svg, "<title>foo (new: 30, base: 10, delta: +20, +200.00%)</title>")
+		require.Contains(t, svg, "<title>bar (new: 5, base: 10, delta: -5, -50.00%)</title>")
+		require.Contains(t, svg, "<title>qux (new: 5, base: 0, delta: +5, new)</title>")
+		// Frames that only exist in the base profile have no width.
+		require.NotContains(t, svg, `data-n="baz"`)
+		require.Contains(t, svg, deltaColor(20, 20))
+		require.Contains(t, svg, deltaColor(-5, 20))
+	})
+
+	t.Run("normalize", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Diffgraph{Base: base, New: curr, Output: out, Width: 1200, Normalize: true}).Execute(context.Background()))
+		require.Contains(t, out.String(), "<title>foo (new: 30, base: 16, delta: +14, +87.50%)</title>")
+	})
+
+	t.Run("normalize different sample types", func(t *testing.T) {
+		base := foldedProfile(t, "alloc/bytes samples/count\nmain;foo 7 10\nmain;bar 3 10\nmain;baz 100 5")
+		curr := foldedProfile(t, "samples/count cpu/nanoseconds\nmain;foo 30 1\nmain;bar 5 1\nmain;qux 5 1")
+		out := &bytes.Buffer{}
+		d := &Diffgraph{Base: base, New: curr, Output: out, Width: 1200, Normalize: true, SampleType: "samples/count"}
+		require.NoError(t, d.Execute(context.Background()))
+		require.Contains(t, out.String(), "<title>foo (new: 30, base: 16, delta: +14, +87.50%)</title>")
+	})
+
+	t.Run("missing sample type", func(t *testing.T) {
+		other := foldedProfile(t, "alloc/bytes\nmain 10")
+		err := (&Diffgraph{Base: base, New: other, Output: &bytes.Buffer{}, Width: 1200}).Execute(context.Background())
+		require.EqualError(t, err, "base profile: sample type not found in profile: alloc/bytes")
+	})
+}
@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Dot renders the call graph of a profile in the graphviz DOT format.
+type Dot struct {
+	Input  []byte
+	Output io.Writer
+	// SampleType is the type/unit of the sample type to render. Defaults to
+	// the default sample type of the profile.
+	SampleType string
+	// NodeFraction drops nodes with a cumulative value below this fraction of
+	// the total.
+	NodeFraction float64
+	// EdgeFraction drops edges with a value below this fraction of the total.
+	EdgeFraction float64
+	// ClusterLabel groups the graph into one cluster per value of this label.
+	ClusterLabel string
+}
+
+func (d *Dot) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(d.Input)
+	if err != nil {
+		return err
+	}
+	idx, err := selectSampleType(prof, d.SampleType)
+	if err != nil {
+		return err
+	}
+	st := prof.SampleType[idx]
+
+	var (
+		groups = map[string]*dotGraph{}
+		keys   []string
+		total  int64
+	)
+	for _, s := range prof.Sample {
+		v := s.Value[idx]
+		if v == 0 {
+			continue
+		}
+		total += abs(v)
+		key := ""
+		if d.ClusterLabel != "" {
+			key = d.ClusterLabel + "=N/A"
+			if vals := s.Label[d.ClusterLabel]; len(vals) > 0 {
+				key = d.ClusterLabel + "=" + strings.Join(vals, ",")
+			} else if vals := s.NumLabel[d.ClusterLabel]; len(vals) > 0 {
+				key = fmt.Sprintf("%s=%d", d.ClusterLabel, vals[0])
+			}
+		}
+		g := groups[key]
+		if g == nil {
+			g = newDotGraph()
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.add(stackNames(s), v)
+	}
+	sort.Strings(keys)
+
+	w := bufio.NewWriter(d.Output)
+	fmt.Fprintf(w, "digraph \"profile\" {\n")
+	fmt.Fprintf(w, "node [style=filled fillcolor=\"#f8f8f8\"]\n")
+
+	var (
+		nodeCutoff                 = int64(d.NodeFraction * float64(total))
+		edgeCutoff                 = int64(d.EdgeFraction * float64(total))
+		shown                      int64
+		droppedNodes, droppedEdges int
+		body                       strings.Builder
+		nextID                     = 1
+	)
+	for i, key := range keys {
+		g := groups[key]
+		nodes, edges, dn, de := g.prune(nodeCutoff, edgeCutoff)
+		droppedNodes, droppedEdges = droppedNodes+dn, droppedEdges+de
+		for _, n := range nodes {
+			shown += abs(n.flat)
+		}
+
+		indent := ""
+		if key != "" {
+			fmt.Fprintf(&body, "subgraph \"cluster_%d\" {\nlabel=%s\n", i, dotQuote(key))
+			indent = "  "
+		}
+		ids := map[string]string{}
+		var maxFlat int64
+		for _, n := range nodes {
+			if abs(n.flat) > maxFlat {
+				maxFlat = abs(n.flat)
+			}
+		}
+		for _, n := range nodes {
+			id := fmt.Sprintf("N%d", nextID)
+			nextID++
+			ids[n.name] = id
+			fontSize := 8.0
+			if maxFlat > 0 {
+				fontSize += math.Round(24 * math.Sqrt(float64(abs(n.flat))/float64(maxFlat)))
+			}
+			score := float64(abs(n.cum)) / float64(total)
+			fmt.Fprintf(&body, "%s%s [label=%s id=\"node%d\" fontsize=%g shape=box tooltip=%s color=%q fillcolor=%q]\n",
+				indent, id,
+				dotQuote(dotLabel(n, st.Unit, total)),
+				nextID-1,
+				fontSize,
+				dotQuote(fmt.Sprintf("%s (%s)", n.name, formatValue(n.cum, st.Unit))),
+				dotColor(score, false),
+				dotColor(score, true),
+			)
+		}
+		var maxEdge int64
+		for _, e := range edges {
+			if abs(e.value) > maxEdge {
+				maxEdge = abs(e.value)
+			}
+		}
+		for _, e := range edges {
+			score := float64(abs(e.value)) / float64(total)
+			penWidth := 1 + 4*float64(abs(e.value))/float64(maxEdge)
+			fmt.Fprintf(&body, "%s%s -> %s [label=%s weight=%d penwidth=%.2f color=%q tooltip=%s]\n",
+				indent, ids[e.caller], ids[e.callee],
+				dotQuote(" "+formatValue(e.value, st.Unit)),
+				1+int(100*score),
+				penWidth,
+				dotColor(score, false),
+				dotQuote(fmt.Sprintf("%s -> %s (%s)", e.caller, e.callee, formatValue(e.value, st.Unit))),
+			)
+		}
+		if key != "" {
+			fmt.Fprintf(&body, "}\n")
+		}
+	}
+
+	legend := []string{
+		fmt.Sprintf("Type: %s/%s", st.Type, st.Unit),
+		fmt.Sprintf("Showing nodes accounting for %s, %.2f%% of %s total", formatValue(shown, st.Unit), percent(shown, total), formatValue(total, st.Unit)),
+	}
+	if droppedNodes > 0 {
+		legend = append(legend, fmt.Sprintf("Dropped %d nodes (cum <= %s)", droppedNodes, formatValue(nodeCutoff, st.Unit)))
+	}
+	if droppedEdges > 0 {
+		legend = append(legend, fmt.Sprintf("Dropped %d edges (freq <= %s)", droppedEdges, formatValue(edgeCutoff, st.Unit)))
+	}
+	fmt.Fprintf(w, "subgraph \"cluster_legend\" {\nlabel=\"\"\n\"legend\" [shape=box fontsize=16 label=%s]\n}\n",
+		dotQuote(strings.Join(legend, "\\l")+"\\l"))
+	w.WriteString(body.String())
+	fmt.Fprintf(w, "}\n")
+	return w.Flush()
+}
+
+type dotNode struct {
+	name string
+	flat int64
+	cum  int64
+}
+
+type dotEdge struct {
+	caller string
+	callee string
+	value  int64
+}
+
+// dotGraph is the call graph of a set of samples. Nodes are functions.
+type dotGraph struct {
+	nodes map[string]*dotNode
+	edges map[[2]string]*dotEdge
+}
+
+func newDotGraph() *dotGraph {
+	return &dotGraph{nodes: map[string]*dotNode{}, edges: map[[2]string]*dotEdge{}}
+}
+
+// add adds a sample with the given stack, ordered from root to leaf, and
+// value to the graph. Recursive functions and calls are only counted once.
+func (g *dotGraph) add(stack []string, v int64) {
+	seenNodes := map[string]bool{}
+	seenEdges := map[[2]string]bool{}
+	for i, name := range stack {
+		n := g.nodes[name]
+		if n == nil {
+			n = &dotNode{name: name}
+			g.nodes[name] = n
+		}
+		if !seenNodes[name] {
+			seenNodes[name] = true
+			n.cum += v
+		}
+		if i == len(stack)-1 {
+			n.flat += v
+		}
+		if i == 0 {
+			continue
+		}
+		key := [2]string{stack[i-1], name}
+		e := g.edges[key]
+		if e == nil {
+			e = &dotEdge{caller: key[0], callee: key[1]}
+			g.edges[key] = e
+		}
+		if !seenEdges[key] {
+			seenEdges[key] = true
+			e.value += v
+		}
+	}
+}
+
+// prune returns the nodes and edges above the given cutoffs in a
+// deterministic order and the number of dropped nodes and edges. Edges of
+// dropped nodes are not counted as dropped.
+func (g *dotGraph) prune(nodeCutoff, edgeCutoff int64) ([]*dotNode, []*dotEdge, int, int) {
+	var nodes []*dotNode
+	for _, n := range g.nodes {
+		if abs(n.cum) > nodeCutoff {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if abs(nodes[i].cum) != abs(nodes[j].cum) {
+			return abs(nodes[i].cum) > abs(nodes[j].cum)
+		}
+		return nodes[i].name < nodes[j].name
+	})
+	kept := map[string]int{}
+	for i, n := range nodes {
+		kept[n.name] = i
+	}
+
+	var (
+		edges   []*dotEdge
+		dropped int
+	)
+	for _, e := range g.edges {
+		_, callerOK := kept[e.caller]
+		_, calleeOK := kept[e.callee]
+		if !callerOK || !calleeOK {
+			continue
+		} else if abs(e.value) > edgeCutoff {
+			edges = append(edges, e)
+		} else {
+			dropped++
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if kept[edges[i].caller] != kept[edges[j].caller] {
+			return kept[edges[i].caller] < kept[edges[j].caller]
+		}
+		return kept[edges[i].callee] < kept[edges[j].callee]
+	})
+	return nodes, edges, len(g.nodes) - len(nodes), dropped
+}
+
+// dotLabel returns the label of a node with its flat and cum values. The
+// package of the function is put on a separate line.
+func dotLabel(n *dotNode, unit string, total int64) string {
+	name := n.name
+	if pkg := packageName(name); pkg != "" && strings.HasPrefix(name, pkg+".") {
+		name = pkg + "\n" + name[len(pkg)+1:]
+	}
+	flat := fmt.Sprintf("%s (%.2f%%)", formatValue(n.flat, unit), percent(n.flat, total))
+	if n.flat == 0 {
+		flat = "0"
+	}
+	if n.flat == n.cum {
+		return fmt.Sprintf("%s\n%s", name, flat)
+	}
+	return fmt.Sprintf("%s\n%s\nof %s (%.2f%%)", name, flat, formatValue(n.cum, unit), percent(n.cum, total))
+}
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\l`, `\l`, `\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// dotColor returns a shade of red for the given score between 0 and 1. The
+// background variant is used for filling nodes.
+func dotColor(score float64, background bool) string {
+	score = math.Min(math.Max(score, 0), 1)
+	if background {
+		return fmt.Sprintf("#%02x%02x%02x", 248-int(11*score), 248-int(55*score), 248-int(55*score))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", 178, 178-int(178*score), 178-int(178*score))
+}
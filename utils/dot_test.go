@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDot(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+{endpoint=/foo} main.main;main.work 3
+{endpoint=/foo} main.main;main.work;main.work 2
+{endpoint=/bar} main.main;main.small 1
+main.main;main.tiny 94
+`)
+
+	t.Run("default", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Dot{Input: in, Output: out, NodeFraction: 0.02, EdgeFraction: 0.001}).Execute(context.Background()))
+		got := out.String()
+		require.Contains(t, got, `N1 [label="main\nmain\n0\nof 100 (100.00%)"`)
+		require.Contains(t, got, `N2 [label="main\ntiny\n94 (94.00%)"`)
+		require.Contains(t, got, `N3 [label="main\nwork\n5 (5.00%)"`)
+		require.Contains(t, got, `N1 -> N3 [label=" 5"`)
+		require.Contains(t, got, `N3 -> N3 [label=" 2"`)
+		require.NotContains(t, got, "main.small")
+		require.Contains(t, got, `Showing nodes accounting for 99, 99.00% of 100 total\lDropped 1 nodes (cum <= 2)\l`)
+
+		again := &bytes.Buffer{}
+		require.NoError(t, (&Dot{Input: in, Output: again, NodeFraction: 0.02, EdgeFraction: 0.001}).Execute(context.Background()))
+		require.Equal(t, got, again.String())
+	})
+
+	t.Run("edge fraction", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Dot{Input: in, Output: out, EdgeFraction: 0.03}).Execute(context.Background()))
+		require.NotContains(t, out.String(), "-> N4 ")
+		require.Contains(t, out.String(), `Dropped 2 edges (freq <= 3)`)
+	})
+
+	t.Run("cluster label", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Dot{Input: in, Output: out, ClusterLabel: "endpoint"}).Execute(context.Background()))
+		got := out.String()
+		require.Contains(t, got, "subgraph \"cluster_0\" {\nlabel=\"endpoint=/bar\"\n")
+		require.Contains(t, got, "subgraph \"cluster_1\" {\nlabel=\"endpoint=/foo\"\n")
+		require.Contains(t, got, "subgraph \"cluster_2\" {\nlabel=\"endpoint=N/A\"\n")
+		require.Contains(t, got, `  N1 [label="main\nmain\n0\nof 1 (1.00%)"`)
+	})
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/google/pprof/profile"
+)
+
+// Filter filters the samples and frames of a profile with the same semantics
+// as the -focus, -ignore, -hide and -show_from flags of go tool pprof. The
+// regular expressions are matched against the function names, which include
+// the package, and the file names of the frames.
+type Filter struct {
+	Input  []byte
+	Output io.Writer
+	// Focus keeps only the samples with at least one matching frame.
+	Focus string
+	// Ignore drops the samples with at least one matching frame.
+	Ignore string
+	// Hide removes the matching frames but keeps the samples.
+	Hide string
+	// ShowFrom drops all frames above the highest matching frame of each
+	// sample. Samples without a matching frame are dropped.
+	ShowFrom string
+}
+
+func (f *Filter) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(f.Input)
+	if err != nil {
+		return err
+	}
+	var focus, ignore, hide, showFrom *regexp.Regexp
+	for _, opt := range []struct {
+		name string
+		expr string
+		re   **regexp.Regexp
+	}{
+		{"focus", f.Focus, &focus},
+		{"ignore", f.Ignore, &ignore},
+		{"hide", f.Hide, &hide},
+		{"show_from", f.ShowFrom, &showFrom},
+	} {
+		if opt.expr == "" {
+			continue
+		} else if *opt.re, err = regexp.Compile(opt.expr); err != nil {
+			return fmt.Errorf("bad %s: %w", opt.name, err)
+		}
+	}
+
+	// This is the same order as in go tool pprof.
+	prof.FilterSamplesByName(focus, ignore, hide, nil)
+	prof.ShowFrom(showFrom)
+	return prof.Compact().Write(f.Output)
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+main.main@main.go:1;main.work@work.go:2;encoding/json.Marshal@encode.go:3 3
+main.main@main.go:1;main.work@work.go:2;runtime.mallocgc@malloc.go:4 2
+main.main@main.go:1;runtime.gopark@proc.go:5 1
+`)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   map[string]int64
+	}{
+		{
+			name:   "focus",
+			filter: Filter{Focus: `^main\.work$`},
+			want: map[string]int64{
+				"main.main;main.work;encoding/json.Marshal": 3,
+				"main.main;main.work;runtime.mallocgc":      2,
+			},
+		},
+		{
+			name:   "focus file",
+			filter: Filter{Focus: `proc\.go`},
+			want:   map[string]int64{"main.main;runtime.gopark": 1},
+		},
+		{
+			name:   "ignore package",
+			filter: Filter{Ignore: `^runtime\.`},
+			want:   map[string]int64{"main.main;main.work;encoding/json.Marshal": 3},
+		},
+		{
+			name:   "hide",
+			filter: Filter{Hide: `^main\.work$`},
+			want: map[string]int64{
+				"main.main;encoding/json.Marshal": 3,
+				"main.main;runtime.mallocgc":      2,
+				"main.main;runtime.gopark":        1,
+			},
+		},
+		{
+			name:   "show_from",
+			filter: Filter{ShowFrom: `^main\.work$`},
+			want: map[string]int64{
+				"main.work;encoding/json.Marshal": 3,
+				"main.work;runtime.mallocgc":      2,
+			},
+		},
+		{
+			name:   "focus and hide",
+			filter: Filter{Focus: `json`, Hide: `^main\.`},
+			want:   map[string]int64{"encoding/json.Marshal": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.filter.Input, tt.filter.Output = in, out
+			require.NoError(t, tt.filter.Execute(context.Background()))
+			prof, err := profile.ParseData(out.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, tt.want, sampleValues(prof, "", 0))
+		})
+	}
+
+	t.Run("bad regexp", func(t *testing.T) {
+		err := (&Filter{Input: in, Output: &bytes.Buffer{}, Ignore: "("}).Execute(context.Background())
+		require.ErrorContains(t, err, "bad ignore: ")
+	})
+}
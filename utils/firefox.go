@@ -0,0 +1,458 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Firefox converts a pprof profile to the processed profile format of the
+// Firefox Profiler. Samples are split into threads by the value of a label.
+type Firefox struct {
+	Input  []byte
+	Output io.Writer
+	// ThreadLabel is the label key used to split samples into threads, e.g.
+	// "goroutine" or "thread". Samples without the label end up in the main
+	// thread.
+	ThreadLabel string
+	// SampleType is the type/unit of the sample type to export. Defaults to
+	// the default sample type of the profile.
+	SampleType string
+}
+
+func (f *Firefox) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(f.Input)
+	if err != nil {
+		return err
+	}
+	idx, err := selectSampleType(prof, f.SampleType)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f.Output).Encode(toFirefox(prof, idx, f.ThreadLabel))
+}
+
+// firefoxIndex is an index into one of the tables of a firefox profile. Negative
+// values are encoded as null.
+type firefoxIndex int
+
+func (i firefoxIndex) MarshalJSON() ([]byte, error) {
+	if i < 0 {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Itoa(int(i))), nil
+}
+
+// firefoxProfile is the processed profile format of the Firefox Profiler. See
+// https://github.com/firefox-devtools/profiler/tree/main/docs-developer for
+// details. Tables are stored as structs of arrays.
+type firefoxProfile struct {
+	Meta     firefoxMeta     `json:"meta"`
+	Libs     []firefoxLib    `json:"libs"`
+	Pages    []interface{}   `json:"pages"`
+	Counters []interface{}   `json:"counters"`
+	Threads  []firefoxThread `json:"threads"`
+}
+
+type firefoxMeta struct {
+	Interval                   float64           `json:"interval"`
+	StartTime                  float64           `json:"startTime"`
+	ProcessType                int               `json:"processType"`
+	Product                    string            `json:"product"`
+	Stackwalk                  int               `json:"stackwalk"`
+	Debug                      bool              `json:"debug"`
+	Version                    int               `json:"version"`
+	PreprocessedProfileVersion int               `json:"preprocessedProfileVersion"`
+	Symbolicated               bool              `json:"symbolicated"`
+	Categories                 []firefoxCategory `json:"categories"`
+	MarkerSchema               []interface{}     `json:"markerSchema"`
+}
+
+type firefoxCategory struct {
+	Name          string   `json:"name"`
+	Color         string   `json:"color"`
+	Subcategories []string `json:"subcategories"`
+}
+
+type firefoxLib struct {
+	Arch       string  `json:"arch"`
+	Name       string  `json:"name"`
+	Path       string  `json:"path"`
+	DebugName  string  `json:"debugName"`
+	DebugPath  string  `json:"debugPath"`
+	BreakpadID string  `json:"breakpadId"`
+	CodeID     *string `json:"codeId"`
+}
+
+type firefoxThread struct {
+	ProcessType         string               `json:"processType"`
+	ProcessStartupTime  float64              `json:"processStartupTime"`
+	ProcessShutdownTime *float64             `json:"processShutdownTime"`
+	RegisterTime        float64              `json:"registerTime"`
+	UnregisterTime      *float64             `json:"unregisterTime"`
+	PausedRanges        []interface{}        `json:"pausedRanges"`
+	Name                string               `json:"name"`
+	IsMainThread        bool                 `json:"isMainThread"`
+	PID                 string               `json:"pid"`
+	TID                 string               `json:"tid"`
+	Samples             firefoxSamples       `json:"samples"`
+	Markers             firefoxMarkers       `json:"markers"`
+	StackTable          firefoxStackTable    `json:"stackTable"`
+	FrameTable          firefoxFrameTable    `json:"frameTable"`
+	FuncTable           firefoxFuncTable     `json:"funcTable"`
+	ResourceTable       firefoxResourceTable `json:"resourceTable"`
+	NativeSymbols       firefoxNativeSymbols `json:"nativeSymbols"`
+	StringArray         []string             `json:"stringArray"`
+}
+
+type firefoxSamples struct {
+	Stack      []firefoxIndex `json:"stack"`
+	Time       []float64      `json:"time"`
+	Weight     []float64      `json:"weight"`
+	WeightType string         `json:"weightType"`
+	Length     int            `json:"length"`
+}
+
+type firefoxMarkers struct {
+	Data      []interface{} `json:"data"`
+	Name      []int         `json:"name"`
+	StartTime []float64     `json:"startTime"`
+	EndTime   []float64     `json:"endTime"`
+	Phase     []int         `json:"phase"`
+	Category  []int         `json:"category"`
+	Length    int           `json:"length"`
+}
+
+type firefoxStackTable struct {
+	Frame       []int          `json:"frame"`
+	Prefix      []firefoxIndex `json:"prefix"`
+	Category    []int          `json:"category"`
+	Subcategory []int          `json:"subcategory"`
+	Length      int            `json:"length"`
+}
+
+type firefoxFrameTable struct {
+	Address        []int64        `json:"address"`
+	InlineDepth    []int          `json:"inlineDepth"`
+	Category       []int          `json:"category"`
+	Subcategory    []int          `json:"subcategory"`
+	Func           []int          `json:"func"`
+	NativeSymbol   []firefoxIndex `json:"nativeSymbol"`
+	InnerWindowID  []firefoxIndex `json:"innerWindowID"`
+	Implementation []firefoxIndex `json:"implementation"`
+	Line           []firefoxIndex `json:"line"`
+	Column         []firefoxIndex `json:"column"`
+	Length         int            `json:"length"`
+}
+
+type firefoxFuncTable struct {
+	Name          []int          `json:"name"`
+	IsJS          []bool         `json:"isJS"`
+	RelevantForJS []bool         `json:"relevantForJS"`
+	Resource      []int          `json:"resource"`
+	FileName      []firefoxIndex `json:"fileName"`
+	LineNumber    []firefoxIndex `json:"lineNumber"`
+	ColumnNumber  []firefoxIndex `json:"columnNumber"`
+	Length        int            `json:"length"`
+}
+
+type firefoxResourceTable struct {
+	Lib    []firefoxIndex `json:"lib"`
+	Name   []int          `json:"name"`
+	Host   []firefoxIndex `json:"host"`
+	Type   []int          `json:"type"`
+	Length int            `json:"length"`
+}
+
+type firefoxNativeSymbols struct {
+	LibIndex     []int   `json:"libIndex"`
+	Address      []int64 `json:"address"`
+	Name         []int   `json:"name"`
+	FunctionSize []int   `json:"functionSize"`
+	Length       int     `json:"length"`
+}
+
+// firefoxResourceLibrary is the resource type for native libraries.
+const firefoxResourceLibrary = 1
+
+func toFirefox(prof *profile.Profile, idx int, threadLabel string) *firefoxProfile {
+	st := prof.SampleType[idx]
+	weightType, scale := "samples", 1.0
+	switch st.Unit {
+	case "nanoseconds":
+		weightType, scale = "tracing-ms", 1e-6
+	case "bytes":
+		weightType = "bytes"
+	}
+
+	// pprof doesn't record when samples were taken, so they are laid out one
+	// interval apart in the order in which they appear in the profile.
+	interval := 1.0
+	if prof.PeriodType != nil && prof.PeriodType.Unit == "nanoseconds" && prof.Period > 0 {
+		interval = float64(prof.Period) / 1e6
+	}
+
+	ff := &firefoxProfile{
+		Meta: firefoxMeta{
+			Interval:                   interval,
+			StartTime:                  float64(prof.TimeNanos) / 1e6,
+			Product:                    "pprofutils",
+			Version:                    27,
+			PreprocessedProfileVersion: 44,
+			Symbolicated:               true,
+			Categories:                 []firefoxCategory{{Name: "Other", Color: "grey", Subcategories: []string{"Other"}}},
+			MarkerSchema:               []interface{}{},
+		},
+		Libs:     []firefoxLib{},
+		Pages:    []interface{}{},
+		Counters: []interface{}{},
+		Threads:  []firefoxThread{},
+	}
+	libs := map[*profile.Mapping]int{}
+	for _, m := range prof.Mapping {
+		if m.File == "" {
+			continue
+		}
+		libs[m] = len(ff.Libs)
+		ff.Libs = append(ff.Libs, firefoxLib{
+			Name:       filepath.Base(m.File),
+			Path:       m.File,
+			DebugName:  filepath.Base(m.File),
+			DebugPath:  m.File,
+			BreakpadID: m.BuildID,
+		})
+	}
+
+	threads := map[string]*firefoxThreadBuilder{}
+	var values []string
+	for _, s := range prof.Sample {
+		if s.Value[idx] == 0 {
+			continue
+		}
+		value := firefoxThreadValue(s, threadLabel)
+		tb := threads[value]
+		if tb == nil {
+			tb = newFirefoxThreadBuilder(libs)
+			threads[value] = tb
+			values = append(values, value)
+		}
+		tb.addSample(s, float64(s.Value[idx])*scale, interval)
+	}
+	if len(values) == 0 {
+		// Emit an empty main thread, so the profile can still be loaded.
+		threads[""] = newFirefoxThreadBuilder(libs)
+		values = append(values, "")
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		a, b := values[i], values[j]
+		if a == "" || b == "" {
+			return a == ""
+		}
+		an, aErr := strconv.ParseInt(a, 10, 64)
+		bn, bErr := strconv.ParseInt(b, 10, 64)
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+		return a < b
+	})
+	for i, value := range values {
+		t := &threads[value].thread
+		t.ProcessType = "default"
+		t.PID = "0"
+		t.TID = strconv.Itoa(i)
+		t.Samples.WeightType = weightType
+		if value == "" {
+			t.Name = "main"
+			t.IsMainThread = true
+		} else {
+			t.Name = threadLabel + " " + value
+		}
+		ff.Threads = append(ff.Threads, *t)
+	}
+	return ff
+}
+
+// firefoxThreadValue returns the value of the thread label of the given sample
+// or an empty string if it doesn't have it.
+func firefoxThreadValue(s *profile.Sample, key string) string {
+	if key == "" {
+		return ""
+	}
+	if vals := s.Label[key]; len(vals) > 0 {
+		return strings.Join(vals, ",")
+	}
+	var vals []string
+	for _, v := range s.NumLabel[key] {
+		vals = append(vals, strconv.FormatInt(v, 10))
+	}
+	return strings.Join(vals, ",")
+}
+
+type firefoxFrameKey struct {
+	fn    int
+	line  int64
+	addr  uint64
+	depth int
+}
+
+// firefoxThreadBuilder builds the tables of a thread and deduplicates their
+// entries.
+type firefoxThreadBuilder struct {
+	thread    firefoxThread
+	libs      map[*profile.Mapping]int
+	strings   map[string]int
+	funcs     map[*profile.Function]int
+	frames    map[firefoxFrameKey]int
+	stacks    map[[2]int]int
+	resources map[*profile.Mapping]int
+	time      float64
+}
+
+func newFirefoxThreadBuilder(libs map[*profile.Mapping]int) *firefoxThreadBuilder {
+	tb := &firefoxThreadBuilder{
+		libs:      libs,
+		strings:   map[string]int{},
+		funcs:     map[*profile.Function]int{},
+		frames:    map[firefoxFrameKey]int{},
+		stacks:    map[[2]int]int{},
+		resources: map[*profile.Mapping]int{},
+	}
+	t := &tb.thread
+	t.PausedRanges = []interface{}{}
+	t.Samples = firefoxSamples{Stack: []firefoxIndex{}, Time: []float64{}, Weight: []float64{}}
+	t.Markers = firefoxMarkers{Data: []interface{}{}, Name: []int{}, StartTime: []float64{}, EndTime: []float64{}, Phase: []int{}, Category: []int{}}
+	t.StackTable = firefoxStackTable{Frame: []int{}, Prefix: []firefoxIndex{}, Category: []int{}, Subcategory: []int{}}
+	t.FrameTable = firefoxFrameTable{Address: []int64{}, InlineDepth: []int{}, Category: []int{}, Subcategory: []int{}, Func: []int{}, NativeSymbol: []firefoxIndex{}, InnerWindowID: []firefoxIndex{}, Implementation: []firefoxIndex{}, Line: []firefoxIndex{}, Column: []firefoxIndex{}}
+	t.FuncTable = firefoxFuncTable{Name: []int{}, IsJS: []bool{}, RelevantForJS: []bool{}, Resource: []int{}, FileName: []firefoxIndex{}, LineNumber: []firefoxIndex{}, ColumnNumber: []firefoxIndex{}}
+	t.ResourceTable = firefoxResourceTable{Lib: []firefoxIndex{}, Name: []int{}, Host: []firefoxIndex{}, Type: []int{}}
+	t.NativeSymbols = firefoxNativeSymbols{LibIndex: []int{}, Address: []int64{}, Name: []int{}, FunctionSize: []int{}}
+	t.StringArray = []string{}
+	return tb
+}
+
+func (tb *firefoxThreadBuilder) addSample(s *profile.Sample, weight, interval float64) {
+	stack := -1
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			frame := tb.frame(loc, loc.Line[j], len(loc.Line)-1-j)
+			stack = tb.stack(stack, frame)
+		}
+	}
+
+	samples := &tb.thread.Samples
+	samples.Stack = append(samples.Stack, firefoxIndex(stack))
+	samples.Time = append(samples.Time, tb.time)
+	samples.Weight = append(samples.Weight, weight)
+	samples.Length++
+	tb.time += interval
+}
+
+func (tb *firefoxThreadBuilder) string(s string) int {
+	idx, ok := tb.strings[s]
+	if !ok {
+		idx = len(tb.thread.StringArray)
+		tb.strings[s] = idx
+		tb.thread.StringArray = append(tb.thread.StringArray, s)
+	}
+	return idx
+}
+
+func (tb *firefoxThreadBuilder) resource(m *profile.Mapping) int {
+	lib, ok := tb.libs[m]
+	if !ok {
+		return -1
+	}
+	idx, ok := tb.resources[m]
+	if !ok {
+		rt := &tb.thread.ResourceTable
+		idx = rt.Length
+		tb.resources[m] = idx
+		rt.Lib = append(rt.Lib, firefoxIndex(lib))
+		rt.Name = append(rt.Name, tb.string(filepath.Base(m.File)))
+		rt.Host = append(rt.Host, -1)
+		rt.Type = append(rt.Type, firefoxResourceLibrary)
+		rt.Length++
+	}
+	return idx
+}
+
+func (tb *firefoxThreadBuilder) function(fn *profile.Function, m *profile.Mapping) int {
+	idx, ok := tb.funcs[fn]
+	if !ok {
+		ft := &tb.thread.FuncTable
+		idx = ft.Length
+		tb.funcs[fn] = idx
+		fileName, lineNumber := firefoxIndex(-1), firefoxIndex(-1)
+		if fn.Filename != "" {
+			fileName = firefoxIndex(tb.string(fn.Filename))
+		}
+		if fn.StartLine > 0 {
+			lineNumber = firefoxIndex(fn.StartLine)
+		}
+		ft.Name = append(ft.Name, tb.string(fn.Name))
+		ft.IsJS = append(ft.IsJS, false)
+		ft.RelevantForJS = append(ft.RelevantForJS, false)
+		ft.Resource = append(ft.Resource, tb.resource(m))
+		ft.FileName = append(ft.FileName, fileName)
+		ft.LineNumber = append(ft.LineNumber, lineNumber)
+		ft.ColumnNumber = append(ft.ColumnNumber, -1)
+		ft.Length++
+	}
+	return idx
+}
+
+func (tb *firefoxThreadBuilder) frame(loc *profile.Location, line profile.Line, depth int) int {
+	key := firefoxFrameKey{fn: tb.function(line.Function, loc.Mapping), line: line.Line, addr: loc.Address, depth: depth}
+	idx, ok := tb.frames[key]
+	if !ok {
+		ft := &tb.thread.FrameTable
+		idx = ft.Length
+		tb.frames[key] = idx
+		address, lineNumber, column := int64(-1), firefoxIndex(-1), firefoxIndex(-1)
+		if loc.Address > 0 {
+			address = int64(loc.Address)
+		}
+		if line.Line > 0 {
+			lineNumber = firefoxIndex(line.Line)
+		}
+		if line.Column > 0 {
+			column = firefoxIndex(line.Column)
+		}
+		ft.Address = append(ft.Address, address)
+		ft.InlineDepth = append(ft.InlineDepth, depth)
+		ft.Category = append(ft.Category, 0)
+		ft.Subcategory = append(ft.Subcategory, 0)
+		ft.Func = append(ft.Func, key.fn)
+		ft.NativeSymbol = append(ft.NativeSymbol, -1)
+		ft.InnerWindowID = append(ft.InnerWindowID, -1)
+		ft.Implementation = append(ft.Implementation, -1)
+		ft.Line = append(ft.Line, lineNumber)
+		ft.Column = append(ft.Column, column)
+		ft.Length++
+	}
+	return idx
+}
+
+func (tb *firefoxThreadBuilder) stack(prefix, frame int) int {
+	key := [2]int{prefix, frame}
+	idx, ok := tb.stacks[key]
+	if !ok {
+		st := &tb.thread.StackTable
+		idx = st.Length
+		tb.stacks[key] = idx
+		st.Frame = append(st.Frame, frame)
+		st.Prefix = append(st.Prefix, firefoxIndex(prefix))
+		st.Category = append(st.Category, 0)
+		st.Subcategory = append(st.Subcategory, 0)
+		st.Length++
+	}
+	return idx
+}
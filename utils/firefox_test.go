@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirefox(t *testing.T) {
+	in := foldedProfile(t, `samples/count cpu/nanoseconds @extended
+{goroutine=#2} main@main.go:1;foo@foo.go:2 2 3000000
+{goroutine=#10} main@main.go:1;bar@bar.go:3 1 1000000
+main@main.go:1 4 0
+`)
+
+	t.Run("threads", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Firefox{Input: in, Output: out, ThreadLabel: "goroutine"}).Execute(context.Background()))
+
+		var ff firefoxProfile
+		require.NoError(t, json.Unmarshal(out.Bytes(), &ff))
+		require.Len(t, ff.Threads, 2)
+		require.Equal(t, "goroutine 2", ff.Threads[0].Name)
+		require.Equal(t, "goroutine 10", ff.Threads[1].Name)
+
+		th := ff.Threads[0]
+		require.Equal(t, "tracing-ms", th.Samples.WeightType)
+		require.Equal(t, []float64{3}, th.Samples.Weight)
+		require.Equal(t, 2, th.StackTable.Length)
+		leaf := th.Samples.Stack[0]
+		frame := th.StackTable.Frame[leaf]
+		fn := th.FuncTable.Name[th.FrameTable.Func[frame]]
+		require.Equal(t, "foo", th.StringArray[fn])
+		require.Equal(t, firefoxIndex(2), th.FrameTable.Line[frame])
+		require.Equal(t, firefoxIndex(0), th.StackTable.Prefix[leaf])
+	})
+
+	t.Run("sample type", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Firefox{Input: in, Output: out, ThreadLabel: "goroutine", SampleType: "samples/count"}).Execute(context.Background()))
+
+		var ff firefoxProfile
+		require.NoError(t, json.Unmarshal(out.Bytes(), &ff))
+		require.Len(t, ff.Threads, 3)
+		require.Equal(t, "main", ff.Threads[0].Name)
+		require.True(t, ff.Threads[0].IsMainThread)
+		require.Equal(t, "samples", ff.Threads[0].Samples.WeightType)
+		require.Equal(t, []float64{4}, ff.Threads[0].Samples.Weight)
+
+		err := (&Firefox{Input: in, Output: out, SampleType: "alloc/bytes"}).Execute(context.Background())
+		require.EqualError(t, err, "sample type not found in profile: alloc/bytes (available: samples/count cpu/nanoseconds)")
+	})
+}
@@ -0,0 +1,469 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/pprof/profile"
+)
+
+// Flamegraph renders an interactive flame graph SVG for a profile.
+type Flamegraph struct {
+	Input  []byte
+	Output io.Writer
+	// SampleType is the type/unit of the sample type to render. Defaults to
+	// the default sample type of the profile.
+	SampleType string
+	// Inverted renders an icicle graph with the root frame at the top.
+	Inverted bool
+	Title    string
+	// Width is the width of the SVG in pixels.
+	Width int
+	// Colors is the color scheme, either "package" or "hot".
+	Colors string
+	// Search is a regular expression for highlighting matching frames.
+	Search string
+}
+
+func (f *Flamegraph) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(f.Input)
+	if err != nil {
+		return err
+	}
+	idx, err := selectSampleType(prof, f.SampleType)
+	if err != nil {
+		return err
+	}
+	var color func(n *flameNode) string
+	switch f.Colors {
+	case "package", "":
+		color = func(n *flameNode) string { return packageColor(n.Name) }
+	case "hot":
+		color = func(n *flameNode) string { return hotColor(n.Name) }
+	default:
+		return fmt.Errorf("unknown colors: %q: must be package or hot", f.Colors)
+	}
+
+	st := prof.SampleType[idx]
+	root := newFlameTree(prof, func(s *profile.Sample) int64 { return s.Value[idx] })
+	return renderFlamegraph(f.Output, root, flameOptions{
+		Title:    f.Title,
+		Subtitle: st.Type + "/" + st.Unit,
+		Width:    f.Width,
+		Inverted: f.Inverted,
+		Search:   f.Search,
+		Color:    color,
+		Details: func(n *flameNode) string {
+			return fmt.Sprintf("%s (%s, %.2f%%)", n.Name, formatValue(n.Value, st.Unit), percent(n.Value, root.Value))
+		},
+	})
+}
+
+// flameNode is a node in the stack tree of a flame graph. The root node holds
+// the total of all samples.
+type flameNode struct {
+	Name  string
+	Value int64
+	// Base is the value of the node in the base profile of a differential
+	// flame graph.
+	Base     int64
+	Children []*flameNode
+	children map[string]*flameNode
+}
+
+func (n *flameNode) child(name string) *flameNode {
+	c := n.children[name]
+	if c == nil {
+		c = &flameNode{Name: name}
+		if n.children == nil {
+			n.children = map[string]*flameNode{}
+		}
+		n.children[name] = c
+		n.Children = append(n.Children, c)
+	}
+	return c
+}
+
+// sort orders the children of n and its descendants by name.
+func (n *flameNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// newFlameTree builds the stack tree of the given profile using the given
+// value of each sample. Samples with a value <= 0 are ignored.
+func newFlameTree(prof *profile.Profile, value func(s *profile.Sample) int64) *flameNode {
+	root := &flameNode{Name: "all"}
+	for _, s := range prof.Sample {
+		v := value(s)
+		if v <= 0 {
+			continue
+		}
+		root.Value += v
+		node := root
+		for _, name := range stackNames(s) {
+			node = node.child(name)
+			node.Value += v
+		}
+	}
+	root.sort()
+	return root
+}
+
+// stackNames returns the function names of the stack of s ordered from root
+// to leaf. Inlined functions are included. Unsymbolized locations are named
+// after their address.
+func stackNames(s *profile.Sample) []string {
+	var names []string
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		if len(loc.Line) == 0 {
+			names = append(names, addressName(loc))
+		}
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			names = append(names, loc.Line[j].Function.Name)
+		}
+	}
+	return names
+}
+
+// addressName returns the name of a location without line information like
+// pprof does, e.g. "0x401000 [app]".
+func addressName(loc *profile.Location) string {
+	name := fmt.Sprintf("0x%x", loc.Address)
+	if loc.Mapping != nil && loc.Mapping.File != "" {
+		name += " [" + filepath.Base(loc.Mapping.File) + "]"
+	}
+	return name
+}
+
+type flameOptions struct {
+	Title    string
+	Subtitle string
+	Width    int
+	Inverted bool
+	Search   string
+	// Color returns the fill color of a frame.
+	Color func(n *flameNode) string
+	// Details returns the text shown when hovering a frame.
+	Details func(n *flameNode) string
+}
+
+const (
+	flameXPad        = 10
+	flameTopPad      = 56
+	flameBottomPad   = 32
+	flameFrameHeight = 16
+	flameCharWidth   = 7
+	// flameMinWidth is the width in pixels below which frames are omitted.
+	flameMinWidth = 0.1
+	// flameSearchColor is used for frames matching the search.
+	flameSearchColor = "rgb(230,0,230)"
+)
+
+type flameFrame struct {
+	node  *flameNode
+	x     float64
+	w     float64
+	depth int
+}
+
+// renderFlamegraph writes root as an SVG. The embedded script allows zooming
+// by clicking frames and searching them.
+func renderFlamegraph(out io.Writer, root *flameNode, opts flameOptions) error {
+	if opts.Width < 2*flameXPad+100 {
+		return fmt.Errorf("width must be at least %d", 2*flameXPad+100)
+	}
+	var search *regexp.Regexp
+	if opts.Search != "" {
+		var err error
+		if search, err = regexp.Compile(opts.Search); err != nil {
+			return fmt.Errorf("bad search: %w", err)
+		}
+	}
+	if opts.Title == "" {
+		opts.Title = "Flame Graph"
+	}
+
+	var (
+		frameWidth = float64(opts.Width - 2*flameXPad)
+		frames     []flameFrame
+		maxDepth   int
+	)
+	var walk func(n *flameNode, x float64, depth int)
+	walk = func(n *flameNode, x float64, depth int) {
+		w := frameWidth
+		if root.Value > 0 {
+			w = float64(n.Value) / float64(root.Value) * frameWidth
+		}
+		if w < flameMinWidth {
+			return
+		}
+		frames = append(frames, flameFrame{node: n, x: x, w: w, depth: depth})
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		for _, c := range n.Children {
+			walk(c, x, depth+1)
+			x += float64(c.Value) / float64(root.Value) * frameWidth
+		}
+	}
+	walk(root, 0, 0)
+
+	height := flameTopPad + (maxDepth+1)*flameFrameHeight + flameBottomPad
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">
+<style>
+text { font-family: Verdana, sans-serif; font-size: 12px; fill: rgb(0,0,0); }
+.title { font-size: 17px; }
+.button { cursor: pointer; fill: rgb(0,0,160); }
+.f { cursor: pointer; }
+.f:hover rect { stroke: rgb(0,0,0); stroke-width: 0.5; }
+.hide { display: none; }
+</style>
+<rect width="100%%" height="100%%" fill="rgb(248,248,248)"/>
+`, opts.Width, height, opts.Width, height)
+	fmt.Fprintf(w, `<text class="title" x="%d" y="24" text-anchor="middle">%s</text>`+"\n", opts.Width/2, html.EscapeString(opts.Title))
+	fmt.Fprintf(w, `<text x="%d" y="42" text-anchor="middle">%s</text>`+"\n", opts.Width/2, html.EscapeString(opts.Subtitle))
+	fmt.Fprintf(w, `<text class="unzoom button hide" x="%d" y="24">Reset Zoom</text>`+"\n", flameXPad)
+	fmt.Fprintf(w, `<text class="search button" x="%d" y="24" text-anchor="end">Search</text>`+"\n", opts.Width-flameXPad)
+	fmt.Fprintf(w, `<text class="details" x="%d" y="%d"> </text>`+"\n", flameXPad, height-12)
+	fmt.Fprintf(w, `<text class="matched" x="%d" y="%d" text-anchor="end"> </text>`+"\n", opts.Width-flameXPad, height-12)
+
+	fmt.Fprintf(w, "<g class=\"frames\">\n")
+	for _, f := range frames {
+		y := height - flameBottomPad - (f.depth+1)*flameFrameHeight
+		if opts.Inverted {
+			y = flameTopPad + f.depth*flameFrameHeight
+		}
+		fill := opts.Color(f.node)
+		if f.depth == 0 {
+			fill = "rgb(200,200,200)"
+		}
+		var original string
+		if search != nil && f.depth > 0 && search.MatchString(f.node.Name) {
+			original = fmt.Sprintf(` data-c="%s"`, fill)
+			fill = flameSearchColor
+		}
+		fmt.Fprintf(w, `<g class="f" data-n="%s" data-x="%.2f" data-w="%.2f" data-d="%d">`,
+			html.EscapeString(f.node.Name), f.x, f.w, f.depth)
+		fmt.Fprintf(w, `<title>%s</title>`, html.EscapeString(opts.Details(f.node)))
+		fmt.Fprintf(w, `<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s"%s rx="2" ry="2"/>`,
+			flameXPad+f.x, y, f.w, flameFrameHeight-1, fill, original)
+		fmt.Fprintf(w, `<text x="%.2f" y="%d">%s</text></g>`+"\n",
+			flameXPad+f.x+3, y+flameFrameHeight-4, html.EscapeString(flameLabel(f.node.Name, f.w)))
+	}
+	fmt.Fprintf(w, "</g>\n")
+
+	initialSearch, err := json.Marshal(opts.Search)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "<script type=\"text/ecmascript\"><![CDATA[\n(function() {\nvar xpad = %d, width = %.2f, charWidth = %d, searchColor = %q, initialSearch = %s;\n%s})();\n]]></script>\n</svg>\n",
+		flameXPad, frameWidth, flameCharWidth, flameSearchColor, initialSearch, flameScript)
+	return w.Flush()
+}
+
+// flameLabel returns name truncated to fit into a frame of width w. The name
+// is truncated on rune boundaries to keep the label valid UTF-8.
+func flameLabel(name string, w float64) string {
+	max := int((w - 6) / flameCharWidth)
+	if max < 3 {
+		return ""
+	} else if utf8.RuneCountInString(name) <= max {
+		return name
+	}
+	return string([]rune(name)[:max-2]) + ".."
+}
+
+// flameScript implements zooming and searching. It must match the labels
+// created by flameLabel.
+const flameScript = `var script = document.currentScript;
+var svg = script ? script.ownerSVGElement : document.documentElement;
+var frames = Array.prototype.slice.call(svg.querySelectorAll(".f"));
+var details = svg.querySelector(".details"), matched = svg.querySelector(".matched");
+var unzoomButton = svg.querySelector(".unzoom"), searchButton = svg.querySelector(".search");
+var searching = false;
+
+function num(f, key) { return parseFloat(f.getAttribute("data-" + key)); }
+
+function label(name, w) {
+  var max = Math.floor((w - 6) / charWidth);
+  if (max < 3) return "";
+  var runes = Array.from(name);
+  return runes.length <= max ? name : runes.slice(0, max - 2).join("") + "..";
+}
+
+function place(f, x, w) {
+  var rect = f.querySelector("rect"), text = f.querySelector("text");
+  rect.setAttribute("x", (xpad + x).toFixed(2));
+  rect.setAttribute("width", w.toFixed(2));
+  text.setAttribute("x", (xpad + x + 3).toFixed(2));
+  text.textContent = label(f.getAttribute("data-n"), w);
+}
+
+function zoom(target) {
+  var tx = num(target, "x"), tw = num(target, "w"), td = num(target, "d"), scale = width / tw, eps = 1e-3;
+  frames.forEach(function(f) {
+    var x = num(f, "x"), w = num(f, "w"), d = num(f, "d");
+    f.classList.remove("hide");
+    f.style.opacity = "";
+    if (d < td && x <= tx + eps && x + w >= tx + tw - eps) {
+      place(f, 0, width);
+      f.style.opacity = "0.5";
+    } else if (d >= td && x >= tx - eps && x + w <= tx + tw + eps) {
+      place(f, (x - tx) * scale, w * scale);
+    } else {
+      f.classList.add("hide");
+    }
+  });
+  unzoomButton.classList.remove("hide");
+}
+
+function unzoom() {
+  frames.forEach(function(f) {
+    f.classList.remove("hide");
+    f.style.opacity = "";
+    place(f, num(f, "x"), num(f, "w"));
+  });
+  unzoomButton.classList.add("hide");
+}
+
+function search(term) {
+  var re = null;
+  if (term) {
+    try { re = new RegExp(term); } catch (e) { alert(e); return; }
+  }
+  var ranges = [];
+  frames.forEach(function(f) {
+    var rect = f.querySelector("rect");
+    if (!rect.hasAttribute("data-c")) rect.setAttribute("data-c", rect.getAttribute("fill"));
+    if (re && num(f, "d") > 0 && re.test(f.getAttribute("data-n"))) {
+      rect.setAttribute("fill", searchColor);
+      ranges.push([num(f, "x"), num(f, "x") + num(f, "w")]);
+    } else {
+      rect.setAttribute("fill", rect.getAttribute("data-c"));
+    }
+  });
+  searching = !!re;
+  searchButton.textContent = re ? "Reset Search" : "Search";
+  if (!re) {
+    matched.textContent = " ";
+    return;
+  }
+  // Nested matches are only counted once.
+  ranges.sort(function(a, b) { return a[0] - b[0]; });
+  var total = 0, end = -1;
+  ranges.forEach(function(r) {
+    if (r[1] <= end) return;
+    total += r[1] - Math.max(r[0], end);
+    end = r[1];
+  });
+  matched.textContent = "Matched: " + (100 * total / width).toFixed(1) + "%";
+}
+
+function promptSearch() {
+  search(prompt("Enter a search term (regexp allowed)", ""));
+}
+
+svg.addEventListener("click", function(e) {
+  var f = e.target.closest(".f");
+  if (f) {
+    zoom(f);
+  } else if (e.target === unzoomButton) {
+    unzoom();
+  } else if (e.target === searchButton) {
+    searching ? search("") : promptSearch();
+  }
+});
+svg.addEventListener("mouseover", function(e) {
+  var f = e.target.closest(".f");
+  details.textContent = f ? f.querySelector("title").textContent : " ";
+});
+if (svg === document.documentElement) {
+  document.addEventListener("keydown", function(e) {
+    if ((e.ctrlKey || e.metaKey) && e.key === "f") {
+      e.preventDefault();
+      promptSearch();
+    }
+  });
+}
+if (initialSearch) search(initialSearch);
+`
+
+// packageName returns the package of a function name, e.g. "encoding/json"
+// for "encoding/json.(*Decoder).Decode" or "std" for "std::vector::push_back".
+// It returns an empty string if the name has no package.
+func packageName(name string) string {
+	if i := strings.IndexAny(name, "[<("); i > 0 && name[i-1] != '.' {
+		// Strip type parameters and argument lists that may contain dots.
+		name = name[:i]
+	}
+	if i := strings.Index(name, "::"); i > 0 {
+		return name[:i]
+	}
+	slash := strings.LastIndex(name, "/") + 1
+	if dot := strings.Index(name[slash:], "."); dot > 0 {
+		return name[:slash+dot]
+	}
+	return ""
+}
+
+// packageColor returns a color for the package of the given function, so that
+// all functions of a package have the same color.
+func packageColor(name string) string {
+	pkg := packageName(name)
+	if pkg == "" {
+		pkg = name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(pkg))
+	return hslColor(float64(h.Sum32()%360), 0.6, 0.72)
+}
+
+// hotColor returns a random warm color for the given function like the
+// default palette of flamegraph.pl.
+func hotColor(name string) string {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	v1, v2 := float64(sum&0xffff)/0xffff, float64(sum>>16&0xffff)/0xffff
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+int(50*v2), int(230*v1), int(55*v2))
+}
+
+// hslColor converts the given hue, saturation and lightness to an rgb color.
+func hslColor(h, s, l float64) string {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return fmt.Sprintf("rgb(%d,%d,%d)", int((r+m)*255), int((g+m)*255), int((b+m)*255))
+}
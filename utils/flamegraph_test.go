@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlamegraph(t *testing.T) {
+	in := foldedProfile(t, "samples/count cpu/nanoseconds\nmain.main;encoding/json.Marshal 3 3000\nmain.main;main.work 1 1000")
+
+	// frames returns the data-n attribute and rect attributes of all frames.
+	frames := func(t *testing.T, svg []byte) map[string]map[string]string {
+		result := map[string]map[string]string{}
+		dec := xml.NewDecoder(bytes.NewReader(svg))
+		var name string
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return result
+			}
+			require.NoError(t, err)
+			el, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			attrs := map[string]string{}
+			for _, a := range el.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if el.Name.Local == "g" && attrs["data-n"] != "" {
+				name = attrs["data-n"]
+			} else if el.Name.Local == "rect" && name != "" {
+				result[name] = attrs
+				name = ""
+			}
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Flamegraph{Input: in, Output: out, Width: 1020}).Execute(context.Background()))
+		got := frames(t, out.Bytes())
+		require.Len(t, got, 4)
+		require.Equal(t, "1000.00", got["all"]["width"])
+		require.Equal(t, "750.00", got["encoding/json.Marshal"]["width"])
+		require.Equal(t, "760.00", got["main.work"]["x"])
+		require.Greater(t, atoi(t, got["all"]["y"]), atoi(t, got["main.main"]["y"]))
+		require.Contains(t, out.String(), "<title>main.work (1.00µs, 25.00%)</title>")
+	})
+
+	t.Run("inverted search", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Flamegraph{Input: in, Output: out, Width: 1020, Inverted: true, Search: "json", SampleType: "samples/count"}).Execute(context.Background()))
+		got := frames(t, out.Bytes())
+		require.Less(t, atoi(t, got["all"]["y"]), atoi(t, got["main.main"]["y"]))
+		require.Equal(t, flameSearchColor, got["encoding/json.Marshal"]["fill"])
+		require.NotEqual(t, flameSearchColor, got["main.work"]["fill"])
+		require.Contains(t, out.String(), "<title>main.work (1, 25.00%)</title>")
+	})
+
+	t.Run("non-ASCII names", func(t *testing.T) {
+		in := foldedProfile(t, "main.main;関数関数関数 1\nmain.main;main.work 3")
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Flamegraph{Input: in, Output: out, Width: 200}).Execute(context.Background()))
+		require.True(t, utf8.Valid(out.Bytes()))
+		got := frames(t, out.Bytes())
+		require.Equal(t, "45.00", got["関数関数関数"]["width"])
+		require.Contains(t, out.String(), ">関数関..</text>")
+	})
+
+	t.Run("unsymbolized", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Flamegraph{Input: addressProfile(t), Output: out, Width: 1020}).Execute(context.Background()))
+		got := frames(t, out.Bytes())
+		require.Len(t, got, 4)
+		require.Equal(t, "1000.00", got["0x401000 [app]"]["width"])
+		require.Equal(t, "750.00", got["0x401100 [app]"]["width"])
+		require.Equal(t, "250.00", got["0xffffffff81000000"]["width"])
+	})
+
+	t.Run("bad colors", func(t *testing.T) {
+		err := (&Flamegraph{Input: in, Output: &bytes.Buffer{}, Width: 1020, Colors: "rainbow"}).Execute(context.Background())
+		require.EqualError(t, err, `unknown colors: "rainbow": must be package or hot`)
+	})
+}
+
+// addressProfile returns an unsymbolized profile like the ones produced by
+// PerfData. main is called at 0x401000, it calls a function at 0x401100 for
+// 3 samples and a kernel function without mapping for 1 sample.
+func addressProfile(t *testing.T) []byte {
+	var (
+		m    = &profile.Mapping{ID: 1, Start: 0x400000, Limit: 0x410000, File: "/usr/bin/app"}
+		main = &profile.Location{ID: 1, Mapping: m, Address: 0x401000}
+		work = &profile.Location{ID: 2, Mapping: m, Address: 0x401100}
+		kern = &profile.Location{ID: 3, Address: 0xffffffff81000000}
+		prof = &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{work, main}, Value: []int64{3}},
+				{Location: []*profile.Location{kern, main}, Value: []int64{1}},
+			},
+			Mapping:  []*profile.Mapping{m},
+			Location: []*profile.Location{main, work, kern},
+		}
+	)
+	buf := &bytes.Buffer{}
+	require.NoError(t, prof.Write(buf))
+	return buf.Bytes()
+}
+
+func atoi(t *testing.T, s string) int {
+	v, err := strconv.Atoi(s)
+	require.NoError(t, err)
+	return v
+}
+
+func TestPackageName(t *testing.T) {
+	tests := map[string]string{
+		"main.main":                              "main",
+		"encoding/json.(*Decoder).Decode":        "encoding/json",
+		"github.com/foo/bar.Baz[...]":            "github.com/foo/bar",
+		"slices.Sort[go.shape.[]uint8]":          "slices",
+		"std::vector<a::b>::push_back":           "std",
+		"malloc":                                 "",
+		"runtime/internal/atomic.(*Uint32).Load": "runtime/internal/atomic",
+	}
+	for name, want := range tests {
+		require.Equal(t, want, packageName(name), name)
+	}
+}
+
+func TestFlameLabel(t *testing.T) {
+	w := float64(6 + 8*flameCharWidth)
+	require.Equal(t, "", flameLabel("main.main", 6+2*flameCharWidth))
+	require.Equal(t, "main.foo", flameLabel("main.foo", w))
+	require.Equal(t, "main.m..", flameLabel("main.main", w))
+	require.Equal(t, "日本語.関数", flameLabel("日本語.関数", w))
+	require.Equal(t, "日本語.関数..", flameLabel("日本語.関数的処理です", w))
+	require.True(t, utf8.ValidString(flameLabel("ñññññññññññ", w)))
+}
@@ -9,11 +9,19 @@ import (
 	"github.com/google/pprof/profile"
 )
 
+// Folded converts pprof to folded text and vice versa. The direction is
+// determined by the input format.
+//
+// Input is held in memory as a whole because it has to be tried as pprof
+// first. Folded text is then parsed line by line, and repeated frames share
+// the same functions and locations, so the memory needed beyond Input is
+// bounded by the number of samples and distinct frames.
 type Folded struct {
 	Input       []byte
 	Output      io.Writer
 	Headers     bool
 	LineNumbers bool
+	Extended    bool
 }
 
 func (f *Folded) Execute(ctx context.Context) error {
@@ -22,6 +30,7 @@ func (f *Folded) Execute(ctx context.Context) error {
 		p := legacy.Protobuf{
 			SampleTypes: f.Headers,
 			LineNumbers: f.LineNumbers,
+			Extended:    f.Extended,
 		}
 		return p.Convert(prof, f.Output)
 	}
@@ -13,17 +13,25 @@ import (
 type JSON struct {
 	Input  []byte
 	Output io.Writer
+	// Simple selects a denormalized json format with one object per sample
+	// instead of the full format that mirrors profile.Profile.
 	Simple bool
 }
 
 func (j *JSON) Execute(ctx context.Context) error {
-	if j.Simple {
-		return fmt.Errorf("simple format is not implemented yet")
-	}
 	prof, err := profile.ParseData(j.Input)
 	if err == nil {
+		if j.Simple {
+			return toSimpleJSON(prof, j.Output)
+		}
 		return toFullJSON(prof, j.Output)
 	}
+	if j.Simple {
+		if err := fromSimpleJSON(j.Input, j.Output); err != nil {
+			return fmt.Errorf("input format is neither pprof nor simple json: %w", err)
+		}
+		return nil
+	}
 	if err := fromFullJSON(j.Input, j.Output); err != nil {
 		return errors.New("input format is neither pprof nor json")
 	}
@@ -43,3 +51,200 @@ func fromFullJSON(in []byte, out io.Writer) error {
 	}
 	return prof.Write(out)
 }
+
+// simpleProfile is the top level object of the simple json format.
+type simpleProfile struct {
+	SampleTypes   []string       `json:"sample_types"`
+	PeriodType    string         `json:"period_type,omitempty"`
+	Period        int64          `json:"period,omitempty"`
+	TimeNanos     int64          `json:"time_nanos,omitempty"`
+	DurationNanos int64          `json:"duration_nanos,omitempty"`
+	Samples       []simpleSample `json:"samples"`
+}
+
+// simpleSample is a sample of the simple json format. The stack is ordered
+// from leaf to root and values are keyed by "type/unit".
+type simpleSample struct {
+	Stack     []simpleFrame       `json:"stack"`
+	Labels    map[string][]string `json:"labels,omitempty"`
+	NumLabels map[string][]int64  `json:"num_labels,omitempty"`
+	NumUnits  map[string][]string `json:"num_units,omitempty"`
+	Values    map[string]int64    `json:"values"`
+}
+
+// simpleFrame is a frame of a simpleSample. Inlined frames were inlined into
+// the frame that follows them and share its location.
+type simpleFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file,omitempty"`
+	Line     int64  `json:"line,omitempty"`
+	Inlined  bool   `json:"inlined,omitempty"`
+}
+
+func toSimpleJSON(prof *profile.Profile, out io.Writer) error {
+	sp := simpleProfile{
+		Period:        prof.Period,
+		TimeNanos:     prof.TimeNanos,
+		DurationNanos: prof.DurationNanos,
+		Samples:       make([]simpleSample, 0, len(prof.Sample)),
+	}
+	for _, st := range prof.SampleType {
+		name := st.Type + "/" + st.Unit
+		for _, other := range sp.SampleTypes {
+			if other == name {
+				return fmt.Errorf("duplicate sample type: %q: values of the simple format are keyed by type/unit", name)
+			}
+		}
+		sp.SampleTypes = append(sp.SampleTypes, name)
+	}
+	if pt := prof.PeriodType; pt != nil && (pt.Type != "" || pt.Unit != "") {
+		sp.PeriodType = pt.Type + "/" + pt.Unit
+	}
+
+	for _, s := range prof.Sample {
+		ss := simpleSample{
+			Stack:  []simpleFrame{},
+			Values: map[string]int64{},
+		}
+		for _, loc := range s.Location {
+			for i, line := range loc.Line {
+				ss.Stack = append(ss.Stack, simpleFrame{
+					Function: line.Function.Name,
+					File:     line.Function.Filename,
+					Line:     line.Line,
+					Inlined:  i < len(loc.Line)-1,
+				})
+			}
+		}
+		if len(s.Label) > 0 {
+			ss.Labels = s.Label
+		}
+		if len(s.NumLabel) > 0 {
+			ss.NumLabels = s.NumLabel
+		}
+		if len(s.NumUnit) > 0 {
+			ss.NumUnits = s.NumUnit
+		}
+		for i, v := range s.Value {
+			ss.Values[sp.SampleTypes[i]] = v
+		}
+		sp.Samples = append(sp.Samples, ss)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sp)
+}
+
+func fromSimpleJSON(in []byte, out io.Writer) error {
+	var sp simpleProfile
+	if err := json.Unmarshal(in, &sp); err != nil {
+		return err
+	}
+	if len(sp.SampleTypes) == 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(in, &fields); err == nil && fields["SampleType"] != nil {
+			return errors.New("input is in the full json format, convert it without the simple flag")
+		}
+		return errors.New("missing sample_types")
+	}
+
+	prof := &profile.Profile{
+		Period:        sp.Period,
+		TimeNanos:     sp.TimeNanos,
+		DurationNanos: sp.DurationNanos,
+		PeriodType:    &profile.ValueType{},
+	}
+	for i, st := range sp.SampleTypes {
+		vt, err := parseValueType(st)
+		if err != nil {
+			return err
+		}
+		for _, other := range sp.SampleTypes[:i] {
+			if other == st {
+				return fmt.Errorf("duplicate sample type: %q: values of the simple format are keyed by type/unit", st)
+			}
+		}
+		prof.SampleType = append(prof.SampleType, &vt)
+	}
+	if sp.PeriodType != "" {
+		vt, err := parseValueType(sp.PeriodType)
+		if err != nil {
+			return err
+		}
+		prof.PeriodType = &vt
+	}
+
+	m := &profile.Mapping{ID: 1, HasFunctions: true}
+	prof.Mapping = []*profile.Mapping{m}
+
+	var (
+		functions = map[simpleFrame]*profile.Function{}
+		locations = map[string]*profile.Location{}
+	)
+	for i, ss := range sp.Samples {
+		s := &profile.Sample{
+			Value:    make([]int64, len(prof.SampleType)),
+			Label:    ss.Labels,
+			NumLabel: ss.NumLabels,
+			NumUnit:  ss.NumUnits,
+		}
+		for st, v := range ss.Values {
+			idx := -1
+			for j, name := range sp.SampleTypes {
+				if name == st {
+					idx = j
+				}
+			}
+			if idx < 0 {
+				return fmt.Errorf("sample %d: unknown sample type: %q", i, st)
+			}
+			s.Value[idx] = v
+		}
+
+		for len(ss.Stack) > 0 {
+			// A location holds a frame along with the frames inlined into it.
+			n := 1
+			for n < len(ss.Stack) && ss.Stack[n-1].Inlined {
+				n++
+			}
+			frames := ss.Stack[:n]
+			ss.Stack = ss.Stack[n:]
+
+			locKey, err := json.Marshal(frames)
+			if err != nil {
+				return err
+			}
+			loc := locations[string(locKey)]
+			if loc == nil {
+				loc = &profile.Location{
+					ID:      uint64(len(prof.Location) + 1),
+					Mapping: m,
+				}
+				for _, frame := range frames {
+					fnKey := simpleFrame{Function: frame.Function, File: frame.File}
+					fn := functions[fnKey]
+					if fn == nil {
+						fn = &profile.Function{
+							ID:       uint64(len(prof.Function) + 1),
+							Name:     frame.Function,
+							Filename: frame.File,
+						}
+						functions[fnKey] = fn
+						prof.Function = append(prof.Function, fn)
+					}
+					loc.Line = append(loc.Line, profile.Line{Function: fn, Line: frame.Line})
+				}
+				locations[string(locKey)] = loc
+				prof.Location = append(prof.Location, loc)
+			}
+			s.Location = append(s.Location, loc)
+		}
+		prof.Sample = append(prof.Sample, s)
+	}
+
+	if err := prof.CheckValid(); err != nil {
+		return err
+	}
+	return prof.Write(out)
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSimple(t *testing.T) {
+	var (
+		fn     = &profile.Function{ID: 1, Name: "main.foo", Filename: "main.go"}
+		inline = &profile.Function{ID: 2, Name: "main.inline", Filename: "main.go"}
+		loc    = &profile.Location{ID: 1, Line: []profile.Line{{Function: inline, Line: 3}, {Function: fn, Line: 12}}}
+		prof   = &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+			PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			Period:     10,
+			Sample: []*profile.Sample{{
+				Location: []*profile.Location{loc},
+				Value:    []int64{1, 10},
+				Label:    map[string][]string{"region": {"us"}},
+			}},
+			Location: []*profile.Location{loc},
+			Function: []*profile.Function{fn, inline},
+		}
+		in = &bytes.Buffer{}
+	)
+	require.NoError(t, prof.Write(in))
+
+	jsonOut := &bytes.Buffer{}
+	require.NoError(t, (&JSON{Input: in.Bytes(), Output: jsonOut, Simple: true}).Execute(context.Background()))
+	require.JSONEq(t, `{
+  "sample_types": ["samples/count", "cpu/nanoseconds"],
+  "period_type": "cpu/nanoseconds",
+  "period": 10,
+  "samples": [{
+    "stack": [
+      {"function": "main.inline", "file": "main.go", "line": 3, "inlined": true},
+      {"function": "main.foo", "file": "main.go", "line": 12}
+    ],
+    "labels": {"region": ["us"]},
+    "values": {"samples/count": 1, "cpu/nanoseconds": 10}
+  }]
+}`, jsonOut.String())
+
+	pprofOut := &bytes.Buffer{}
+	require.NoError(t, (&JSON{Input: jsonOut.Bytes(), Output: pprofOut, Simple: true}).Execute(context.Background()))
+	got, err := profile.Parse(pprofOut)
+	require.NoError(t, err)
+	require.Equal(t, "samples/count cpu/nanoseconds", formatSampleTypes(got))
+	require.Len(t, got.Sample, 1)
+	require.Equal(t, []int64{1, 10}, got.Sample[0].Value)
+	require.Equal(t, []string{"us"}, got.Sample[0].Label["region"])
+	require.Len(t, got.Sample[0].Location, 1)
+	lines := got.Sample[0].Location[0].Line
+	require.Len(t, lines, 2)
+	require.Equal(t, "main.inline", lines[0].Function.Name)
+	require.Equal(t, int64(3), lines[0].Line)
+	require.Equal(t, "main.foo", lines[1].Function.Name)
+	require.Equal(t, "main.go", lines[1].Function.Filename)
+	require.Equal(t, int64(12), lines[1].Line)
+
+	t.Run("full json input", func(t *testing.T) {
+		fullOut := &bytes.Buffer{}
+		require.NoError(t, (&JSON{Input: in.Bytes(), Output: fullOut}).Execute(context.Background()))
+		err := (&JSON{Input: fullOut.Bytes(), Output: &bytes.Buffer{}, Simple: true}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor simple json: input is in the full json format, convert it without the simple flag")
+	})
+
+	t.Run("duplicate sample types", func(t *testing.T) {
+		dup := foldedProfile(t, "samples/count samples/count\nmain 1 2")
+		err := (&JSON{Input: dup, Output: &bytes.Buffer{}, Simple: true}).Execute(context.Background())
+		require.EqualError(t, err, `duplicate sample type: "samples/count": values of the simple format are keyed by type/unit`)
+
+		in := []byte(`{"sample_types": ["samples/count", "samples/count"], "samples": []}`)
+		err = (&JSON{Input: in, Output: &bytes.Buffer{}, Simple: true}).Execute(context.Background())
+		require.EqualError(t, err, `input format is neither pprof nor simple json: duplicate sample type: "samples/count": values of the simple format are keyed by type/unit`)
+	})
+
+	t.Run("missing sample types", func(t *testing.T) {
+		err := (&JSON{Input: []byte(`{"samples": []}`), Output: &bytes.Buffer{}, Simple: true}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor simple json: missing sample_types")
+	})
+}
@@ -4,15 +4,30 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/pprof/profile"
 )
 
+// Labelframes adds virtual frames for the values of the given labels to every
+// sample, e.g. to visualize them in a flame graph.
 type Labelframes struct {
 	Input  []byte
 	Output io.Writer
-	Label  string
+	// Label is a comma separated list of label keys. The frames are added in
+	// the same order from caller to callee.
+	Label string
+	// Position is either "root" or "leaf" and determines which end of the
+	// stack the frames are added to.
+	Position string
+	// Buckets holds key=boundary,... pairs for numeric labels, e.g.
+	// "bytes=1KB,4KB,64KB". The values of these labels are replaced by the
+	// bucket they fall into if their unit matches the boundaries, see
+	// labelBuckets.
+	Buckets []string
 }
 
 func (l *Labelframes) Execute(ctx context.Context) error {
@@ -21,6 +36,31 @@ func (l *Labelframes) Execute(ctx context.Context) error {
 		return err
 	}
 
+	var keys []string
+	for _, key := range strings.Split(l.Label, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("label must not be empty")
+	}
+	switch l.Position {
+	case "root", "", "leaf":
+	default:
+		return fmt.Errorf("unknown position: %q: must be root or leaf", l.Position)
+	}
+	buckets := map[string]*labelBuckets{}
+	for _, b := range l.Buckets {
+		key, bounds, ok := strings.Cut(b, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("bad buckets: %q: must be key=boundary,...", b)
+		}
+		if buckets[key], err = parseLabelBuckets(bounds); err != nil {
+			return fmt.Errorf("bad buckets: %q: %w", b, err)
+		}
+	}
+
 	var maxLocID uint64
 	for _, loc := range prof.Location {
 		if loc.ID > uint64(maxLocID) {
@@ -34,39 +74,140 @@ func (l *Labelframes) Execute(ctx context.Context) error {
 		}
 	}
 
+	// The virtual locations are shared by all samples with the same label
+	// value, regardless of the values of the other labels.
 	locIDX := map[string]*profile.Location{}
 	for _, s := range prof.Sample {
-		var labelVal = "N/A"
-		for k, v := range s.Label {
-			if k != l.Label {
-				continue
+		// frames are ordered from callee to caller like s.Location.
+		frames := make([]*profile.Location, len(keys))
+		for i, key := range keys {
+			var frame string
+			if b := buckets[key]; b != nil && len(s.NumLabel[key]) > 0 {
+				frame = b.frame(s, key)
+			} else {
+				labelVal, ok := labelValue(s, key)
+				if !ok {
+					labelVal = "N/A"
+				}
+				frame = fmt.Sprintf("%s=%s", key, labelVal)
 			}
+			loc := locIDX[frame]
+			if loc == nil {
+				maxFuncID++
+				fn := &profile.Function{
+					ID:   maxFuncID,
+					Name: frame,
+				}
+				prof.Function = append(prof.Function, fn)
 
-			labelVal = strings.Join(v, ",")
-			break
-		}
-
-		frame := fmt.Sprintf("%s=%s", l.Label, labelVal)
-		loc := locIDX[frame]
-		if loc == nil {
-			maxFuncID++
-			fn := &profile.Function{
-				ID:   maxFuncID,
-				Name: frame,
-			}
-			prof.Function = append(prof.Function, fn)
-
-			maxLocID++
-			loc = &profile.Location{
-				ID:   maxLocID,
-				Line: []profile.Line{{Function: fn}},
+				maxLocID++
+				loc = &profile.Location{
+					ID:   maxLocID,
+					Line: []profile.Line{{Function: fn}},
+				}
+				prof.Location = append(prof.Location, loc)
+				locIDX[frame] = loc
 			}
-			prof.Location = append(prof.Location, loc)
-			locIDX[frame] = loc
+			frames[len(keys)-1-i] = loc
 		}
 
-		s.Location = append(s.Location, loc)
+		if l.Position == "leaf" {
+			s.Location = append(frames, s.Location...)
+		} else {
+			// The locations of all samples may share the same backing array,
+			// so appending must not write past the end of s.Location.
+			n := len(s.Location)
+			s.Location = append(s.Location[:n:n], frames...)
+		}
 	}
 
 	return prof.Write(l.Output)
 }
+
+// labelBuckets are the buckets for the values of a numeric label. Only values
+// with a unit matching the boundaries are bucketed: byte sizes match bytes,
+// durations match nanoseconds and plain integers match values without a unit
+// or with a count unit.
+type labelBuckets struct {
+	unit    string
+	buckets []labelBucket
+}
+
+// labelBucket is the lower bound of a bucket for numeric label values.
+type labelBucket struct {
+	name  string
+	value int64
+}
+
+// matches returns true if values with the given unit can be bucketed.
+func (b *labelBuckets) matches(unit string) bool {
+	if b.unit == "" {
+		return unit == "" || unit == "count"
+	}
+	return unit == b.unit
+}
+
+// frame returns the name of the frame for the buckets of the values of the
+// numeric label with the given key, e.g. "bytes<1KB", "1KB<=bytes<4KB" or
+// "bytes>=64KB". Values with a unit that doesn't match the boundaries are
+// formatted like key=value instead. Multiple values are joined by commas.
+func (b *labelBuckets) frame(s *profile.Sample, key string) string {
+	var frames []string
+	for i, v := range s.NumLabel[key] {
+		if unit := numLabelUnit(s, key, i); !b.matches(unit) {
+			frames = append(frames, key+"="+formatNumLabel(v, unit))
+			continue
+		}
+		j := 0
+		for j < len(b.buckets) && v >= b.buckets[j].value {
+			j++
+		}
+		switch {
+		case j == 0:
+			frames = append(frames, key+"<"+b.buckets[0].name)
+		case j == len(b.buckets):
+			frames = append(frames, key+">="+b.buckets[j-1].name)
+		default:
+			frames = append(frames, b.buckets[j-1].name+"<="+key+"<"+b.buckets[j].name)
+		}
+	}
+	return strings.Join(frames, ",")
+}
+
+var byteSizeRegexp = regexp.MustCompile(`^(?i)(\d+(?:\.\d+)?)\s*(b|kb|kib|mb|mib|gb|gib|tb|tib)$`)
+
+// parseLabelBuckets parses a comma separated list of ascending bucket
+// boundaries. Boundaries are either all byte sizes like "64KB", all durations
+// like "10ms", which are converted to nanoseconds, or all integers.
+func parseLabelBuckets(bounds string) (*labelBuckets, error) {
+	b := &labelBuckets{}
+	for i, name := range strings.Split(bounds, ",") {
+		name = strings.TrimSpace(name)
+		var (
+			value int64
+			unit  string
+			err   error
+		)
+		if m := byteSizeRegexp.FindStringSubmatch(name); m != nil {
+			f, _ := strconv.ParseFloat(m[1], 64)
+			shift := map[string]int{"b": 0, "k": 10, "m": 20, "g": 30, "t": 40}[strings.ToLower(m[2][:1])]
+			value, unit = int64(f*float64(int64(1)<<shift)), "bytes"
+		} else if value, err = strconv.ParseInt(name, 10, 64); err != nil {
+			d, derr := time.ParseDuration(name)
+			if derr != nil {
+				return nil, fmt.Errorf("bad boundary: %q", name)
+			}
+			value, unit = int64(d), "nanoseconds"
+		}
+		if i == 0 {
+			b.unit = unit
+		} else if unit != b.unit {
+			return nil, fmt.Errorf("boundaries must all be byte sizes, durations or integers: %q", bounds)
+		}
+		if len(b.buckets) > 0 && value <= b.buckets[len(b.buckets)-1].value {
+			return nil, fmt.Errorf("boundaries must be ascending: %q", bounds)
+		}
+		b.buckets = append(b.buckets, labelBucket{name: name, value: value})
+	}
+	return b, nil
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelframes(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+{endpoint=/foo,size=#512:bytes} main;foo 1
+{endpoint=/foo,size=#2048:bytes} main;foo 2
+{endpoint=/bar,size=#100000:bytes} main;bar 3
+{size=#10:count} main 4
+`)
+
+	tests := []struct {
+		name        string
+		labelframes Labelframes
+		want        map[string]int64
+		functions   int
+	}{
+		{
+			name:        "root",
+			labelframes: Labelframes{Label: "endpoint,size"},
+			want: map[string]int64{
+				"endpoint=/foo;size=512B;main;foo":    1,
+				"endpoint=/foo;size=2.00kB;main;foo":  2,
+				"endpoint=/bar;size=97.66kB;main;bar": 3,
+				"endpoint=N/A;size=10count;main":      4,
+			},
+			functions: 3 + 3 + 4,
+		},
+		{
+			name:        "leaf buckets",
+			labelframes: Labelframes{Label: "size, endpoint", Position: "leaf", Buckets: []string{"size=1KB,4KB,64KB"}},
+			want: map[string]int64{
+				"main;foo;size<1KB;endpoint=/foo":      1,
+				"main;foo;1KB<=size<4KB;endpoint=/foo": 2,
+				"main;bar;size>=64KB;endpoint=/bar":    3,
+				"main;size=10count;endpoint=N/A":       4,
+			},
+			functions: 3 + 4 + 3,
+		},
+		{
+			name:        "duration buckets",
+			labelframes: Labelframes{Label: "size", Buckets: []string{"size=1ms"}},
+			want: map[string]int64{
+				"size=512B;main;foo":    1,
+				"size=2.00kB;main;foo":  2,
+				"size=97.66kB;main;bar": 3,
+				"size=10count;main":     4,
+			},
+			functions: 3 + 4,
+		},
+		{
+			name:        "integer buckets",
+			labelframes: Labelframes{Label: "size", Buckets: []string{"size=5,20"}},
+			want: map[string]int64{
+				"size=512B;main;foo":    1,
+				"size=2.00kB;main;foo":  2,
+				"size=97.66kB;main;bar": 3,
+				"5<=size<20;main":       4,
+			},
+			functions: 3 + 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.labelframes.Input, tt.labelframes.Output = in, out
+			require.NoError(t, tt.labelframes.Execute(context.Background()))
+			prof, err := profile.ParseData(out.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, tt.want, sampleValues(prof, "", 0))
+			// The virtual functions are shared by all samples.
+			require.Len(t, prof.Function, tt.functions)
+		})
+	}
+
+	t.Run("bad buckets", func(t *testing.T) {
+		err := (&Labelframes{Input: in, Output: &bytes.Buffer{}, Label: "size", Buckets: []string{"size=4KB,1KB"}}).Execute(context.Background())
+		require.EqualError(t, err, `bad buckets: "size=4KB,1KB": boundaries must be ascending: "4KB,1KB"`)
+
+		err = (&Labelframes{Input: in, Output: &bytes.Buffer{}, Label: "size", Buckets: []string{"size=1KB,1s"}}).Execute(context.Background())
+		require.EqualError(t, err, `bad buckets: "size=1KB,1s": boundaries must all be byte sizes, durations or integers: "1KB,1s"`)
+	})
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Labels modifies the labels of all samples in a profile. The operations are
+// applied in the order Rename, Delete, Keep, Set and TagNumeric. Samples that
+// end up with identical labels and stacks are merged.
+type Labels struct {
+	Input  []byte
+	Output io.Writer
+	// Set holds key=value pairs that are set on every sample, replacing
+	// existing labels with the same key.
+	Set []string
+	// Delete holds the keys of labels to remove. Each entry may contain a
+	// comma separated list of keys.
+	Delete []string
+	// Rename holds old=new pairs of label keys to rename.
+	Rename []string
+	// Keep holds the keys of labels to keep, all other labels are removed.
+	// Each entry may contain a comma separated list of keys. All labels are
+	// kept if it is empty.
+	Keep []string
+	// TagNumeric holds key=unit pairs of string labels to convert into
+	// numeric labels with the given unit. The unit is optional. Values that
+	// are not integers are kept as string labels.
+	TagNumeric []string
+}
+
+func (l *Labels) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(l.Input)
+	if err != nil {
+		return err
+	}
+	set, err := parseKeyValues("set", l.Set, true)
+	if err != nil {
+		return err
+	}
+	rename, err := parseKeyValues("rename", l.Rename, true)
+	if err != nil {
+		return err
+	}
+	tagNumeric, err := parseKeyValues("tag_numeric", l.TagNumeric, false)
+	if err != nil {
+		return err
+	}
+	del := splitKeys(l.Delete)
+	keep := splitKeys(l.Keep)
+
+	for _, s := range prof.Sample {
+		for _, kv := range rename {
+			renameLabel(s, kv[0], kv[1])
+		}
+		for key := range del {
+			deleteLabel(s, key)
+		}
+		if len(keep) > 0 {
+			for key := range s.Label {
+				if !keep[key] {
+					deleteLabel(s, key)
+				}
+			}
+			for key := range s.NumLabel {
+				if !keep[key] {
+					deleteLabel(s, key)
+				}
+			}
+		}
+		for _, kv := range set {
+			deleteLabel(s, kv[0])
+			if s.Label == nil {
+				s.Label = map[string][]string{}
+			}
+			s.Label[kv[0]] = []string{kv[1]}
+		}
+		for _, kv := range tagNumeric {
+			tagNumericLabel(s, kv[0], kv[1])
+		}
+	}
+
+	merged, err := profile.Merge([]*profile.Profile{prof})
+	if err != nil {
+		return err
+	}
+	return merged.Write(l.Output)
+}
+
+// parseKeyValues parses key=value pairs. The value is optional unless
+// required is true.
+func parseKeyValues(name string, pairs []string, required bool) ([][2]string, error) {
+	var result [][2]string
+	for _, pair := range pairs {
+		key, val, ok := strings.Cut(pair, "=")
+		if key == "" || (required && !ok) {
+			return nil, fmt.Errorf("bad %s: %q: must be key=value", name, pair)
+		}
+		result = append(result, [2]string{key, val})
+	}
+	return result, nil
+}
+
+// splitKeys returns the set of comma separated keys in the given lists.
+func splitKeys(lists []string) map[string]bool {
+	keys := map[string]bool{}
+	for _, list := range lists {
+		for _, key := range strings.Split(list, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// deleteLabel removes the string and numeric label with the given key from s.
+func deleteLabel(s *profile.Sample, key string) {
+	delete(s.Label, key)
+	delete(s.NumLabel, key)
+	delete(s.NumUnit, key)
+}
+
+// renameLabel renames the string and numeric label with the given key. An
+// existing label with the new key is replaced.
+func renameLabel(s *profile.Sample, from, to string) {
+	if from == to {
+		return
+	}
+	vals, ok := s.Label[from]
+	numVals, numOK := s.NumLabel[from]
+	if !ok && !numOK {
+		return
+	}
+	units := s.NumUnit[from]
+	deleteLabel(s, from)
+	deleteLabel(s, to)
+	if ok {
+		s.Label[to] = vals
+	}
+	if numOK {
+		s.NumLabel[to] = numVals
+		if units != nil {
+			s.NumUnit[to] = units
+		}
+	}
+}
+
+// tagNumericLabel converts the string label with the given key into a numeric
+// label with the given unit if all of its values are integers.
+func tagNumericLabel(s *profile.Sample, key, unit string) {
+	vals, ok := s.Label[key]
+	if !ok {
+		return
+	}
+	var (
+		nums  = make([]int64, len(vals))
+		units []string
+		err   error
+	)
+	for i, v := range vals {
+		if nums[i], err = strconv.ParseInt(strings.TrimSpace(v), 10, 64); err != nil {
+			return
+		}
+		if unit != "" {
+			units = append(units, unit)
+		}
+	}
+	deleteLabel(s, key)
+	if s.NumLabel == nil {
+		s.NumLabel = map[string][]int64{}
+	}
+	s.NumLabel[key] = nums
+	if units != nil {
+		if s.NumUnit == nil {
+			s.NumUnit = map[string][]string{}
+		}
+		s.NumUnit[key] = units
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+{endpoint=/foo,span_id=1,trace_id=a,size=#10:bytes} main 1
+{endpoint=/foo,span_id=2,trace_id=b} main 2
+{endpoint=/bar,region=eu,trace_id=c} main 4
+`)
+
+	// labels returns the values of the samples keyed by their sorted labels.
+	labels := func(t *testing.T, data []byte) map[string]int64 {
+		prof, err := profile.ParseData(data)
+		require.NoError(t, err)
+		result := map[string]int64{}
+		for _, s := range prof.Sample {
+			var kvs []string
+			for k, v := range s.Label {
+				kvs = append(kvs, k+"="+strings.Join(v, ","))
+			}
+			for k, v := range s.NumLabel {
+				kvs = append(kvs, fmt.Sprintf("%s=#%v%v", k, v, s.NumUnit[k]))
+			}
+			sort.Strings(kvs)
+			result[strings.Join(kvs, " ")] += s.Value[0]
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		labels Labels
+		want   map[string]int64
+	}{
+		{
+			name:   "delete and set",
+			labels: Labels{Delete: []string{"trace_id,size", "span_id"}, Set: []string{"env=prod", "endpoint=/all"}},
+			want: map[string]int64{
+				"endpoint=/all env=prod":           3,
+				"endpoint=/all env=prod region=eu": 4,
+			},
+		},
+		{
+			name:   "rename and keep",
+			labels: Labels{Rename: []string{"span_id=span"}, Keep: []string{"span,size"}},
+			want: map[string]int64{
+				"size=#[10][bytes] span=1": 1,
+				"span=2":                   2,
+				"":                         4,
+			},
+		},
+		{
+			name:   "tag numeric",
+			labels: Labels{Keep: []string{"span_id", "trace_id"}, TagNumeric: []string{"span_id=count", "trace_id"}},
+			want: map[string]int64{
+				"span_id=#[1][count] trace_id=a": 1,
+				"span_id=#[2][count] trace_id=b": 2,
+				"trace_id=c":                     4,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			tt.labels.Input, tt.labels.Output = in, out
+			require.NoError(t, tt.labels.Execute(context.Background()))
+			require.Equal(t, tt.want, labels(t, out.Bytes()))
+		})
+	}
+
+	t.Run("bad set", func(t *testing.T) {
+		err := (&Labels{Input: in, Output: &bytes.Buffer{}, Set: []string{"env"}}).Execute(context.Background())
+		require.EqualError(t, err, `bad set: "env": must be key=value`)
+	})
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/pprof/profile"
+)
+
+// Merge combines multiple profiles into a single profile.
+type Merge struct {
+	Inputs [][]byte
+	// InputNames holds the name of each input, e.g. its file name. It is used
+	// for error messages and the values of the SourceLabel.
+	InputNames []string
+	Output     io.Writer
+	// SourceLabel is the key of a label that is added to every sample with the
+	// name of the input it came from. No label is added if it is empty.
+	SourceLabel string
+	// Force causes profiles with different sample types to be merged by adding
+	// zero values for the sample types missing from each profile.
+	Force bool
+}
+
+func (m *Merge) Execute(ctx context.Context) error {
+	if m.SourceLabel != "" {
+		seen := map[string]bool{}
+		for i := range m.Inputs {
+			name := m.inputName(i)
+			if seen[name] {
+				return fmt.Errorf("duplicate input name %q: the %s label would not identify the source of samples", name, m.SourceLabel)
+			}
+			seen[name] = true
+		}
+	}
+
+	var profs []*profile.Profile
+	for i, input := range m.Inputs {
+		prof, err := profile.ParseData(input)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.inputName(i), err)
+		}
+		if m.SourceLabel != "" {
+			for _, s := range prof.Sample {
+				if s.Label == nil {
+					s.Label = map[string][]string{}
+				}
+				s.Label[m.SourceLabel] = []string{m.inputName(i)}
+			}
+		}
+		profs = append(profs, prof)
+	}
+
+	if m.Force {
+		alignSampleTypes(profs)
+	} else {
+		for i, prof := range profs[1:] {
+			if a, b := formatSampleTypes(profs[0]), formatSampleTypes(prof); a != b {
+				return fmt.Errorf(
+					"sample types of %s (%s) and %s (%s) differ, use -force to merge them anyway",
+					m.inputName(0), a, m.inputName(i+1), b,
+				)
+			}
+		}
+	}
+
+	merged, err := profile.Merge(profs)
+	if err != nil {
+		return err
+	}
+	return merged.Write(m.Output)
+}
+
+func (m *Merge) inputName(i int) string {
+	if i < len(m.InputNames) && m.InputNames[i] != "" {
+		return m.InputNames[i]
+	}
+	return fmt.Sprintf("input %d", i+1)
+}
+
+// alignSampleTypes changes the given profiles to have the same sample types by
+// adding zero values for any sample types they are missing. The period type of
+// the first profile is used for all profiles.
+func alignSampleTypes(profs []*profile.Profile) {
+	var (
+		all   []*profile.ValueType
+		index = map[string]int{}
+	)
+	for _, prof := range profs {
+		for _, st := range prof.SampleType {
+			key := st.Type + "/" + st.Unit
+			if _, ok := index[key]; !ok {
+				index[key] = len(all)
+				all = append(all, &profile.ValueType{Type: st.Type, Unit: st.Unit})
+			}
+		}
+	}
+
+	periodType := profs[0].PeriodType
+	if periodType == nil {
+		periodType = &profile.ValueType{}
+	}
+
+	for _, prof := range profs {
+		for _, s := range prof.Sample {
+			value := make([]int64, len(all))
+			for i, st := range prof.SampleType {
+				value[index[st.Type+"/"+st.Unit]] = s.Value[i]
+			}
+			s.Value = value
+		}
+		prof.SampleType = make([]*profile.ValueType, len(all))
+		for i, st := range all {
+			prof.SampleType[i] = &profile.ValueType{Type: st.Type, Unit: st.Unit}
+		}
+		prof.PeriodType = &profile.ValueType{Type: periodType.Type, Unit: periodType.Unit}
+	}
+}
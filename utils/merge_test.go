@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	var (
+		a = foldedProfile(t, "main;foo 5\nmain;bar 3")
+		b = foldedProfile(t, "main;foo 2")
+		c = foldedProfile(t, "x/count y/count\nmain;foo 1 2")
+	)
+
+	t.Run("source label", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		m := &Merge{
+			Inputs:      [][]byte{a, b},
+			InputNames:  []string{"a.pprof", "b.pprof"},
+			Output:      buf,
+			SourceLabel: "host",
+		}
+		require.NoError(t, m.Execute(context.Background()))
+
+		prof, err := profile.Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{
+			"a.pprof main;bar": 3,
+			"a.pprof main;foo": 5,
+			"b.pprof main;foo": 2,
+		}, sampleValues(prof, "host", 0))
+	})
+
+	t.Run("duplicate source names", func(t *testing.T) {
+		m := &Merge{
+			Inputs:      [][]byte{a, b},
+			InputNames:  []string{"cpu.pprof", "cpu.pprof"},
+			Output:      &bytes.Buffer{},
+			SourceLabel: "host",
+		}
+		err := m.Execute(context.Background())
+		require.EqualError(t, err, `duplicate input name "cpu.pprof": the host label would not identify the source of samples`)
+	})
+
+	t.Run("different sample types", func(t *testing.T) {
+		m := &Merge{Inputs: [][]byte{a, c}, InputNames: []string{"a.pprof", "c.pprof"}, Output: &bytes.Buffer{}}
+		err := m.Execute(context.Background())
+		require.EqualError(t, err, "sample types of a.pprof (samples/count) and c.pprof (x/count y/count) differ, use -force to merge them anyway")
+	})
+
+	t.Run("force", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		m := &Merge{Inputs: [][]byte{a, c}, Output: buf, Force: true}
+		require.NoError(t, m.Execute(context.Background()))
+
+		prof, err := profile.Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count x/count y/count", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{"main;bar": 0, "main;foo": 1}, sampleValues(prof, "", 1))
+		require.Equal(t, map[string]int64{"main;bar": 3, "main;foo": 5}, sampleValues(prof, "", 0))
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		m := &Merge{Inputs: [][]byte{withMetadata(t, a, 1e9), withMetadata(t, b, 2e9)}, Output: buf}
+		require.NoError(t, m.Execute(context.Background()))
+
+		prof, err := profile.Parse(buf)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count", formatSampleTypes(prof))
+		require.Equal(t, &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}, prof.PeriodType)
+		require.Equal(t, int64(10000000), prof.Period)
+		require.Equal(t, int64(3e9), prof.DurationNanos)
+		// The identical mappings of both profiles are merged.
+		require.Len(t, prof.Mapping, 1)
+		require.Equal(t, "/usr/bin/app", prof.Mapping[0].File)
+		require.Equal(t, "abc", prof.Mapping[0].BuildID)
+		for _, loc := range prof.Location {
+			require.Equal(t, prof.Mapping[0], loc.Mapping)
+		}
+	})
+}
+
+// withMetadata returns the given profile with a period, duration and a mapping
+// for all of its locations.
+func withMetadata(t *testing.T, data []byte, durationNanos int64) []byte {
+	prof, err := profile.ParseData(data)
+	require.NoError(t, err)
+	prof.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+	prof.Period = 10000000
+	prof.DurationNanos = durationNanos
+	prof.Mapping[0].File = "/usr/bin/app"
+	prof.Mapping[0].BuildID = "abc"
+	buf := &bytes.Buffer{}
+	require.NoError(t, prof.Write(buf))
+	return buf.Bytes()
+}
+
+// foldedProfile converts the given folded text into a pprof profile.
+func foldedProfile(t *testing.T, folded string) []byte {
+	prof, err := legacy.Text{}.Convert(strings.NewReader(folded))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	require.NoError(t, prof.Write(buf))
+	return buf.Bytes()
+}
+
+// sampleValues returns the values of the sample type with the given index
+// keyed by the folded stack of each sample. If label is not empty, the value
+// of the label is prepended to the key.
+func sampleValues(prof *profile.Profile, label string, idx int) map[string]int64 {
+	values := map[string]int64{}
+	for _, s := range prof.Sample {
+		var frames []string
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			for j := len(s.Location[i].Line) - 1; j >= 0; j-- {
+				frames = append(frames, s.Location[i].Line[j].Function.Name)
+			}
+		}
+		key := strings.Join(frames, ";")
+		if label != "" {
+			key = strings.Join(s.Label[label], ",") + " " + key
+		}
+		values[key] += s.Value[idx]
+	}
+	return values
+}
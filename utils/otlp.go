@@ -0,0 +1,1037 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// OTLP converts pprof to the OpenTelemetry profiles signal (ProfilesData
+// protobuf) and vice versa. The direction is determined by the input format.
+//
+// The profiles signal is still in development and its wire format changes
+// between releases, so OTLP implements the layout of exactly one release:
+// opentelemetry-proto v1.5.0 (go.opentelemetry.io/proto/otlp v1.5.0). Later
+// releases that move the tables into a ProfilesDictionary are rejected.
+type OTLP struct {
+	Input  []byte
+	Output io.Writer
+	// ResourceAttributes is a comma separated list of key=value pairs, e.g.
+	// "service.name=api,deployment.environment=prod", that are added to the
+	// resource of the OTLP output.
+	ResourceAttributes string
+}
+
+func (o *OTLP) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(o.Input)
+	if err == nil {
+		attrs, err := parseOTLPAttributes(o.ResourceAttributes)
+		if err != nil {
+			return err
+		}
+		data := toOTLP(prof, attrs, sha256.Sum256(o.Input))
+		_, err = o.Output.Write(data.encode())
+		return err
+	}
+
+	input := o.Input
+	if len(input) > 2 && input[0] == 0x1f && input[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(input))
+		if err != nil {
+			return err
+		}
+		if input, err = io.ReadAll(gz); err != nil {
+			return err
+		}
+	}
+	var data otlpProfilesData
+	if err := data.decode(input); errors.Is(err, errOTLPDictionary) {
+		return err
+	} else if err != nil || len(data.ResourceProfiles) == 0 {
+		return errors.New("input format is neither pprof nor OTLP profiles protobuf")
+	}
+	prof, err = fromOTLP(&data)
+	if err != nil {
+		return err
+	}
+	return prof.Write(o.Output)
+}
+
+// parseOTLPAttributes parses a comma separated list of key=value pairs.
+func parseOTLPAttributes(s string) ([]otlpKeyValue, error) {
+	var attrs []otlpKeyValue
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("bad resource attribute: %q: must be key=value", kv)
+		}
+		attrs = append(attrs, otlpKeyValue{Key: strings.TrimSpace(key), Value: otlpValue{Str: &val}})
+	}
+	return attrs, nil
+}
+
+// otlpBuildIDKey is the attribute key used for the build ids of mappings.
+const otlpBuildIDKey = "process.executable.build_id.gnu"
+
+func toOTLP(prof *profile.Profile, resourceAttrs []otlpKeyValue, id [32]byte) *otlpProfilesData {
+	p := &otlpProfile{
+		Strings:       []string{""},
+		TimeNanos:     prof.TimeNanos,
+		DurationNanos: prof.DurationNanos,
+		Period:        prof.Period,
+		ProfileID:     id[:16],
+	}
+	var (
+		strIdx   = map[string]int64{"": 0}
+		attrIdx  = map[string]int64{}
+		units    = map[string]bool{}
+		mappings = map[*profile.Mapping]int64{}
+		funcs    = map[*profile.Function]int64{}
+		locs     = map[*profile.Location]int64{}
+	)
+	str := func(s string) int64 {
+		idx, ok := strIdx[s]
+		if !ok {
+			idx = int64(len(p.Strings))
+			strIdx[s] = idx
+			p.Strings = append(p.Strings, s)
+		}
+		return idx
+	}
+	attr := func(kv otlpKeyValue) int64 {
+		key := string(kv.encode())
+		idx, ok := attrIdx[key]
+		if !ok {
+			idx = int64(len(p.Attributes))
+			attrIdx[key] = idx
+			p.Attributes = append(p.Attributes, kv)
+		}
+		return idx
+	}
+	valueType := func(vt *profile.ValueType) otlpValueType {
+		return otlpValueType{Type: str(vt.Type), Unit: str(vt.Unit)}
+	}
+
+	for _, st := range prof.SampleType {
+		p.SampleTypes = append(p.SampleTypes, valueType(st))
+	}
+	if prof.PeriodType != nil {
+		vt := valueType(prof.PeriodType)
+		p.PeriodType = &vt
+	}
+	for _, c := range prof.Comments {
+		p.Comments = append(p.Comments, str(c))
+	}
+	if prof.DefaultSampleType != "" {
+		p.DefaultSampleType = str(prof.DefaultSampleType)
+	}
+
+	for _, m := range prof.Mapping {
+		om := otlpMapping{
+			MemoryStart:     m.Start,
+			MemoryLimit:     m.Limit,
+			FileOffset:      m.Offset,
+			Filename:        str(m.File),
+			HasFunctions:    m.HasFunctions,
+			HasFilenames:    m.HasFilenames,
+			HasLineNumbers:  m.HasLineNumbers,
+			HasInlineFrames: m.HasInlineFrames,
+		}
+		if m.BuildID != "" {
+			buildID := m.BuildID
+			om.Attributes = []int64{attr(otlpKeyValue{Key: otlpBuildIDKey, Value: otlpValue{Str: &buildID}})}
+		}
+		mappings[m] = int64(len(p.Mappings))
+		p.Mappings = append(p.Mappings, om)
+	}
+	for _, fn := range prof.Function {
+		funcs[fn] = int64(len(p.Functions))
+		p.Functions = append(p.Functions, otlpFunction{
+			Name:       str(fn.Name),
+			SystemName: str(fn.SystemName),
+			Filename:   str(fn.Filename),
+			StartLine:  fn.StartLine,
+		})
+	}
+	for _, loc := range prof.Location {
+		ol := otlpLocation{Mapping: -1, Address: loc.Address, IsFolded: loc.IsFolded}
+		if loc.Mapping != nil {
+			ol.Mapping = mappings[loc.Mapping]
+		}
+		for _, line := range loc.Line {
+			ol.Lines = append(ol.Lines, otlpLine{Function: funcs[line.Function], Line: line.Line, Column: line.Column})
+		}
+		locs[loc] = int64(len(p.Locations))
+		p.Locations = append(p.Locations, ol)
+	}
+
+	for _, s := range prof.Sample {
+		sample := otlpSample{
+			LocationsStart:  int64(len(p.LocationIndices)),
+			LocationsLength: int64(len(s.Location)),
+			Values:          s.Value,
+		}
+		for _, loc := range s.Location {
+			p.LocationIndices = append(p.LocationIndices, locs[loc])
+		}
+		for _, key := range legacy.SortedKeys(s.Label) {
+			sample.Attributes = append(sample.Attributes, attr(otlpKeyValue{Key: key, Value: otlpStrings(s.Label[key])}))
+		}
+		for _, key := range legacy.SortedKeys(s.NumLabel) {
+			sample.Attributes = append(sample.Attributes, attr(otlpKeyValue{Key: key, Value: otlpInts(s.NumLabel[key])}))
+			if unit := firstNonEmpty(s.NumUnit[key]); unit != "" && !units[key] {
+				units[key] = true
+				p.AttributeUnits = append(p.AttributeUnits, otlpAttributeUnit{Key: str(key), Unit: str(unit)})
+			}
+		}
+		p.Samples = append(p.Samples, sample)
+	}
+
+	return &otlpProfilesData{ResourceProfiles: []otlpResourceProfiles{{
+		Resource: resourceAttrs,
+		ScopeProfiles: []otlpScopeProfiles{{
+			ScopeName: "pprofutils",
+			Profiles:  []*otlpProfile{p},
+		}},
+	}}}
+}
+
+func otlpStrings(vals []string) otlpValue {
+	if len(vals) == 1 {
+		return otlpValue{Str: &vals[0]}
+	}
+	arr := make([]otlpValue, len(vals))
+	for i := range vals {
+		arr[i] = otlpValue{Str: &vals[i]}
+	}
+	return otlpValue{Array: arr}
+}
+
+func otlpInts(vals []int64) otlpValue {
+	if len(vals) == 1 {
+		return otlpValue{Int: &vals[0]}
+	}
+	arr := make([]otlpValue, len(vals))
+	for i := range vals {
+		arr[i] = otlpValue{Int: &vals[i]}
+	}
+	return otlpValue{Array: arr}
+}
+
+func firstNonEmpty(vals []string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// fromOTLP converts all profiles contained in data to pprof and merges them.
+// Resource attributes are added as labels to the samples of each profile.
+func fromOTLP(data *otlpProfilesData) (*profile.Profile, error) {
+	var profs []*profile.Profile
+	for _, rp := range data.ResourceProfiles {
+		for _, sp := range rp.ScopeProfiles {
+			for _, p := range sp.Profiles {
+				prof, err := fromOTLPProfile(p)
+				if err != nil {
+					return nil, err
+				}
+				for _, s := range prof.Sample {
+					for _, kv := range rp.Resource {
+						addOTLPLabel(s, kv, "")
+					}
+				}
+				profs = append(profs, prof)
+			}
+		}
+	}
+	switch len(profs) {
+	case 0:
+		return nil, errors.New("no profiles found in OTLP data")
+	case 1:
+		return profs[0], nil
+	default:
+		return profile.Merge(profs)
+	}
+}
+
+func fromOTLPProfile(p *otlpProfile) (*profile.Profile, error) {
+	var err error
+	str := func(idx int64) string {
+		if idx < 0 || idx >= int64(len(p.Strings)) {
+			if err == nil {
+				err = fmt.Errorf("bad string index: %d", idx)
+			}
+			return ""
+		}
+		return p.Strings[idx]
+	}
+	valueType := func(vt otlpValueType) *profile.ValueType {
+		return &profile.ValueType{Type: str(vt.Type), Unit: str(vt.Unit)}
+	}
+	attrs := func(indices []int64) []otlpKeyValue {
+		var kvs []otlpKeyValue
+		for _, idx := range indices {
+			if idx < 0 || idx >= int64(len(p.Attributes)) {
+				if err == nil {
+					err = fmt.Errorf("bad attribute index: %d", idx)
+				}
+				continue
+			}
+			kvs = append(kvs, p.Attributes[idx])
+		}
+		return kvs
+	}
+
+	prof := &profile.Profile{
+		TimeNanos:     p.TimeNanos,
+		DurationNanos: p.DurationNanos,
+		Period:        p.Period,
+	}
+	for _, st := range p.SampleTypes {
+		prof.SampleType = append(prof.SampleType, valueType(st))
+	}
+	if p.PeriodType != nil {
+		prof.PeriodType = valueType(*p.PeriodType)
+	}
+	for _, c := range p.Comments {
+		prof.Comments = append(prof.Comments, str(c))
+	}
+	prof.DefaultSampleType = str(p.DefaultSampleType)
+	units := map[string]string{}
+	for _, u := range p.AttributeUnits {
+		units[str(u.Key)] = str(u.Unit)
+	}
+
+	for i, om := range p.Mappings {
+		m := &profile.Mapping{
+			ID:              uint64(i + 1),
+			Start:           om.MemoryStart,
+			Limit:           om.MemoryLimit,
+			Offset:          om.FileOffset,
+			File:            str(om.Filename),
+			HasFunctions:    om.HasFunctions,
+			HasFilenames:    om.HasFilenames,
+			HasLineNumbers:  om.HasLineNumbers,
+			HasInlineFrames: om.HasInlineFrames,
+		}
+		for _, kv := range attrs(om.Attributes) {
+			if kv.Key == otlpBuildIDKey && kv.Value.Str != nil {
+				m.BuildID = *kv.Value.Str
+			}
+		}
+		prof.Mapping = append(prof.Mapping, m)
+	}
+	for i, of := range p.Functions {
+		prof.Function = append(prof.Function, &profile.Function{
+			ID:         uint64(i + 1),
+			Name:       str(of.Name),
+			SystemName: str(of.SystemName),
+			Filename:   str(of.Filename),
+			StartLine:  of.StartLine,
+		})
+	}
+	for i, ol := range p.Locations {
+		loc := &profile.Location{ID: uint64(i + 1), Address: ol.Address, IsFolded: ol.IsFolded}
+		if ol.Mapping >= 0 {
+			if ol.Mapping >= int64(len(prof.Mapping)) {
+				return nil, fmt.Errorf("bad mapping index: %d", ol.Mapping)
+			}
+			loc.Mapping = prof.Mapping[ol.Mapping]
+		}
+		for _, line := range ol.Lines {
+			if line.Function < 0 || line.Function >= int64(len(prof.Function)) {
+				return nil, fmt.Errorf("bad function index: %d", line.Function)
+			}
+			loc.Line = append(loc.Line, profile.Line{Function: prof.Function[line.Function], Line: line.Line, Column: line.Column})
+		}
+		prof.Location = append(prof.Location, loc)
+	}
+
+	for _, sample := range p.Samples {
+		if sample.LocationsStart < 0 || sample.LocationsLength < 0 || sample.LocationsStart+sample.LocationsLength > int64(len(p.LocationIndices)) {
+			return nil, fmt.Errorf("bad sample locations: start=%d length=%d", sample.LocationsStart, sample.LocationsLength)
+		} else if len(sample.Values) != len(prof.SampleType) {
+			return nil, fmt.Errorf("sample has %d values but profile has %d sample types", len(sample.Values), len(prof.SampleType))
+		}
+		s := &profile.Sample{Value: sample.Values}
+		for _, idx := range p.LocationIndices[sample.LocationsStart : sample.LocationsStart+sample.LocationsLength] {
+			if idx < 0 || idx >= int64(len(prof.Location)) {
+				return nil, fmt.Errorf("bad location index: %d", idx)
+			}
+			s.Location = append(s.Location, prof.Location[idx])
+		}
+		for _, kv := range attrs(sample.Attributes) {
+			addOTLPLabel(s, kv, units[kv.Key])
+		}
+		prof.Sample = append(prof.Sample, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prof, prof.CheckValid()
+}
+
+// addOTLPLabel adds the given attribute as a label to s. Int values become
+// numeric labels, all other values become string labels.
+func addOTLPLabel(s *profile.Sample, kv otlpKeyValue, unit string) {
+	vals := kv.Value.Array
+	if vals == nil {
+		vals = []otlpValue{kv.Value}
+	}
+	for _, v := range vals {
+		if v.Int != nil {
+			if s.NumLabel == nil {
+				s.NumLabel = map[string][]int64{}
+			}
+			s.NumLabel[kv.Key] = append(s.NumLabel[kv.Key], *v.Int)
+			if unit != "" {
+				if s.NumUnit == nil {
+					s.NumUnit = map[string][]string{}
+				}
+				s.NumUnit[kv.Key] = append(s.NumUnit[kv.Key], unit)
+			}
+			continue
+		}
+		if s.Label == nil {
+			s.Label = map[string][]string{}
+		}
+		s.Label[kv.Key] = append(s.Label[kv.Key], v.String())
+	}
+}
+
+// The types below mirror the messages of the OpenTelemetry profiles signal as
+// defined in opentelemetry/proto/profiles/v1development/profiles.proto of
+// opentelemetry-proto v1.5.0. Only the fields that have a pprof equivalent are
+// supported. Index fields use -1 for absent optional values.
+
+type otlpProfilesData struct {
+	ResourceProfiles []otlpResourceProfiles
+}
+
+type otlpResourceProfiles struct {
+	Resource      []otlpKeyValue
+	ScopeProfiles []otlpScopeProfiles
+}
+
+type otlpScopeProfiles struct {
+	ScopeName string
+	Profiles  []*otlpProfile
+}
+
+type otlpProfile struct {
+	SampleTypes       []otlpValueType
+	Samples           []otlpSample
+	Mappings          []otlpMapping
+	Locations         []otlpLocation
+	LocationIndices   []int64
+	Functions         []otlpFunction
+	Attributes        []otlpKeyValue
+	AttributeUnits    []otlpAttributeUnit
+	Strings           []string
+	TimeNanos         int64
+	DurationNanos     int64
+	PeriodType        *otlpValueType
+	Period            int64
+	Comments          []int64
+	DefaultSampleType int64
+	ProfileID         []byte
+}
+
+type otlpValueType struct {
+	Type int64
+	Unit int64
+}
+
+type otlpSample struct {
+	LocationsStart  int64
+	LocationsLength int64
+	Values          []int64
+	Attributes      []int64
+}
+
+type otlpMapping struct {
+	MemoryStart     uint64
+	MemoryLimit     uint64
+	FileOffset      uint64
+	Filename        int64
+	Attributes      []int64
+	HasFunctions    bool
+	HasFilenames    bool
+	HasLineNumbers  bool
+	HasInlineFrames bool
+}
+
+type otlpLocation struct {
+	Mapping  int64
+	Address  uint64
+	Lines    []otlpLine
+	IsFolded bool
+}
+
+type otlpLine struct {
+	Function int64
+	Line     int64
+	Column   int64
+}
+
+type otlpFunction struct {
+	Name       int64
+	SystemName int64
+	Filename   int64
+	StartLine  int64
+}
+
+type otlpAttributeUnit struct {
+	Key  int64
+	Unit int64
+}
+
+type otlpKeyValue struct {
+	Key   string
+	Value otlpValue
+}
+
+// otlpValue is an AnyValue. At most one of its fields is set.
+type otlpValue struct {
+	Str    *string
+	Bool   *bool
+	Int    *int64
+	Double *float64
+	Array  []otlpValue
+}
+
+func (v otlpValue) String() string {
+	switch {
+	case v.Str != nil:
+		return *v.Str
+	case v.Bool != nil:
+		return strconv.FormatBool(*v.Bool)
+	case v.Int != nil:
+		return strconv.FormatInt(*v.Int, 10)
+	case v.Double != nil:
+		return strconv.FormatFloat(*v.Double, 'g', -1, 64)
+	}
+	var vals []string
+	for _, v := range v.Array {
+		vals = append(vals, v.String())
+	}
+	return strings.Join(vals, ",")
+}
+
+// protoBuffer is a minimal protobuf encoder. Zero values are omitted like
+// proto3 does for fields without explicit presence.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(num protowire.Number, v uint64) {
+	if v != 0 {
+		b.presentVarint(num, v)
+	}
+}
+
+func (b *protoBuffer) presentVarint(num protowire.Number, v uint64) {
+	*b = protowire.AppendTag(*b, num, protowire.VarintType)
+	*b = protowire.AppendVarint(*b, v)
+}
+
+func (b *protoBuffer) bool(num protowire.Number, v bool) {
+	if v {
+		b.varint(num, 1)
+	}
+}
+
+func (b *protoBuffer) bytes(num protowire.Number, v []byte) {
+	*b = protowire.AppendTag(*b, num, protowire.BytesType)
+	*b = protowire.AppendBytes(*b, v)
+}
+
+func (b *protoBuffer) string(num protowire.Number, v string) {
+	if v != "" {
+		b.bytes(num, []byte(v))
+	}
+}
+
+func (b *protoBuffer) packed(num protowire.Number, vals []int64) {
+	if len(vals) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range vals {
+		packed = protowire.AppendVarint(packed, uint64(v))
+	}
+	b.bytes(num, packed)
+}
+
+func (b *protoBuffer) message(num protowire.Number, fn func(b *protoBuffer)) {
+	var msg protoBuffer
+	fn(&msg)
+	b.bytes(num, msg)
+}
+
+func (d *otlpProfilesData) encode() []byte {
+	var b protoBuffer
+	for _, rp := range d.ResourceProfiles {
+		b.message(1, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) {
+				for _, kv := range rp.Resource {
+					b.bytes(1, kv.encode())
+				}
+			})
+			for _, sp := range rp.ScopeProfiles {
+				b.message(2, func(b *protoBuffer) {
+					b.message(1, func(b *protoBuffer) { b.string(1, sp.ScopeName) })
+					for _, p := range sp.Profiles {
+						b.bytes(2, p.encode())
+					}
+				})
+			}
+		})
+	}
+	return b
+}
+
+func (p *otlpProfile) encode() []byte {
+	var b protoBuffer
+	valueType := func(num protowire.Number, vt otlpValueType) {
+		b.message(num, func(b *protoBuffer) {
+			b.varint(1, uint64(vt.Type))
+			b.varint(2, uint64(vt.Unit))
+		})
+	}
+	for _, st := range p.SampleTypes {
+		valueType(1, st)
+	}
+	for _, s := range p.Samples {
+		b.message(2, func(b *protoBuffer) {
+			b.varint(1, uint64(s.LocationsStart))
+			b.varint(2, uint64(s.LocationsLength))
+			b.packed(3, s.Values)
+			b.packed(4, s.Attributes)
+		})
+	}
+	for _, m := range p.Mappings {
+		b.message(3, func(b *protoBuffer) {
+			b.varint(1, m.MemoryStart)
+			b.varint(2, m.MemoryLimit)
+			b.varint(3, m.FileOffset)
+			b.varint(4, uint64(m.Filename))
+			b.packed(5, m.Attributes)
+			b.bool(6, m.HasFunctions)
+			b.bool(7, m.HasFilenames)
+			b.bool(8, m.HasLineNumbers)
+			b.bool(9, m.HasInlineFrames)
+		})
+	}
+	for _, loc := range p.Locations {
+		b.message(4, func(b *protoBuffer) {
+			if loc.Mapping >= 0 {
+				b.presentVarint(1, uint64(loc.Mapping))
+			}
+			b.varint(2, loc.Address)
+			for _, line := range loc.Lines {
+				b.message(3, func(b *protoBuffer) {
+					b.varint(1, uint64(line.Function))
+					b.varint(2, uint64(line.Line))
+					b.varint(3, uint64(line.Column))
+				})
+			}
+			b.bool(4, loc.IsFolded)
+		})
+	}
+	b.packed(5, p.LocationIndices)
+	for _, fn := range p.Functions {
+		b.message(6, func(b *protoBuffer) {
+			b.varint(1, uint64(fn.Name))
+			b.varint(2, uint64(fn.SystemName))
+			b.varint(3, uint64(fn.Filename))
+			b.varint(4, uint64(fn.StartLine))
+		})
+	}
+	for _, kv := range p.Attributes {
+		b.bytes(7, kv.encode())
+	}
+	for _, u := range p.AttributeUnits {
+		b.message(8, func(b *protoBuffer) {
+			b.varint(1, uint64(u.Key))
+			b.varint(2, uint64(u.Unit))
+		})
+	}
+	for _, s := range p.Strings {
+		b.bytes(10, []byte(s))
+	}
+	b.varint(11, uint64(p.TimeNanos))
+	b.varint(12, uint64(p.DurationNanos))
+	if p.PeriodType != nil {
+		valueType(13, *p.PeriodType)
+	}
+	b.varint(14, uint64(p.Period))
+	b.packed(15, p.Comments)
+	b.varint(16, uint64(p.DefaultSampleType))
+	if len(p.ProfileID) > 0 {
+		b.bytes(17, p.ProfileID)
+	}
+	return b
+}
+
+func (kv otlpKeyValue) encode() []byte {
+	var b protoBuffer
+	b.string(1, kv.Key)
+	b.bytes(2, kv.Value.encode())
+	return b
+}
+
+func (v otlpValue) encode() []byte {
+	var b protoBuffer
+	switch {
+	case v.Str != nil:
+		b.bytes(1, []byte(*v.Str))
+	case v.Bool != nil:
+		b.presentVarint(2, protowire.EncodeBool(*v.Bool))
+	case v.Int != nil:
+		b.presentVarint(3, uint64(*v.Int))
+	case v.Double != nil:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*v.Double))
+	case v.Array != nil:
+		b.message(5, func(b *protoBuffer) {
+			for _, v := range v.Array {
+				b.bytes(1, v.encode())
+			}
+		})
+	}
+	return b
+}
+
+// protoField is a decoded protobuf field. For varint and fixed fields the value
+// is in v, for length delimited fields it's in buf.
+type protoField struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64
+	buf []byte
+}
+
+// int64s returns the values of a repeated integer field that may or may not
+// be packed.
+func (f protoField) int64s() ([]int64, error) {
+	if f.typ != protowire.BytesType {
+		return []int64{int64(f.v)}, nil
+	}
+	var vals []int64
+	for buf := f.buf; len(buf) > 0; {
+		v, n := protowire.ConsumeVarint(buf)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		vals = append(vals, int64(v))
+		buf = buf[n:]
+	}
+	return vals, nil
+}
+
+// decodeProto calls fn for each field of the given protobuf message.
+func decodeProto(buf []byte, fn func(f protoField) error) error {
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		buf = buf[n:]
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(buf)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(buf)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(buf)
+			f.v = uint64(v)
+		case protowire.BytesType:
+			f.buf, n = protowire.ConsumeBytes(buf)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, buf)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		buf = buf[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendInt64s decodes a repeated integer field and appends it to dst.
+func appendInt64s(dst *[]int64, f protoField) error {
+	vals, err := f.int64s()
+	*dst = append(*dst, vals...)
+	return err
+}
+
+// errOTLPDictionary is returned when decoding ProfilesData that uses the
+// ProfilesDictionary introduced after opentelemetry-proto v1.5.0.
+var errOTLPDictionary = errors.New("OTLP profiles with a ProfilesDictionary are not supported, only the opentelemetry-proto v1.5.0 layout is")
+
+func (d *otlpProfilesData) decode(buf []byte) error {
+	return decodeProto(buf, func(f protoField) error {
+		if f.num == 2 && f.typ == protowire.BytesType {
+			return errOTLPDictionary
+		} else if f.num != 1 || f.typ != protowire.BytesType {
+			return fmt.Errorf("unexpected field %d in ProfilesData", f.num)
+		}
+		var rp otlpResourceProfiles
+		err := decodeProto(f.buf, func(f protoField) error {
+			switch {
+			case f.num == 1 && f.typ == protowire.BytesType:
+				return decodeProto(f.buf, func(f protoField) error {
+					if f.num != 1 || f.typ != protowire.BytesType {
+						return nil
+					}
+					var kv otlpKeyValue
+					err := kv.decode(f.buf)
+					rp.Resource = append(rp.Resource, kv)
+					return err
+				})
+			case f.num == 2 && f.typ == protowire.BytesType:
+				var sp otlpScopeProfiles
+				err := decodeProto(f.buf, func(f protoField) error {
+					switch {
+					case f.num == 1 && f.typ == protowire.BytesType:
+						return decodeProto(f.buf, func(f protoField) error {
+							if f.num == 1 {
+								sp.ScopeName = string(f.buf)
+							}
+							return nil
+						})
+					case f.num == 2 && f.typ == protowire.BytesType:
+						p := &otlpProfile{}
+						sp.Profiles = append(sp.Profiles, p)
+						return p.decode(f.buf)
+					}
+					return nil
+				})
+				rp.ScopeProfiles = append(rp.ScopeProfiles, sp)
+				return err
+			}
+			return nil
+		})
+		d.ResourceProfiles = append(d.ResourceProfiles, rp)
+		return err
+	})
+}
+
+func (p *otlpProfile) decode(buf []byte) error {
+	decodeValueType := func(buf []byte) (otlpValueType, error) {
+		var vt otlpValueType
+		err := decodeProto(buf, func(f protoField) error {
+			switch f.num {
+			case 1:
+				vt.Type = int64(f.v)
+			case 2:
+				vt.Unit = int64(f.v)
+			}
+			return nil
+		})
+		return vt, err
+	}
+
+	return decodeProto(buf, func(f protoField) error {
+		switch f.num {
+		case 1:
+			vt, err := decodeValueType(f.buf)
+			p.SampleTypes = append(p.SampleTypes, vt)
+			return err
+		case 2:
+			var s otlpSample
+			err := decodeProto(f.buf, func(f protoField) error {
+				switch f.num {
+				case 1:
+					s.LocationsStart = int64(f.v)
+				case 2:
+					s.LocationsLength = int64(f.v)
+				case 3:
+					return appendInt64s(&s.Values, f)
+				case 4:
+					return appendInt64s(&s.Attributes, f)
+				}
+				return nil
+			})
+			p.Samples = append(p.Samples, s)
+			return err
+		case 3:
+			var m otlpMapping
+			err := decodeProto(f.buf, func(f protoField) error {
+				switch f.num {
+				case 1:
+					m.MemoryStart = f.v
+				case 2:
+					m.MemoryLimit = f.v
+				case 3:
+					m.FileOffset = f.v
+				case 4:
+					m.Filename = int64(f.v)
+				case 5:
+					return appendInt64s(&m.Attributes, f)
+				case 6:
+					m.HasFunctions = f.v != 0
+				case 7:
+					m.HasFilenames = f.v != 0
+				case 8:
+					m.HasLineNumbers = f.v != 0
+				case 9:
+					m.HasInlineFrames = f.v != 0
+				}
+				return nil
+			})
+			p.Mappings = append(p.Mappings, m)
+			return err
+		case 4:
+			loc := otlpLocation{Mapping: -1}
+			err := decodeProto(f.buf, func(f protoField) error {
+				switch f.num {
+				case 1:
+					loc.Mapping = int64(f.v)
+				case 2:
+					loc.Address = f.v
+				case 3:
+					var line otlpLine
+					err := decodeProto(f.buf, func(f protoField) error {
+						switch f.num {
+						case 1:
+							line.Function = int64(f.v)
+						case 2:
+							line.Line = int64(f.v)
+						case 3:
+							line.Column = int64(f.v)
+						}
+						return nil
+					})
+					loc.Lines = append(loc.Lines, line)
+					return err
+				case 4:
+					loc.IsFolded = f.v != 0
+				}
+				return nil
+			})
+			p.Locations = append(p.Locations, loc)
+			return err
+		case 5:
+			return appendInt64s(&p.LocationIndices, f)
+		case 6:
+			var fn otlpFunction
+			err := decodeProto(f.buf, func(f protoField) error {
+				switch f.num {
+				case 1:
+					fn.Name = int64(f.v)
+				case 2:
+					fn.SystemName = int64(f.v)
+				case 3:
+					fn.Filename = int64(f.v)
+				case 4:
+					fn.StartLine = int64(f.v)
+				}
+				return nil
+			})
+			p.Functions = append(p.Functions, fn)
+			return err
+		case 7:
+			var kv otlpKeyValue
+			err := kv.decode(f.buf)
+			p.Attributes = append(p.Attributes, kv)
+			return err
+		case 8:
+			var u otlpAttributeUnit
+			err := decodeProto(f.buf, func(f protoField) error {
+				switch f.num {
+				case 1:
+					u.Key = int64(f.v)
+				case 2:
+					u.Unit = int64(f.v)
+				}
+				return nil
+			})
+			p.AttributeUnits = append(p.AttributeUnits, u)
+			return err
+		case 10:
+			p.Strings = append(p.Strings, string(f.buf))
+		case 11:
+			p.TimeNanos = int64(f.v)
+		case 12:
+			p.DurationNanos = int64(f.v)
+		case 13:
+			vt, err := decodeValueType(f.buf)
+			p.PeriodType = &vt
+			return err
+		case 14:
+			p.Period = int64(f.v)
+		case 15:
+			return appendInt64s(&p.Comments, f)
+		case 16:
+			p.DefaultSampleType = int64(f.v)
+		case 17:
+			p.ProfileID = f.buf
+		}
+		return nil
+	})
+}
+
+func (kv *otlpKeyValue) decode(buf []byte) error {
+	return decodeProto(buf, func(f protoField) error {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.buf)
+		case 2:
+			return kv.Value.decode(f.buf)
+		}
+		return nil
+	})
+}
+
+func (v *otlpValue) decode(buf []byte) error {
+	return decodeProto(buf, func(f protoField) error {
+		switch f.num {
+		case 1:
+			s := string(f.buf)
+			v.Str = &s
+		case 2:
+			b := f.v != 0
+			v.Bool = &b
+		case 3:
+			i := int64(f.v)
+			v.Int = &i
+		case 4:
+			d := math.Float64frombits(f.v)
+			v.Double = &d
+		case 5:
+			v.Array = []otlpValue{}
+			return decodeProto(f.buf, func(f protoField) error {
+				if f.num != 1 {
+					return nil
+				}
+				var elem otlpValue
+				err := elem.decode(f.buf)
+				v.Array = append(v.Array, elem)
+				return err
+			})
+		}
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestOTLP(t *testing.T) {
+	t.Run("roundtrip", func(t *testing.T) {
+		in, err := profile.ParseData(foldedProfile(t, `samples/count alloc/bytes @extended
+{endpoint=/foo,size=#1024:bytes} main@main.go:1;foo@foo.go:2 5 50
+main@main.go:1;bar@bar.go:3 3 30
+`))
+		require.NoError(t, err)
+		in.Comments = []string{"hello"}
+		in.Mapping = []*profile.Mapping{{ID: 1, File: "/bin/app", BuildID: "abc", HasFunctions: true}}
+		for _, loc := range in.Location {
+			loc.Mapping = in.Mapping[0]
+		}
+		inData := &bytes.Buffer{}
+		require.NoError(t, in.Write(inData))
+
+		otlp := &bytes.Buffer{}
+		require.NoError(t, (&OTLP{Input: inData.Bytes(), Output: otlp, ResourceAttributes: "service.name=api"}).Execute(context.Background()))
+		_, err = profile.ParseData(otlp.Bytes())
+		require.Error(t, err)
+
+		out := &bytes.Buffer{}
+		require.NoError(t, (&OTLP{Input: otlp.Bytes(), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+
+		require.Equal(t, "samples/count alloc/bytes", formatSampleTypes(prof))
+		require.Equal(t, []string{"hello"}, prof.Comments)
+		require.Equal(t, map[string]int64{
+			"api main;foo": 5,
+			"api main;bar": 3,
+		}, sampleValues(prof, "service.name", 0))
+		require.Equal(t, []string{"/foo"}, prof.Sample[0].Label["endpoint"])
+		require.Equal(t, []int64{1024}, prof.Sample[0].NumLabel["size"])
+		require.Equal(t, []string{"bytes"}, prof.Sample[0].NumUnit["size"])
+		require.Equal(t, "foo.go", prof.Sample[0].Location[0].Line[0].Function.Filename)
+		require.Equal(t, int64(2), prof.Sample[0].Location[0].Line[0].Line)
+		require.Equal(t, "abc", prof.Sample[0].Location[0].Mapping.BuildID)
+	})
+
+	// otlp.pb is generated by testdata/otlpgen using the official generated
+	// types of opentelemetry-proto v1.5.0.
+	golden, err := os.ReadFile(filepath.Join("testdata", "otlp.pb"))
+	require.NoError(t, err)
+
+	t.Run("golden encode", func(t *testing.T) {
+		attrs, err := parseOTLPAttributes("service.name=api")
+		require.NoError(t, err)
+		var id [32]byte
+		for i := range id {
+			id[i] = byte(i)
+		}
+		require.Equal(t, golden, toOTLP(otlpGoldenProfile(), attrs, id).encode())
+	})
+
+	t.Run("golden decode", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&OTLP{Input: golden, Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+
+		want := otlpGoldenProfile()
+		for _, s := range want.Sample {
+			if s.Label == nil {
+				s.Label = map[string][]string{}
+			}
+			s.Label["service.name"] = []string{"api"}
+		}
+		require.Equal(t, want.String(), prof.String())
+	})
+
+	t.Run("dictionary layout", func(t *testing.T) {
+		var data []byte
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, nil)
+		data = protowire.AppendTag(data, 2, protowire.BytesType)
+		data = protowire.AppendBytes(data, nil)
+		err := (&OTLP{Input: data, Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.ErrorIs(t, err, errOTLPDictionary)
+	})
+
+	t.Run("bad resource attributes", func(t *testing.T) {
+		in := foldedProfile(t, "main 1")
+		err := (&OTLP{Input: in, Output: &bytes.Buffer{}, ResourceAttributes: "service.name"}).Execute(context.Background())
+		require.EqualError(t, err, `bad resource attribute: "service.name": must be key=value`)
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		err := (&OTLP{Input: []byte("hello world"), Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor OTLP profiles protobuf")
+	})
+}
+
+// otlpGoldenProfile returns the pprof equivalent of testdata/otlp.pb.
+func otlpGoldenProfile() *profile.Profile {
+	var (
+		m    = &profile.Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/app", BuildID: "abc", HasFunctions: true}
+		main = &profile.Function{ID: 1, Name: "main", Filename: "main.go", StartLine: 1}
+		foo  = &profile.Function{ID: 2, Name: "foo", Filename: "foo.go"}
+		loc1 = &profile.Location{ID: 1, Mapping: m, Address: 0x1100, Line: []profile.Line{{Function: main, Line: 3}}}
+		loc2 = &profile.Location{ID: 2, Mapping: m, Address: 0x1200, Line: []profile.Line{{Function: foo, Line: 7}}}
+	)
+	return &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		DefaultSampleType: "cpu",
+		Sample: []*profile.Sample{
+			{
+				Location: []*profile.Location{loc2, loc1},
+				Value:    []int64{1, 10},
+				Label:    map[string][]string{"endpoint": {"/foo"}},
+				NumLabel: map[string][]int64{"size": {1024}},
+				NumUnit:  map[string][]string{"size": {"bytes"}},
+			},
+			{Location: []*profile.Location{loc1}, Value: []int64{2, 20}},
+		},
+		Mapping:       []*profile.Mapping{m},
+		Location:      []*profile.Location{loc1, loc2},
+		Function:      []*profile.Function{main, foo},
+		Comments:      []string{"hello"},
+		TimeNanos:     1700000000000000000,
+		DurationNanos: 1000000000,
+		PeriodType:    &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:        10000000,
+	}
+}
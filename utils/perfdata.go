@@ -0,0 +1,858 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// PerfData converts the binary perf.data files recorded by Linux perf to
+// pprof and vice versa. The direction is determined by the input format. The
+// perf.data file does not contain symbols, so the resulting profile contains
+// addresses and mappings that can be symbolized by pprof.
+type PerfData struct {
+	Input  []byte
+	Output io.Writer
+}
+
+func (p *PerfData) Execute(ctx context.Context) error {
+	if !hasPerfMagic(p.Input) {
+		if prof, err := profile.ParseData(p.Input); err == nil {
+			return writePerfData(prof, p.Output)
+		}
+	}
+
+	prof, err := parsePerfData(p.Input)
+	if err != nil {
+		return err
+	}
+	return prof.Write(p.Output)
+}
+
+// hasPerfMagic returns true if data starts with the magic of a perf.data file
+// in either byte order.
+func hasPerfMagic(data []byte) bool {
+	return len(data) >= 8 &&
+		(binary.LittleEndian.Uint64(data) == perfMagic || binary.BigEndian.Uint64(data) == perfMagic)
+}
+
+const (
+	perfMagic          = 0x32454c4946524550 // "PERFILE2"
+	perfFileHeaderSize = 104
+
+	perfRecordMmap   = 1
+	perfRecordComm   = 3
+	perfRecordFork   = 7
+	perfRecordSample = 9
+	perfRecordMmap2  = 10
+
+	perfRecordMiscKernel      = 1
+	perfRecordMiscUser        = 2
+	perfRecordMiscMmapBuildID = 1 << 14
+
+	perfSampleIP         = 1 << 0
+	perfSampleTID        = 1 << 1
+	perfSampleTime       = 1 << 2
+	perfSampleAddr       = 1 << 3
+	perfSampleRead       = 1 << 4
+	perfSampleCallchain  = 1 << 5
+	perfSampleID         = 1 << 6
+	perfSampleCPU        = 1 << 7
+	perfSamplePeriod     = 1 << 8
+	perfSampleStreamID   = 1 << 9
+	perfSampleIdentifier = 1 << 16
+
+	perfFormatTotalTimeEnabled = 1 << 0
+	perfFormatTotalTimeRunning = 1 << 1
+	perfFormatID               = 1 << 2
+	perfFormatGroup            = 1 << 3
+	perfFormatLost             = 1 << 4
+
+	perfContextMax = 0xfffffffffffff001 // (u64)-4095
+
+	perfHeaderEventDesc = 12
+
+	perfTypeHardware = 0
+	perfTypeSoftware = 1
+	perfTypeRaw      = 4
+)
+
+var (
+	perfHardwareEvents = []string{
+		"cycles", "instructions", "cache-references", "cache-misses",
+		"branch-instructions", "branch-misses", "bus-cycles",
+		"stalled-cycles-frontend", "stalled-cycles-backend", "ref-cycles",
+	}
+	perfSoftwareEvents = []string{
+		"cpu-clock", "task-clock", "page-faults", "context-switches",
+		"cpu-migrations", "minor-faults", "major-faults", "alignment-faults",
+		"emulation-faults", "dummy", "bpf-output",
+	}
+)
+
+// perfEventAttr holds the fields of a perf_event_attr that are needed for
+// decoding samples.
+type perfEventAttr struct {
+	typ        uint32
+	config     uint64
+	sampleType uint64
+	readFormat uint64
+	name       string
+}
+
+// perfMapping is a memory mapping of a process.
+type perfMapping struct {
+	start, limit uint64
+	mapping      *profile.Mapping
+}
+
+// perfDataParser holds the state for parsing a perf.data file.
+type perfDataParser struct {
+	data  []byte
+	order binary.ByteOrder
+	attrs []*perfEventAttr
+	byID  map[uint64]*perfEventAttr
+
+	prof      *profile.Profile
+	comms     map[uint32]string
+	mappings  map[uint32][]perfMapping
+	kernel    []perfMapping
+	mappingBy map[[4]string]*profile.Mapping
+	locations map[[2]uint64]*profile.Location
+}
+
+func parsePerfData(data []byte) (*profile.Profile, error) {
+	p := &perfDataParser{
+		data:      data,
+		byID:      map[uint64]*perfEventAttr{},
+		comms:     map[uint32]string{},
+		mappings:  map[uint32][]perfMapping{},
+		mappingBy: map[[4]string]*profile.Mapping{},
+		locations: map[[2]uint64]*profile.Location{},
+		prof:      &profile.Profile{PeriodType: &profile.ValueType{}},
+	}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("bad perf.data: %w", err)
+	}
+	return p.prof, p.prof.CheckValid()
+}
+
+func (p *perfDataParser) parse() error {
+	if len(p.data) < 16 {
+		return errors.New("file too short")
+	}
+	switch {
+	case binary.LittleEndian.Uint64(p.data) == perfMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint64(p.data) == perfMagic:
+		p.order = binary.BigEndian
+	default:
+		return errors.New("bad magic, only perf.data files in the PERFILE2 format are supported")
+	}
+	if p.u64(8) != perfFileHeaderSize || len(p.data) < perfFileHeaderSize {
+		return errors.New("unsupported header, perf.data files in pipe mode are not supported")
+	}
+
+	var (
+		attrSize  = p.u64(16)
+		attrsOff  = p.u64(24)
+		attrsSize = p.u64(32)
+		dataOff   = p.u64(40)
+		dataSize  = p.u64(48)
+		features  = p.data[72:104]
+	)
+	if _, err := p.section(attrsOff, attrsSize); err != nil {
+		return err
+	} else if attrSize < 16 || attrsSize%attrSize != 0 {
+		return fmt.Errorf("bad attr size: %d", attrSize)
+	}
+	for off := uint64(0); off < attrsSize; off += attrSize {
+		if err := p.parseAttr(attrsOff+off, attrSize); err != nil {
+			return err
+		}
+	}
+	if len(p.attrs) == 0 {
+		return errors.New("no event attributes")
+	}
+
+	if err := p.parseEventDesc(features, dataOff+dataSize); err != nil {
+		return err
+	}
+	for _, attr := range p.attrs {
+		p.prof.SampleType = append(p.prof.SampleType, &profile.ValueType{
+			Type: attr.name,
+			Unit: perfEventUnit(attr),
+		})
+	}
+
+	records, err := p.section(dataOff, dataSize)
+	if err != nil {
+		return err
+	}
+	for off := 0; off < len(records); {
+		if len(records)-off < 8 {
+			return errors.New("truncated record header")
+		}
+		var (
+			typ  = p.order.Uint32(records[off:])
+			misc = p.order.Uint16(records[off+4:])
+			size = int(p.order.Uint16(records[off+6:]))
+		)
+		if size < 8 || off+size > len(records) {
+			return fmt.Errorf("bad record size: %d", size)
+		}
+		rec := records[off+8 : off+size]
+		off += size
+
+		switch typ {
+		case perfRecordMmap, perfRecordMmap2:
+			err = p.parseMmap(rec, typ, misc)
+		case perfRecordComm:
+			err = p.parseComm(rec)
+		case perfRecordFork:
+			err = p.parseFork(rec)
+		case perfRecordSample:
+			err = p.parseSample(rec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *perfDataParser) u64(off uint64) uint64 {
+	return p.order.Uint64(p.data[off:])
+}
+
+func (p *perfDataParser) section(off, size uint64) ([]byte, error) {
+	if off > uint64(len(p.data)) || size > uint64(len(p.data))-off {
+		return nil, fmt.Errorf("section out of bounds: offset=%d size=%d", off, size)
+	}
+	return p.data[off : off+size], nil
+}
+
+// parseAttr parses a perf_file_attr, i.e. a perf_event_attr followed by the
+// file section that holds the ids of the event.
+func (p *perfDataParser) parseAttr(off, size uint64) error {
+	buf, err := p.section(off, size)
+	if err != nil {
+		return err
+	} else if len(buf) < 16+48 {
+		return fmt.Errorf("attr too short: %d", len(buf))
+	}
+	attr := &perfEventAttr{
+		typ:        p.order.Uint32(buf[0:]),
+		config:     p.order.Uint64(buf[8:]),
+		sampleType: p.order.Uint64(buf[24:]),
+		readFormat: p.order.Uint64(buf[32:]),
+	}
+	attr.name = perfEventName(attr)
+
+	idsOff, idsSize := p.order.Uint64(buf[len(buf)-16:]), p.order.Uint64(buf[len(buf)-8:])
+	ids, err := p.section(idsOff, idsSize)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+8 <= len(ids); i += 8 {
+		p.byID[p.order.Uint64(ids[i:])] = attr
+	}
+	p.attrs = append(p.attrs, attr)
+	return nil
+}
+
+// parseEventDesc uses the HEADER_EVENT_DESC feature section, if present, to
+// name the events the way perf does, e.g. "cycles:u".
+func (p *perfDataParser) parseEventDesc(features []byte, off uint64) error {
+	// The feature sections are stored in the order of the feature bits that
+	// are set in the header.
+	for bit := 0; bit < perfHeaderEventDesc; bit++ {
+		if p.featureBit(features, bit) {
+			off += 16
+		}
+	}
+	if !p.featureBit(features, perfHeaderEventDesc) {
+		return nil
+	}
+	sec, err := p.section(off, 16)
+	if err != nil {
+		return err
+	}
+	buf, err := p.section(p.order.Uint64(sec), p.order.Uint64(sec[8:]))
+	if err != nil {
+		return err
+	} else if len(buf) < 8 {
+		return errors.New("event desc too short")
+	}
+
+	nr, attrSize := p.order.Uint32(buf), p.order.Uint32(buf[4:])
+	pos := uint64(8)
+	for i := uint32(0); i < nr; i++ {
+		pos += uint64(attrSize)
+		if pos+8 > uint64(len(buf)) {
+			return errors.New("event desc truncated")
+		}
+		nrIDs := uint64(p.order.Uint32(buf[pos:]))
+		strLen := uint64(p.order.Uint32(buf[pos+4:]))
+		pos += 8
+		if pos+strLen+nrIDs*8 > uint64(len(buf)) {
+			return errors.New("event desc truncated")
+		}
+		name := string(bytes.TrimRight(buf[pos:pos+strLen], "\x00"))
+		pos += strLen
+		for j := uint64(0); j < nrIDs; j++ {
+			if attr := p.byID[p.order.Uint64(buf[pos+j*8:])]; attr != nil && name != "" {
+				attr.name = name
+			}
+		}
+		pos += nrIDs * 8
+		if nrIDs == 0 && int(i) < len(p.attrs) && name != "" {
+			p.attrs[i].name = name
+		}
+	}
+	return nil
+}
+
+func (p *perfDataParser) featureBit(features []byte, bit int) bool {
+	word := p.order.Uint64(features[bit/64*8:])
+	return word&(1<<(bit%64)) != 0
+}
+
+func (p *perfDataParser) parseComm(rec []byte) error {
+	if len(rec) < 8 {
+		return errors.New("comm record too short")
+	}
+	tid := p.order.Uint32(rec[4:])
+	p.comms[tid] = cString(rec[8:])
+	return nil
+}
+
+// parseFork lets new processes inherit the mappings of their parent.
+func (p *perfDataParser) parseFork(rec []byte) error {
+	if len(rec) < 8 {
+		return errors.New("fork record too short")
+	}
+	pid, ppid := p.order.Uint32(rec), p.order.Uint32(rec[4:])
+	if _, ok := p.mappings[pid]; !ok && pid != ppid {
+		p.mappings[pid] = append([]perfMapping(nil), p.mappings[ppid]...)
+	}
+	return nil
+}
+
+func (p *perfDataParser) parseMmap(rec []byte, typ uint32, misc uint16) error {
+	fixed := 32
+	if typ == perfRecordMmap2 {
+		fixed = 32 + 24 + 8
+	}
+	if len(rec) < fixed {
+		return errors.New("mmap record too short")
+	}
+	var (
+		pid      = p.order.Uint32(rec)
+		start    = p.order.Uint64(rec[8:])
+		length   = p.order.Uint64(rec[16:])
+		pgoff    = p.order.Uint64(rec[24:])
+		filename = cString(rec[fixed:])
+		buildID  string
+	)
+	if typ == perfRecordMmap2 && misc&perfRecordMiscMmapBuildID != 0 {
+		size := int(rec[32])
+		if size > 20 {
+			size = 20
+		}
+		buildID = fmt.Sprintf("%x", rec[36:36+size])
+	}
+
+	key := [4]string{
+		filename,
+		strconv.FormatUint(start, 16),
+		strconv.FormatUint(length, 16),
+		strconv.FormatUint(pgoff, 16),
+	}
+	m := p.mappingBy[key]
+	if m == nil {
+		m = &profile.Mapping{
+			ID:      uint64(len(p.prof.Mapping) + 1),
+			Start:   start,
+			Limit:   start + length,
+			Offset:  pgoff,
+			File:    filename,
+			BuildID: buildID,
+		}
+		p.mappingBy[key] = m
+		p.prof.Mapping = append(p.prof.Mapping, m)
+	}
+
+	pm := perfMapping{start: start, limit: start + length, mapping: m}
+	if pid == ^uint32(0) {
+		p.kernel = append(p.kernel, pm)
+	} else {
+		p.mappings[pid] = append(p.mappings[pid], pm)
+	}
+	return nil
+}
+
+func (p *perfDataParser) parseSample(rec []byte) error {
+	attr := p.attrs[0]
+	if len(p.attrs) > 1 {
+		id, ok := perfSampleEventID(rec, attr.sampleType, p.order)
+		if !ok {
+			return errors.New("cannot determine event of sample")
+		}
+		if attr = p.byID[id]; attr == nil {
+			return fmt.Errorf("unknown sample id: %d", id)
+		}
+	}
+
+	r := &perfReader{buf: rec, order: p.order}
+	var (
+		st          = attr.sampleType
+		pid, tid    uint32
+		period      uint64 = 1
+		callchain   []uint64
+		hasTID      = st&perfSampleTID != 0
+		hasPeriod   = st&perfSamplePeriod != 0
+		hasStackIPs bool
+	)
+	if st&perfSampleIdentifier != 0 {
+		r.u64()
+	}
+	var ip uint64
+	if st&perfSampleIP != 0 {
+		ip = r.u64()
+	}
+	if hasTID {
+		pid, tid = r.u32(), r.u32()
+	}
+	for _, bit := range []uint64{perfSampleTime, perfSampleAddr, perfSampleID, perfSampleStreamID} {
+		if st&bit != 0 {
+			r.u64()
+		}
+	}
+	if st&perfSampleCPU != 0 {
+		r.u64()
+	}
+	if hasPeriod {
+		period = r.u64()
+	}
+	if st&perfSampleRead != 0 {
+		r.skipReadFormat(attr.readFormat)
+	}
+	if st&perfSampleCallchain != 0 {
+		nr := r.u64()
+		if nr > uint64(len(rec))/8 {
+			return fmt.Errorf("bad callchain length: %d", nr)
+		}
+		for i := uint64(0); i < nr; i++ {
+			callchain = append(callchain, r.u64())
+		}
+		hasStackIPs = true
+	}
+	if r.err != nil {
+		return fmt.Errorf("sample record too short")
+	}
+	if !hasStackIPs {
+		callchain = []uint64{ip}
+	}
+
+	sample := &profile.Sample{Value: make([]int64, len(p.attrs))}
+	for i, a := range p.attrs {
+		if a == attr {
+			sample.Value[i] = int64(period)
+		}
+	}
+	if hasTID {
+		sample.Label = map[string][]string{
+			"pid": {strconv.FormatUint(uint64(pid), 10)},
+			"tid": {strconv.FormatUint(uint64(tid), 10)},
+		}
+		if comm, ok := p.comms[tid]; ok {
+			sample.Label["comm"] = []string{comm}
+		} else if comm, ok := p.comms[pid]; ok {
+			sample.Label["comm"] = []string{comm}
+		}
+	}
+
+	for _, addr := range callchain {
+		if addr >= perfContextMax {
+			// Context markers like PERF_CONTEXT_KERNEL separate the kernel and
+			// user parts of the callchain and are not part of the stack.
+			continue
+		}
+		sample.Location = append(sample.Location, p.location(pid, addr))
+	}
+	p.prof.Sample = append(p.prof.Sample, sample)
+	return nil
+}
+
+// location returns the location for the given address in the address space of
+// the given pid.
+func (p *perfDataParser) location(pid uint32, addr uint64) *profile.Location {
+	var m *profile.Mapping
+	for _, pms := range [][]perfMapping{p.mappings[pid], p.kernel} {
+		for i := len(pms) - 1; i >= 0 && m == nil; i-- {
+			if addr >= pms[i].start && addr < pms[i].limit {
+				m = pms[i].mapping
+			}
+		}
+	}
+
+	var mappingID uint64
+	if m != nil {
+		mappingID = m.ID
+	}
+	key := [2]uint64{mappingID, addr}
+	loc := p.locations[key]
+	if loc == nil {
+		loc = &profile.Location{
+			ID:      uint64(len(p.prof.Location) + 1),
+			Mapping: m,
+			Address: addr,
+		}
+		p.locations[key] = loc
+		p.prof.Location = append(p.prof.Location, loc)
+	}
+	return loc
+}
+
+// perfSampleEventID returns the id of the event the sample record belongs to.
+func perfSampleEventID(rec []byte, st uint64, order binary.ByteOrder) (uint64, bool) {
+	if st&perfSampleIdentifier != 0 {
+		if len(rec) < 8 {
+			return 0, false
+		}
+		return order.Uint64(rec), true
+	} else if st&perfSampleID == 0 {
+		return 0, false
+	}
+	off := 0
+	for _, bit := range []uint64{perfSampleIP, perfSampleTID, perfSampleTime, perfSampleAddr} {
+		if st&bit != 0 {
+			off += 8
+		}
+	}
+	if len(rec) < off+8 {
+		return 0, false
+	}
+	return order.Uint64(rec[off:]), true
+}
+
+func perfEventName(attr *perfEventAttr) string {
+	switch {
+	case attr.typ == perfTypeHardware && attr.config < uint64(len(perfHardwareEvents)):
+		return perfHardwareEvents[attr.config]
+	case attr.typ == perfTypeSoftware && attr.config < uint64(len(perfSoftwareEvents)):
+		return perfSoftwareEvents[attr.config]
+	}
+	return fmt.Sprintf("type%d-config%#x", attr.typ, attr.config)
+}
+
+func perfEventUnit(attr *perfEventAttr) string {
+	if attr.typ == perfTypeSoftware && attr.config <= 1 {
+		// The period of cpu-clock and task-clock is measured in nanoseconds.
+		return "nanoseconds"
+	}
+	return "count"
+}
+
+// cString returns the NUL terminated string at the beginning of buf.
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
+
+// perfReader reads fixed size values from a record and remembers if it ran out
+// of data.
+type perfReader struct {
+	buf   []byte
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *perfReader) u64() uint64 {
+	if len(r.buf) < 8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := r.order.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v
+}
+
+func (r *perfReader) u32() uint32 {
+	if len(r.buf) < 4 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := r.order.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+// skipReadFormat skips the read_format values of a sample.
+func (r *perfReader) skipReadFormat(format uint64) {
+	perValue := 1
+	if format&perfFormatID != 0 {
+		perValue++
+	}
+	if format&perfFormatLost != 0 {
+		perValue++
+	}
+	nr := uint64(1)
+	if format&perfFormatGroup != 0 {
+		nr = r.u64()
+	}
+	if format&perfFormatTotalTimeEnabled != 0 {
+		r.u64()
+	}
+	if format&perfFormatTotalTimeRunning != 0 {
+		r.u64()
+	}
+	for i := uint64(0); i < nr*uint64(perValue) && r.err == nil; i++ {
+		r.u64()
+	}
+}
+
+// perfAttrSize is the size of the perf_event_attr structs written by
+// writePerfData, i.e. PERF_ATTR_SIZE_VER7.
+const perfAttrSize = 128
+
+// perfMaxCallchain is the maximum number of addresses of a sample record.
+// Record sizes are limited to 64KiB, so deeper stacks are truncated at the
+// root.
+const perfMaxCallchain = (math.MaxUint16 - 48) / 8
+
+// writePerfData writes prof as a little endian perf.data file. Each sample
+// type becomes an event, and every non-zero value of a sample becomes a sample
+// record of that event with the value as its period. The pid, tid and comm
+// labels of the samples and the mappings of their locations are written as
+// well. perf.data files can't hold symbols, so only the addresses of the
+// locations are written.
+func writePerfData(prof *profile.Profile, out io.Writer) error {
+	le := binary.LittleEndian
+	if len(prof.SampleType) == 0 {
+		return errors.New("profile has no sample types")
+	}
+	hasAddrs, hasPIDs := false, false
+	for _, loc := range prof.Location {
+		hasAddrs = hasAddrs || loc.Address != 0
+	}
+	for _, s := range prof.Sample {
+		_, ok := s.Label["pid"]
+		hasPIDs = hasPIDs || ok
+	}
+	if !hasAddrs && len(prof.Sample) > 0 {
+		return errors.New("profile has no addresses, perf.data files can't hold symbols")
+	}
+
+	sampleType := uint64(perfSampleIdentifier | perfSampleIP | perfSamplePeriod | perfSampleCallchain)
+	if hasPIDs {
+		sampleType |= perfSampleTID
+	}
+	attrs := make([][]byte, len(prof.SampleType))
+	for i, st := range prof.SampleType {
+		typ, config := perfEventConfig(st, i)
+		attr := make([]byte, perfAttrSize)
+		le.PutUint32(attr[0:], typ)
+		le.PutUint32(attr[4:], perfAttrSize)
+		le.PutUint64(attr[8:], config)
+		le.PutUint64(attr[16:], 1) // sample_period
+		le.PutUint64(attr[24:], sampleType)
+		attrs[i] = attr
+	}
+
+	var (
+		data  []byte
+		comms = map[uint32]string{}
+		mmaps = map[[2]uint64]bool{}
+	)
+	record := func(typ uint32, misc uint16, body []byte) {
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+		data = le.AppendUint32(data, typ)
+		data = le.AppendUint16(data, misc)
+		data = le.AppendUint16(data, uint16(8+len(body)))
+		data = append(data, body...)
+	}
+	// mmap writes a PERF_RECORD_MMAP2 for m in the address space of pid unless
+	// it has already been written.
+	mmap := func(pid uint32, m *profile.Mapping) {
+		misc := uint16(perfRecordMiscUser)
+		if strings.HasPrefix(m.File, "[kernel") {
+			pid, misc = ^uint32(0), perfRecordMiscKernel
+		}
+		key := [2]uint64{uint64(pid), m.ID}
+		if mmaps[key] {
+			return
+		}
+		mmaps[key] = true
+		body := le.AppendUint32(nil, pid)
+		body = le.AppendUint32(body, pid)
+		body = le.AppendUint64(body, m.Start)
+		body = le.AppendUint64(body, m.Limit-m.Start)
+		body = le.AppendUint64(body, m.Offset)
+		id := make([]byte, 24)
+		if buildID, err := hex.DecodeString(m.BuildID); err == nil && len(buildID) > 0 && len(buildID) <= 20 {
+			misc |= perfRecordMiscMmapBuildID
+			id[0] = byte(len(buildID))
+			copy(id[4:], buildID)
+		}
+		body = append(body, id...)
+		body = le.AppendUint32(body, 5) // PROT_READ|PROT_EXEC
+		body = le.AppendUint32(body, 2) // MAP_PRIVATE
+		body = append(body, m.File...)
+		record(perfRecordMmap2, misc, append(body, 0))
+	}
+
+	for i, s := range prof.Sample {
+		if len(s.Value) != len(prof.SampleType) {
+			return fmt.Errorf("sample %d has %d values, expected %d", i, len(s.Value), len(prof.SampleType))
+		}
+		pid, tid, err := perfSamplePIDs(s)
+		if err != nil {
+			return fmt.Errorf("sample %d: %w", i, err)
+		}
+		if comm := s.Label["comm"]; len(comm) > 0 && comms[tid] != comm[0] {
+			comms[tid] = comm[0]
+			body := le.AppendUint32(nil, pid)
+			body = le.AppendUint32(body, tid)
+			record(perfRecordComm, 0, append(append(body, comm[0]...), 0))
+		}
+
+		locs := s.Location
+		if len(locs) > perfMaxCallchain {
+			locs = locs[:perfMaxCallchain]
+		}
+		for _, loc := range locs {
+			if loc.Mapping != nil {
+				mmap(pid, loc.Mapping)
+			}
+		}
+
+		for j, v := range s.Value {
+			if v == 0 {
+				continue
+			} else if v < 0 {
+				return fmt.Errorf("sample %d has a negative %s value: %d", i, prof.SampleType[j].Type, v)
+			}
+			var ip uint64
+			if len(locs) > 0 {
+				ip = locs[0].Address
+			}
+			body := le.AppendUint64(nil, uint64(j+1)) // identifier
+			body = le.AppendUint64(body, ip)
+			if hasPIDs {
+				body = le.AppendUint32(body, pid)
+				body = le.AppendUint32(body, tid)
+			}
+			body = le.AppendUint64(body, uint64(v))
+			body = le.AppendUint64(body, uint64(len(locs)))
+			for _, loc := range locs {
+				body = le.AppendUint64(body, loc.Address)
+			}
+			record(perfRecordSample, perfRecordMiscUser, body)
+		}
+	}
+
+	// The HEADER_EVENT_DESC feature section holds the names of the events.
+	desc := le.AppendUint32(nil, uint32(len(attrs)))
+	desc = le.AppendUint32(desc, perfAttrSize)
+	for i, attr := range attrs {
+		desc = append(desc, attr...)
+		desc = le.AppendUint32(desc, 1)
+		name := make([]byte, (len(prof.SampleType[i].Type)/64+1)*64)
+		copy(name, prof.SampleType[i].Type)
+		desc = le.AppendUint32(desc, uint32(len(name)))
+		desc = append(desc, name...)
+		desc = le.AppendUint64(desc, uint64(i+1))
+	}
+
+	var (
+		fileAttrSize = uint64(perfAttrSize + 16)
+		attrsOff     = uint64(perfFileHeaderSize)
+		idsOff       = attrsOff + uint64(len(attrs))*fileAttrSize
+		dataOff      = idsOff + uint64(len(attrs))*8
+		featuresOff  = dataOff + uint64(len(data))
+		descOff      = featuresOff + 16
+	)
+	file := le.AppendUint64(nil, perfMagic)
+	file = le.AppendUint64(file, perfFileHeaderSize)
+	file = le.AppendUint64(file, fileAttrSize)
+	file = le.AppendUint64(file, attrsOff)
+	file = le.AppendUint64(file, uint64(len(attrs))*fileAttrSize)
+	file = le.AppendUint64(file, dataOff)
+	file = le.AppendUint64(file, uint64(len(data)))
+	file = append(file, make([]byte, 16)...) // event_types
+	file = le.AppendUint64(file, 1<<perfHeaderEventDesc)
+	file = append(file, make([]byte, 24)...)
+	for i, attr := range attrs {
+		file = append(file, attr...)
+		file = le.AppendUint64(file, idsOff+uint64(i)*8)
+		file = le.AppendUint64(file, 8)
+	}
+	for i := range attrs {
+		file = le.AppendUint64(file, uint64(i+1))
+	}
+	file = append(file, data...)
+	file = le.AppendUint64(file, descOff)
+	file = le.AppendUint64(file, uint64(len(desc)))
+	file = append(file, desc...)
+
+	_, err := out.Write(file)
+	return err
+}
+
+// perfEventConfig returns the perf_event_attr type and config for the sample
+// type with the given index. Sample types measured in nanoseconds become
+// cpu-clock events, so their unit is retained when converting back to pprof.
+func perfEventConfig(st *profile.ValueType, i int) (uint32, uint64) {
+	if st.Unit == "nanoseconds" {
+		return perfTypeSoftware, 0
+	}
+	name, _, _ := strings.Cut(st.Type, ":")
+	for config, event := range perfHardwareEvents {
+		if event == name {
+			return perfTypeHardware, uint64(config)
+		}
+	}
+	return perfTypeRaw, uint64(i)
+}
+
+// perfSamplePIDs returns the pid and tid of a sample from its pid and tid
+// labels. The tid defaults to the pid.
+func perfSamplePIDs(s *profile.Sample) (pid, tid uint32, err error) {
+	parse := func(key string) (uint32, bool, error) {
+		vals := s.Label[key]
+		if len(vals) == 0 {
+			return 0, false, nil
+		}
+		v, err := strconv.ParseUint(vals[0], 10, 32)
+		if err != nil {
+			return 0, false, fmt.Errorf("bad %s label: %q", key, vals[0])
+		}
+		return uint32(v), true, nil
+	}
+	pid, _, err = parse("pid")
+	if err != nil {
+		return 0, 0, err
+	}
+	tid, ok, err := parse("tid")
+	if err != nil {
+		return 0, 0, err
+	} else if !ok {
+		tid = pid
+	}
+	return pid, tid, nil
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerfData(t *testing.T) {
+	// perf.data is generated by testdata/perfdata.go
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "perf.data"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, (&PerfData{Input: data, Output: buf}).Execute(context.Background()))
+
+	pprofData := buf.Bytes()
+	prof, err := profile.ParseData(pprofData)
+	require.NoError(t, err)
+	require.Equal(t, "cycles:u/count cpu-clock/nanoseconds", formatSampleTypes(prof))
+	require.Len(t, prof.Sample, 3)
+	require.Len(t, prof.Location, 4)
+	require.Len(t, prof.Mapping, 2)
+	require.Equal(t, &profile.ValueType{}, prof.PeriodType)
+	require.Equal(t, int64(0), prof.Period)
+	kernel, app := prof.Mapping[0], prof.Mapping[1]
+	require.Equal(t, uint64(0xffffffff81000000), kernel.Start)
+	require.Equal(t, uint64(0xffffffff82000000), kernel.Limit)
+	require.Equal(t, uint64(0x400000), app.Start)
+	require.Equal(t, uint64(0x410000), app.Limit)
+	require.False(t, app.HasFunctions, "perf.data files contain no symbols")
+	for _, loc := range prof.Location {
+		require.NotNil(t, loc.Mapping)
+		require.Empty(t, loc.Line)
+	}
+
+	s := prof.Sample[0]
+	require.Equal(t, []int64{1000, 0}, s.Value)
+	require.Equal(t, map[string][]string{"pid": {"100"}, "tid": {"100"}, "comm": {"app"}}, s.Label)
+	require.Len(t, s.Location, 3)
+	require.Equal(t, uint64(0xffffffff81000100), s.Location[0].Address)
+	require.Equal(t, "[kernel.kallsyms]_text", s.Location[0].Mapping.File)
+	require.Equal(t, "/usr/bin/app", s.Location[1].Mapping.File)
+	require.Equal(t, "000102030405060708090a0b0c0d0e0f10111213", s.Location[1].Mapping.BuildID)
+	require.Equal(t, uint64(0x1000), s.Location[1].Mapping.Offset)
+
+	s = prof.Sample[1]
+	require.Equal(t, []int64{0, 250000}, s.Value)
+	require.Equal(t, []string{"worker"}, s.Label["comm"])
+	require.Equal(t, "/usr/bin/app", s.Location[1].Mapping.File, "mappings are inherited via fork")
+
+	_, err = parsePerfData([]byte("not a perf.data file"))
+	require.Error(t, err)
+
+	t.Run("write", func(t *testing.T) {
+		perfOut := &bytes.Buffer{}
+		require.NoError(t, (&PerfData{Input: pprofData, Output: perfOut}).Execute(context.Background()))
+		pprofOut := &bytes.Buffer{}
+		require.NoError(t, (&PerfData{Input: perfOut.Bytes(), Output: pprofOut}).Execute(context.Background()))
+		got, err := profile.Parse(pprofOut)
+		require.NoError(t, err)
+
+		require.Equal(t, formatSampleTypes(prof), formatSampleTypes(got))
+		require.Len(t, got.Sample, len(prof.Sample))
+		for i, s := range prof.Sample {
+			require.Equal(t, s.Value, got.Sample[i].Value)
+			require.Equal(t, s.Label, got.Sample[i].Label)
+			require.Len(t, got.Sample[i].Location, len(s.Location))
+			for j, loc := range s.Location {
+				gotLoc := got.Sample[i].Location[j]
+				require.Equal(t, loc.Address, gotLoc.Address)
+				require.Equal(t, loc.Mapping.File, gotLoc.Mapping.File)
+				require.Equal(t, loc.Mapping.Start, gotLoc.Mapping.Start)
+				require.Equal(t, loc.Mapping.Limit, gotLoc.Mapping.Limit)
+				require.Equal(t, loc.Mapping.Offset, gotLoc.Mapping.Offset)
+				require.Equal(t, loc.Mapping.BuildID, gotLoc.Mapping.BuildID)
+			}
+		}
+	})
+
+	t.Run("write without addresses", func(t *testing.T) {
+		err := (&PerfData{Input: foldedProfile(t, "main;foo 1"), Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.EqualError(t, err, "profile has no addresses, perf.data files can't hold symbols")
+	})
+}
+
+func TestPerfDataRecord(t *testing.T) {
+	// perf-record.data is recorded by testdata/perfrecord.go
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "perf-record.data"))
+	require.NoError(t, err)
+
+	prof, err := parsePerfData(data)
+	require.NoError(t, err)
+	require.Equal(t, "cpu-clock/nanoseconds", formatSampleTypes(prof))
+	require.Len(t, prof.Sample, 84)
+
+	var files []string
+	for _, m := range prof.Mapping {
+		files = append(files, m.File)
+	}
+	require.Equal(t, []string{
+		"[kernel.kallsyms]_text",
+		"/tmp/app",
+		"/usr/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2",
+		"[vdso]",
+		"/usr/lib/x86_64-linux-gnu/libc.so.6",
+	}, files)
+	app := prof.Mapping[1]
+	require.Equal(t, "cde01d2695c65a95b9d38118817124fa47822dc3", app.BuildID)
+	require.Equal(t, uint64(0x1000), app.Offset)
+
+	tids := map[string]int{}
+	for _, s := range prof.Sample {
+		require.Equal(t, []int64{1000000}, s.Value)
+		require.Equal(t, []string{"2848"}, s.Label["pid"])
+		require.Equal(t, []string{"app"}, s.Label["comm"])
+		require.Equal(t, "/tmp/app", s.Location[0].Mapping.File)
+		require.Equal(t, "/usr/lib/x86_64-linux-gnu/libc.so.6", s.Location[len(s.Location)-1].Mapping.File)
+		tids[s.Label["tid"][0]]++
+	}
+	require.Equal(t, map[string]int{"2848": 46, "2849": 38}, tids)
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+var (
+	// perfScriptHeaderRE matches the first line of a sample, e.g.
+	// "perf 1234/1235 [002] 12345.678901: 250000 cpu-clock:pppH:". Tracepoint
+	// samples are followed by their payload, e.g.
+	// "perf 1234 [002] 12345.678901: 1 sched:sched_switch: prev_comm=perf".
+	perfScriptHeaderRE = regexp.MustCompile(`^(\S.*?)\s+(?:(\d+)/)?(\d+)\s+.*?:\s*(?:(\d+)\s+)?(\S+):(?:\s.*)?$`)
+	// perfScriptModifiersRE matches event modifiers like the "u" in "cycles:u".
+	perfScriptModifiersRE = regexp.MustCompile(`:[ukhIGHpPSDWeb]+$`)
+	// perfScriptFrameRE matches a stack frame of a sample, e.g.
+	// "ffffffff8102bd14 native_safe_halt+0x4 ([kernel.kallsyms])".
+	perfScriptFrameRE = regexp.MustCompile(`^\s+([0-9a-fA-F]+)\s+(?:(.*)\s+)?\(([^()]*(?: \(deleted\))?)\)$`)
+	// perfScriptOffsetRE matches the offset perf adds to symbol names.
+	perfScriptOffsetRE = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+)
+
+// PerfScript converts the text output of Linux perf script to pprof.
+type PerfScript struct {
+	Input  []byte
+	Output io.Writer
+}
+
+func (p *PerfScript) Execute(ctx context.Context) error {
+	prof, err := parsePerfScript(bytes.NewReader(p.Input))
+	if err != nil {
+		return err
+	}
+	return prof.Write(p.Output)
+}
+
+func parsePerfScript(in io.Reader) (*profile.Profile, error) {
+	type locationKey struct {
+		dso, name string
+		addr      uint64
+	}
+	var (
+		prof = &profile.Profile{
+			PeriodType: &profile.ValueType{},
+		}
+		events    = map[string][2]int{}
+		values    = map[*profile.Sample]map[int]int64{}
+		mappings  = map[string]*profile.Mapping{}
+		functions = map[string]*profile.Function{}
+		locations = map[locationKey]*profile.Location{}
+		sample    *profile.Sample
+	)
+
+	mapping := func(dso string) *profile.Mapping {
+		m := mappings[dso]
+		if m == nil {
+			m = &profile.Mapping{
+				ID:           uint64(len(prof.Mapping) + 1),
+				File:         dso,
+				HasFunctions: true,
+			}
+			mappings[dso] = m
+			prof.Mapping = append(prof.Mapping, m)
+		}
+		return m
+	}
+	function := func(name string) *profile.Function {
+		fn := functions[name]
+		if fn == nil {
+			fn = &profile.Function{
+				ID:   uint64(len(prof.Function) + 1),
+				Name: name,
+			}
+			functions[name] = fn
+			prof.Function = append(prof.Function, fn)
+		}
+		return fn
+	}
+
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			sample = nil
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if sample == nil {
+			m := perfScriptHeaderRE.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("bad line: %d: %q: expected sample header", n, line)
+			}
+			comm, pid, tid, period, event := m[1], m[2], m[3], m[4], m[5]
+			// Event modifiers like the "u" in "cycles:u" are not part of the
+			// sample type, the subsystem of tracepoints like the "sched" in
+			// "sched:sched_switch" is.
+			event = perfScriptModifiersRE.ReplaceAllString(event, "")
+
+			// idx holds the indexes of the sample count and of the sum of the
+			// periods of the event. The latter is -1 if perf doesn't print
+			// the period.
+			idx, ok := events[event]
+			if !ok {
+				idx = [2]int{len(prof.SampleType), -1}
+				prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: event, Unit: "count"})
+				if period != "" {
+					idx[1] = len(prof.SampleType)
+					prof.SampleType = append(prof.SampleType, &profile.ValueType{Type: event, Unit: perfScriptPeriodUnit(event)})
+				}
+				events[event] = idx
+			}
+
+			sample = &profile.Sample{Label: map[string][]string{"comm": {comm}, "tid": {tid}}}
+			if pid != "" {
+				sample.Label["pid"] = []string{pid}
+			}
+			values[sample] = map[int]int64{idx[0]: 1}
+			if period != "" && idx[1] >= 0 {
+				v, err := strconv.ParseInt(period, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("bad line: %d: %q: %w", n, line, err)
+				}
+				values[sample][idx[1]] = v
+			}
+			prof.Sample = append(prof.Sample, sample)
+			continue
+		}
+
+		m := perfScriptFrameRE.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("bad line: %d: %q: expected stack frame", n, line)
+		}
+		addr, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad line: %d: %q: %w", n, line, err)
+		}
+		name, dso := perfScriptOffsetRE.ReplaceAllString(m[2], ""), m[3]
+		if name == "" {
+			name = "[unknown]"
+		}
+		if strings.HasPrefix(dso, "[kernel") {
+			name += "_[k]"
+		}
+
+		key := locationKey{dso: dso, name: name, addr: addr}
+		loc := locations[key]
+		if loc == nil {
+			loc = &profile.Location{
+				ID:      uint64(len(prof.Location) + 1),
+				Mapping: mapping(dso),
+				Address: addr,
+				Line:    []profile.Line{{Function: function(name)}},
+			}
+			locations[key] = loc
+			prof.Location = append(prof.Location, loc)
+		}
+		sample.Location = append(sample.Location, loc)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(prof.Sample) == 0 {
+		return nil, fmt.Errorf("no samples found in perf script output")
+	}
+
+	for _, s := range prof.Sample {
+		s.Value = make([]int64, len(prof.SampleType))
+		for idx, v := range values[s] {
+			s.Value[idx] = v
+		}
+	}
+	return prof, prof.CheckValid()
+}
+
+// perfScriptPeriodUnit returns the unit of the period of the given event. The
+// period of cpu-clock and task-clock is measured in nanoseconds, the period of
+// other events is the number of events between two samples.
+func perfScriptPeriodUnit(event string) string {
+	if event == "cpu-clock" || event == "task-clock" {
+		return "nanoseconds"
+	}
+	return "events"
+}
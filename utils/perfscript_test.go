@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerfScript(t *testing.T) {
+	in := strings.TrimSpace(`
+# ========
+# captured on: Thu Jan  1 00:00:00 2024
+# ========
+#
+swapper     0 [000] 12345.678901:   10101010 cpu-clock:pppH:
+	ffffffff8102bd14 native_safe_halt+0x4 ([kernel.kallsyms])
+	ffffffff8100b8a5 default_idle+0x35 ([kernel.kallsyms])
+
+Web Content  1234/1240 [002] 12345.679000:       1000 cycles:u:
+	    55d0c1a2b3c4 std::vector<int>::operator[] (/usr/bin/app)
+	    55d0c1a2b000 main+0x10 (/usr/bin/app)
+	    7f0000001000 [unknown] (/tmp/jit-1234.so (deleted))
+
+Web Content  1234/1240 [002] 12345.679100:       2000 cycles:u:
+	    55d0c1a2b000 main+0x10 (/usr/bin/app)
+`)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, (&PerfScript{Input: []byte(in), Output: out}).Execute(context.Background()))
+
+	prof, err := profile.Parse(out)
+	require.NoError(t, err)
+	require.Equal(t, "cpu-clock/count cpu-clock/nanoseconds cycles/count cycles/events", formatSampleTypes(prof))
+	require.Equal(t, map[string]int64{
+		"default_idle_[k];native_safe_halt_[k]":       1,
+		"[unknown];main;std::vector<int>::operator[]": 0,
+		"main": 0,
+	}, sampleValues(prof, "", 0))
+	require.Equal(t, map[string]int64{
+		"0 default_idle_[k];native_safe_halt_[k]":          0,
+		"1240 [unknown];main;std::vector<int>::operator[]": 1,
+		"1240 main": 1,
+	}, sampleValues(prof, "tid", 2))
+	// The periods of the events are retained.
+	require.Equal(t, map[string]int64{
+		"default_idle_[k];native_safe_halt_[k]":       10101010,
+		"[unknown];main;std::vector<int>::operator[]": 0,
+		"main": 0,
+	}, sampleValues(prof, "", 1))
+	require.Equal(t, map[string]int64{
+		"default_idle_[k];native_safe_halt_[k]":       0,
+		"[unknown];main;std::vector<int>::operator[]": 1000,
+		"main": 2000,
+	}, sampleValues(prof, "", 3))
+
+	s := prof.Sample[1]
+	require.Equal(t, []string{"Web Content"}, s.Label["comm"])
+	require.Equal(t, []string{"1234"}, s.Label["pid"])
+	require.Equal(t, "/usr/bin/app", s.Location[0].Mapping.File)
+	require.Equal(t, uint64(0x55d0c1a2b3c4), s.Location[0].Address)
+	require.Equal(t, "/tmp/jit-1234.so (deleted)", s.Location[2].Mapping.File)
+	require.Len(t, prof.Mapping, 3)
+	require.Len(t, prof.Location, 5)
+	require.Equal(t, &profile.ValueType{}, prof.PeriodType)
+	var files []string
+	for _, m := range prof.Mapping {
+		files = append(files, m.File)
+		require.True(t, m.HasFunctions, "perf script symbolizes all frames")
+	}
+	require.Equal(t, []string{"[kernel.kallsyms]", "/usr/bin/app", "/tmp/jit-1234.so (deleted)"}, files)
+	for _, loc := range prof.Location {
+		require.NotNil(t, loc.Mapping)
+		require.Len(t, loc.Line, 1)
+	}
+
+	t.Run("without period", func(t *testing.T) {
+		in := "app 42 [000] 1.000000: cycles:\n\t    1000 main (/usr/bin/app)\n"
+		out := &bytes.Buffer{}
+		require.NoError(t, (&PerfScript{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, "cycles/count", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{"main": 1}, sampleValues(prof, "", 0))
+	})
+
+	t.Run("tracepoints", func(t *testing.T) {
+		in := strings.TrimSpace(`
+app 42 [000] 1.000000: 1 sched:sched_switch: prev_comm=app prev_pid=42 prev_prio=120 prev_state=S ==> next_comm=swapper/0 next_pid=0 next_prio=120
+	    1000 schedule (/usr/bin/app)
+
+app 42 [000] 1.000100: 1 sched:sched_wakeup: comm=app pid=43 prio=120 target_cpu=001
+	    1000 schedule (/usr/bin/app)
+
+app 42 [000] 1.000200: sched:sched_switch:k: prev_comm=app prev_pid=42
+	    1000 schedule (/usr/bin/app)
+`)
+		out := &bytes.Buffer{}
+		require.NoError(t, (&PerfScript{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, "sched:sched_switch/count sched:sched_switch/events sched:sched_wakeup/count sched:sched_wakeup/events", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{"schedule": 2}, sampleValues(prof, "", 0))
+		require.Equal(t, map[string]int64{"schedule": 1}, sampleValues(prof, "", 2))
+	})
+}
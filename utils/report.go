@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/felixge/pprofutils/v2/internal/legacy"
+	"github.com/google/pprof/profile"
+)
+
+// Report renders a self-contained HTML report for a profile. It contains a
+// summary, a top table, a flame graph and a label breakdown for every sample
+// type and can be viewed offline.
+type Report struct {
+	Input  []byte
+	Output io.Writer
+	Title  string
+	// Top limits the number of functions in the top table. 0 means no limit.
+	Top int
+}
+
+// reportMaxLabelValues limits the number of values shown per label key.
+const reportMaxLabelValues = 50
+
+func (r *Report) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(r.Input)
+	if err != nil {
+		return err
+	}
+	if r.Top < 0 {
+		return fmt.Errorf("top must be >= 0: %d", r.Top)
+	}
+	defaultIdx, err := selectSampleType(prof, "")
+	if err != nil {
+		return err
+	}
+
+	data := reportData{
+		Title:    r.Title,
+		Samples:  len(prof.Sample),
+		Comments: prof.Comments,
+		Default:  defaultIdx,
+	}
+	if data.Title == "" {
+		data.Title = "Profile Report"
+	}
+	if prof.TimeNanos != 0 {
+		data.Time = time.Unix(0, prof.TimeNanos).UTC().Format(time.RFC3339)
+	}
+	if prof.DurationNanos != 0 {
+		data.Duration = time.Duration(prof.DurationNanos).String()
+	}
+	if prof.PeriodType != nil {
+		data.Period = fmt.Sprintf("%s %s/%s", formatValue(prof.Period, prof.PeriodType.Unit), prof.PeriodType.Type, prof.PeriodType.Unit)
+	}
+
+	for idx := range prof.SampleType {
+		section, err := newReportSection(prof, idx, r.Top)
+		if err != nil {
+			return err
+		}
+		data.SampleTypes = append(data.SampleTypes, section)
+	}
+	return reportTemplate.Execute(r.Output, data)
+}
+
+type reportData struct {
+	Title       string
+	Time        string
+	Duration    string
+	Period      string
+	Samples     int
+	Comments    []string
+	Default     int
+	SampleTypes []*reportSection
+}
+
+// reportSection holds the parts of the report for one sample type.
+type reportSection struct {
+	Name       string
+	Total      string
+	Top        []reportRow
+	Omitted    int
+	Flamegraph template.HTML
+	Labels     []reportLabel
+}
+
+type reportRow struct {
+	Name    string
+	Flat    int64
+	FlatS   string
+	FlatPct string
+	Sum     int64
+	SumPct  string
+	Cum     int64
+	CumS    string
+	CumPct  string
+}
+
+type reportLabel struct {
+	Key     string
+	Values  []reportRow
+	Omitted int
+}
+
+func newReportSection(prof *profile.Profile, idx, top int) (*reportSection, error) {
+	st := prof.SampleType[idx]
+	value := func(s *profile.Sample) int64 { return s.Value[idx] }
+	var total int64
+	for _, s := range prof.Sample {
+		total += value(s)
+	}
+	section := &reportSection{
+		Name:  st.Type + "/" + st.Unit,
+		Total: formatValue(total, st.Unit),
+	}
+	pct := func(v int64) string { return fmt.Sprintf("%.2f%%", percent(v, total)) }
+
+	var sum int64
+	for _, e := range topEntries(prof, value, "function") {
+		sum += e.Flat
+		if top > 0 && len(section.Top) >= top {
+			section.Omitted++
+			continue
+		}
+		section.Top = append(section.Top, reportRow{
+			Name:    e.Name,
+			Flat:    e.Flat,
+			FlatS:   formatValue(e.Flat, st.Unit),
+			FlatPct: pct(e.Flat),
+			Sum:     sum,
+			SumPct:  pct(sum),
+			Cum:     e.Cum,
+			CumS:    formatValue(e.Cum, st.Unit),
+			CumPct:  pct(e.Cum),
+		})
+	}
+
+	svg := &bytes.Buffer{}
+	root := newFlameTree(prof, value)
+	if err := renderFlamegraph(svg, root, flameOptions{
+		Title:    "Flame Graph",
+		Subtitle: section.Name,
+		Width:    1200,
+		Color:    func(n *flameNode) string { return packageColor(n.Name) },
+		Details: func(n *flameNode) string {
+			return fmt.Sprintf("%s (%s, %.2f%%)", n.Name, formatValue(n.Value, st.Unit), percent(n.Value, root.Value))
+		},
+	}); err != nil {
+		return nil, err
+	}
+	// The XML declaration is not allowed inside of HTML.
+	_, inline, _ := strings.Cut(svg.String(), "\n")
+	section.Flamegraph = template.HTML(inline)
+
+	for _, key := range labelKeys(prof) {
+		values := map[string]int64{}
+		for _, s := range prof.Sample {
+			name := "N/A"
+			if vals, ok := s.Label[key]; ok {
+				name = strings.Join(vals, ",")
+			} else if vals, ok := s.NumLabel[key]; ok {
+				var names []string
+				for i, v := range vals {
+					unit := ""
+					if i < len(s.NumUnit[key]) {
+						unit = s.NumUnit[key][i]
+					}
+					names = append(names, formatValue(v, unit))
+				}
+				name = strings.Join(names, ",")
+			}
+			values[name] += value(s)
+		}
+		label := reportLabel{Key: key}
+		for _, name := range legacy.SortedKeys(values) {
+			v := values[name]
+			label.Values = append(label.Values, reportRow{Name: name, Flat: v, FlatS: formatValue(v, st.Unit), FlatPct: pct(v)})
+		}
+		sort.SliceStable(label.Values, func(i, j int) bool { return label.Values[i].Flat > label.Values[j].Flat })
+		if len(label.Values) > reportMaxLabelValues {
+			label.Omitted = len(label.Values) - reportMaxLabelValues
+			label.Values = label.Values[:reportMaxLabelValues]
+		}
+		section.Labels = append(section.Labels, label)
+	}
+	return section, nil
+}
+
+// labelKeys returns the sorted string and numeric label keys of the profile.
+func labelKeys(prof *profile.Profile) []string {
+	keys := map[string]bool{}
+	for _, s := range prof.Sample {
+		for k := range s.Label {
+			keys[k] = true
+		}
+		for k := range s.NumLabel {
+			keys[k] = true
+		}
+	}
+	return legacy.SortedKeys(keys)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Verdana, sans-serif; font-size: 13px; margin: 20px; color: #222; }
+h1 { font-size: 22px; }
+h2 { font-size: 17px; margin-top: 28px; }
+h3 { font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 12px; }
+th, td { padding: 3px 10px; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.summary th { text-align: left; }
+.sortable th { cursor: pointer; user-select: none; background: #f4f4f4; }
+.sortable th[data-sort="asc"]::after { content: " \25B2"; }
+.sortable th[data-sort="desc"]::after { content: " \25BC"; }
+td.name { font-family: monospace; max-width: 700px; overflow-wrap: anywhere; }
+.flamegraph svg { width: 100%; height: auto; }
+.note { color: #777; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<h2>Summary</h2>
+<table class="summary">
+{{- if .Time}}
+<tr><th>Time</th><td>{{.Time}}</td></tr>
+{{- end}}
+{{- if .Duration}}
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+{{- end}}
+{{- if .Period}}
+<tr><th>Period</th><td>{{.Period}}</td></tr>
+{{- end}}
+<tr><th>Samples</th><td>{{.Samples}}</td></tr>
+{{- range .SampleTypes}}
+<tr><th>Total {{.Name}}</th><td>{{.Total}}</td></tr>
+{{- end}}
+</table>
+{{- range .Comments}}
+<p class="note">{{.}}</p>
+{{- end}}
+<p>
+<label>Sample type
+<select id="sample-type">
+{{- range $i, $st := .SampleTypes}}
+<option value="{{$i}}"{{if eq $i $.Default}} selected{{end}}>{{$st.Name}}{{if eq $i $.Default}} (default){{end}}</option>
+{{- end}}
+</select>
+</label>
+</p>
+{{- range $i, $st := .SampleTypes}}
+<div class="sample-type" data-index="{{$i}}"{{if ne $i $.Default}} hidden{{end}}>
+<h2>Top Functions</h2>
+<table class="sortable">
+<thead><tr><th>Function</th><th>Flat</th><th>Flat%</th><th>Sum%</th><th>Cum</th><th>Cum%</th></tr></thead>
+<tbody>
+{{- range .Top}}
+<tr><td class="name">{{.Name}}</td><td data-v="{{.Flat}}">{{.FlatS}}</td><td data-v="{{.Flat}}">{{.FlatPct}}</td><td data-v="{{.Sum}}">{{.SumPct}}</td><td data-v="{{.Cum}}">{{.CumS}}</td><td data-v="{{.Cum}}">{{.CumPct}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Omitted}}
+<p class="note">{{.Omitted}} more functions not shown.</p>
+{{- end}}
+<h2>Flame Graph</h2>
+<div class="flamegraph">
+{{.Flamegraph}}</div>
+<h2>Labels</h2>
+{{- range .Labels}}
+<h3>{{.Key}}</h3>
+<table class="sortable">
+<thead><tr><th>Value</th><th>Total</th><th>Total%</th></tr></thead>
+<tbody>
+{{- range .Values}}
+<tr><td class="name">{{.Name}}</td><td data-v="{{.Flat}}">{{.FlatS}}</td><td data-v="{{.Flat}}">{{.FlatPct}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Omitted}}
+<p class="note">{{.Omitted}} more values not shown.</p>
+{{- end}}
+{{- else}}
+<p class="note">The profile has no labels.</p>
+{{- end}}
+</div>
+{{- end}}
+<script>
+(function() {
+document.getElementById("sample-type").addEventListener("change", function(e) {
+  document.querySelectorAll(".sample-type").forEach(function(s) {
+    s.hidden = s.getAttribute("data-index") !== e.target.value;
+  });
+});
+function cellValue(td) {
+  return td.hasAttribute("data-v") ? parseFloat(td.getAttribute("data-v")) : td.textContent;
+}
+document.querySelectorAll(".sortable th").forEach(function(th) {
+  th.addEventListener("click", function() {
+    var table = th.closest("table"), tbody = table.tBodies[0], col = th.cellIndex;
+    var rows = Array.prototype.slice.call(tbody.rows);
+    if (!rows.length) return;
+    var numeric = typeof cellValue(rows[0].cells[col]) === "number";
+    var order = th.getAttribute("data-sort");
+    order = order ? (order === "asc" ? "desc" : "asc") : (numeric ? "desc" : "asc");
+    table.querySelectorAll("th").forEach(function(h) { h.removeAttribute("data-sort"); });
+    th.setAttribute("data-sort", order);
+    rows.sort(function(a, b) {
+      var x = cellValue(a.cells[col]), y = cellValue(b.cells[col]);
+      var c = numeric ? x - y : String(x).localeCompare(String(y));
+      return order === "asc" ? c : -c;
+    });
+    rows.forEach(function(r) { tbody.appendChild(r); });
+  });
+});
+})();
+</script>
+</body>
+</html>
+`))
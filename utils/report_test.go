@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	in := foldedProfile(t, `samples/count cpu/nanoseconds @extended
+{endpoint=/foo} main.main;main.work 3 3000
+{endpoint=/bar} main.main;main.work;main.work 1 1000
+main.main;main.<script> 6 6000
+`)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, (&Report{Input: in, Output: out, Title: "Incident <42>", Top: 2}).Execute(context.Background()))
+	got := out.String()
+
+	require.Contains(t, got, "<title>Incident &lt;42&gt;</title>")
+	require.Contains(t, got, "<tr><th>Samples</th><td>3</td></tr>")
+	require.Contains(t, got, "<tr><th>Total cpu/nanoseconds</th><td>10.00µs</td></tr>")
+	require.Contains(t, got, `<option value="1" selected>cpu/nanoseconds (default)</option>`)
+	require.Contains(t, got, `<tr><td class="name">main.&lt;script&gt;</td><td data-v="6000">6.00µs</td><td data-v="6000">60.00%</td><td data-v="6000">60.00%</td><td data-v="6000">6.00µs</td><td data-v="6000">60.00%</td></tr>`)
+	require.Contains(t, got, `<tr><td class="name">main.work</td><td data-v="4000">4.00µs</td><td data-v="4000">40.00%</td><td data-v="10000">100.00%</td><td data-v="4000">4.00µs</td><td data-v="4000">40.00%</td></tr>`)
+	require.Contains(t, got, "<p class=\"note\">1 more functions not shown.</p>")
+	require.Contains(t, got, `<tr><td class="name">N/A</td><td data-v="6000">6.00µs</td><td data-v="6000">60.00%</td></tr>`)
+	require.Contains(t, got, `<tr><td class="name">/foo</td><td data-v="3000">3.00µs</td><td data-v="3000">30.00%</td></tr>`)
+	require.Contains(t, got, `<g class="f" data-n="main.work"`)
+	require.NotContains(t, got, "<?xml")
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// selectSampleType returns the index of the sample type given as "type/unit".
+// If s is empty, the index of the default sample type is returned, which is
+// the last sample type unless the profile says otherwise.
+func selectSampleType(prof *profile.Profile, s string) (int, error) {
+	if len(prof.SampleType) == 0 {
+		return -1, fmt.Errorf("profile has no sample types")
+	}
+	if s == "" {
+		for i, st := range prof.SampleType {
+			if st.Type == prof.DefaultSampleType {
+				return i, nil
+			}
+		}
+		return len(prof.SampleType) - 1, nil
+	}
+	vt, err := parseValueType(s)
+	if err != nil {
+		return -1, err
+	}
+	idx := sampleTypeIndex(prof, vt)
+	if idx < 0 {
+		return -1, fmt.Errorf("sample type not found in profile: %s (available: %s)", s, formatSampleTypes(prof))
+	}
+	return idx, nil
+}
+
+// parseValueType parses a sample type given as "type/unit".
+func parseValueType(s string) (profile.ValueType, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return profile.ValueType{}, fmt.Errorf("bad sample type: %q: must be type/unit", s)
+	}
+	return profile.ValueType{Type: parts[0], Unit: parts[1]}, nil
+}
+
+// formatSampleTypes returns the sample types of prof as a space separated list
+// of type/unit pairs.
+func formatSampleTypes(prof *profile.Profile) string {
+	var sampleTypes []string
+	for _, st := range prof.SampleType {
+		sampleTypes = append(sampleTypes, st.Type+"/"+st.Unit)
+	}
+	return strings.Join(sampleTypes, " ")
+}
+
+// formatValue formats v for humans according to its unit.
+func formatValue(v int64, unit string) string {
+	f, sign := float64(v), ""
+	if f < 0 {
+		f, sign = -f, "-"
+	}
+	switch unit {
+	case "nanoseconds":
+		switch {
+		case f >= 1e9:
+			return fmt.Sprintf("%s%.2fs", sign, f/1e9)
+		case f >= 1e6:
+			return fmt.Sprintf("%s%.2fms", sign, f/1e6)
+		case f >= 1e3:
+			return fmt.Sprintf("%s%.2fµs", sign, f/1e3)
+		}
+		return fmt.Sprintf("%dns", v)
+	case "bytes":
+		switch {
+		case f >= 1<<30:
+			return fmt.Sprintf("%s%.2fGB", sign, f/(1<<30))
+		case f >= 1<<20:
+			return fmt.Sprintf("%s%.2fMB", sign, f/(1<<20))
+		case f >= 1<<10:
+			return fmt.Sprintf("%s%.2fkB", sign, f/(1<<10))
+		}
+		return fmt.Sprintf("%dB", v)
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+// percent returns v as a percentage of total.
+func percent(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(v) / float64(total)
+}
+
+// labelValue returns the values of the label with the given key joined by
+// commas and whether the sample has the label. Numeric values are formatted
+// according to their unit.
+func labelValue(s *profile.Sample, key string) (string, bool) {
+	if vals, ok := s.Label[key]; ok {
+		return strings.Join(vals, ","), true
+	}
+	nums, ok := s.NumLabel[key]
+	if !ok {
+		return "", false
+	}
+	vals := make([]string, len(nums))
+	for i, v := range nums {
+		vals[i] = formatNumLabel(v, numLabelUnit(s, key, i))
+	}
+	return strings.Join(vals, ","), true
+}
+
+// formatNumLabel formats the value of a numeric label according to its unit.
+func formatNumLabel(v int64, unit string) string {
+	switch unit {
+	case "bytes", "nanoseconds", "":
+		return formatValue(v, unit)
+	default:
+		return fmt.Sprintf("%d%s", v, unit)
+	}
+}
+
+// numLabelUnit returns the unit of the i-th value of the numeric label with
+// the given key.
+func numLabelUnit(s *profile.Sample, key string, i int) string {
+	if units := s.NumUnit[key]; i < len(units) {
+		return units[i]
+	}
+	return ""
+}
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// Speedscope converts pprof to the speedscope file format and vice versa. The
+// direction is determined by the input format.
+type Speedscope struct {
+	Input  []byte
+	Output io.Writer
+}
+
+func (s *Speedscope) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(s.Input)
+	if err == nil {
+		return toSpeedscope(prof, s.Output)
+	}
+
+	var file speedscopeFile
+	if err := json.Unmarshal(s.Input, &file); err != nil || !strings.Contains(file.Schema, "speedscope") {
+		return errors.New("input format is neither pprof nor speedscope json")
+	}
+	prof, err = fromSpeedscope(&file)
+	if err != nil {
+		return err
+	}
+	return prof.Write(s.Output)
+}
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name,omitempty"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter,omitempty"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+	Col  int64  `json:"col,omitempty"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue float64 `json:"startValue"`
+	EndValue   float64 `json:"endValue"`
+	// Samples and Weights are used by "sampled" profiles. Each sample is a
+	// list of frame indexes ordered from root to leaf.
+	Samples [][]int   `json:"samples,omitempty"`
+	Weights []float64 `json:"weights,omitempty"`
+	// Events are used by "evented" profiles.
+	Events []speedscopeEvent `json:"events,omitempty"`
+}
+
+type speedscopeEvent struct {
+	Type  string  `json:"type"`
+	At    float64 `json:"at"`
+	Frame int     `json:"frame"`
+}
+
+// speedscopeUnits are the units supported by speedscope. Other units are
+// converted to "none".
+var speedscopeUnits = map[string]bool{
+	"bytes":        true,
+	"nanoseconds":  true,
+	"microseconds": true,
+	"milliseconds": true,
+	"seconds":      true,
+}
+
+func toSpeedscope(prof *profile.Profile, out io.Writer) error {
+	file := &speedscopeFile{
+		Schema:   speedscopeSchema,
+		Exporter: "pprofutils",
+	}
+
+	frameIdx := map[speedscopeFrame]int{}
+	stacks := make([][]int, len(prof.Sample))
+	for i, s := range prof.Sample {
+		stack := []int{}
+		for j := len(s.Location) - 1; j >= 0; j-- {
+			loc := s.Location[j]
+			for k := len(loc.Line) - 1; k >= 0; k-- {
+				line := loc.Line[k]
+				frame := speedscopeFrame{
+					Name: line.Function.Name,
+					File: line.Function.Filename,
+					Line: line.Line,
+				}
+				idx, ok := frameIdx[frame]
+				if !ok {
+					idx = len(file.Shared.Frames)
+					frameIdx[frame] = idx
+					file.Shared.Frames = append(file.Shared.Frames, frame)
+				}
+				stack = append(stack, idx)
+			}
+		}
+		stacks[i] = stack
+	}
+
+	for i, st := range prof.SampleType {
+		p := speedscopeProfile{
+			Type:    "sampled",
+			Name:    st.Type + "/" + st.Unit,
+			Unit:    st.Unit,
+			Samples: [][]int{},
+			Weights: []float64{},
+		}
+		if !speedscopeUnits[p.Unit] {
+			p.Unit = "none"
+		}
+		for j, s := range prof.Sample {
+			if s.Value[i] == 0 {
+				continue
+			}
+			p.Samples = append(p.Samples, stacks[j])
+			p.Weights = append(p.Weights, float64(s.Value[i]))
+			p.EndValue += float64(s.Value[i])
+		}
+		file.Profiles = append(file.Profiles, p)
+	}
+	if file.Shared.Frames == nil {
+		file.Shared.Frames = []speedscopeFrame{}
+	}
+	if prof.DefaultSampleType != "" {
+		for i, st := range prof.SampleType {
+			if st.Type == prof.DefaultSampleType {
+				file.ActiveProfileIndex = i
+			}
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	return enc.Encode(file)
+}
+
+func fromSpeedscope(file *speedscopeFile) (*profile.Profile, error) {
+	var (
+		prof = &profile.Profile{
+			PeriodType: &profile.ValueType{},
+		}
+		m             = &profile.Mapping{ID: 1, HasFunctions: true}
+		functions     = map[speedscopeFrame]*profile.Function{}
+		locations     = map[speedscopeFrame]*profile.Location{}
+		sampleTypeIdx = map[string]int{}
+		samples       []*profile.Sample
+		sampleTypes   []int
+	)
+	prof.Mapping = []*profile.Mapping{m}
+
+	location := func(idx int) (*profile.Location, error) {
+		if idx < 0 || idx >= len(file.Shared.Frames) {
+			return nil, fmt.Errorf("bad frame index: %d", idx)
+		}
+		frame := file.Shared.Frames[idx]
+		frame.Col = 0
+		if loc := locations[frame]; loc != nil {
+			return loc, nil
+		}
+		fnKey := speedscopeFrame{Name: frame.Name, File: frame.File}
+		fn := functions[fnKey]
+		if fn == nil {
+			fn = &profile.Function{
+				ID:       uint64(len(prof.Function) + 1),
+				Name:     frame.Name,
+				Filename: frame.File,
+			}
+			functions[fnKey] = fn
+			prof.Function = append(prof.Function, fn)
+		}
+		loc := &profile.Location{
+			ID:      uint64(len(prof.Location) + 1),
+			Mapping: m,
+			Line:    []profile.Line{{Function: fn, Line: frame.Line}},
+		}
+		locations[frame] = loc
+		prof.Location = append(prof.Location, loc)
+		return loc, nil
+	}
+
+	addSample := func(p *speedscopeProfile, st int, stack []int, weight int64) error {
+		if weight == 0 {
+			return nil
+		}
+		s := &profile.Sample{}
+		if _, err := parseValueType(p.Name); err != nil && p.Name != "" {
+			// Keep the name of profiles that don't represent a sample type,
+			// e.g. the name of a thread.
+			s.Label = map[string][]string{"profile": {p.Name}}
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			loc, err := location(stack[i])
+			if err != nil {
+				return err
+			}
+			s.Location = append(s.Location, loc)
+		}
+		s.Value = []int64{weight}
+		samples = append(samples, s)
+		sampleTypes = append(sampleTypes, st)
+		return nil
+	}
+
+	for i := range file.Profiles {
+		p := &file.Profiles[i]
+		st, scale := speedscopeSampleType(p)
+		idx, ok := sampleTypeIdx[st.Type+"/"+st.Unit]
+		if !ok {
+			idx = len(prof.SampleType)
+			sampleTypeIdx[st.Type+"/"+st.Unit] = idx
+			prof.SampleType = append(prof.SampleType, st)
+		}
+
+		switch p.Type {
+		case "sampled":
+			for j, stack := range p.Samples {
+				weight := scale
+				if j < len(p.Weights) {
+					weight = p.Weights[j] * scale
+				}
+				if err := addSample(p, idx, stack, int64(weight)); err != nil {
+					return nil, err
+				}
+			}
+		case "evented":
+			events := append([]speedscopeEvent(nil), p.Events...)
+			sort.SliceStable(events, func(i, j int) bool { return events[i].At < events[j].At })
+			var (
+				stack []int
+				last  = p.StartValue
+			)
+			for _, e := range events {
+				if len(stack) > 0 {
+					if err := addSample(p, idx, stack, int64((e.At-last)*scale)); err != nil {
+						return nil, err
+					}
+				}
+				last = e.At
+				switch e.Type {
+				case "O":
+					stack = append(stack, e.Frame)
+				case "C":
+					if len(stack) == 0 || stack[len(stack)-1] != e.Frame {
+						return nil, fmt.Errorf("profile %q: close event for frame %d does not match open frame", p.Name, e.Frame)
+					}
+					stack = stack[:len(stack)-1]
+				default:
+					return nil, fmt.Errorf("profile %q: unknown event type: %q", p.Name, e.Type)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported speedscope profile type: %q", p.Type)
+		}
+	}
+
+	for i, s := range samples {
+		value := make([]int64, len(prof.SampleType))
+		value[sampleTypes[i]] = s.Value[0]
+		s.Value = value
+	}
+	prof.Sample = samples
+	return prof, prof.CheckValid()
+}
+
+// speedscopeSampleType returns the sample type for the given profile and the
+// factor for scaling its values to the unit of the sample type. Profiles
+// exported by pprofutils are named after their sample type. For other profiles
+// the sample type is derived from the unit and time units are converted to
+// nanoseconds.
+func speedscopeSampleType(p *speedscopeProfile) (*profile.ValueType, float64) {
+	if vt, err := parseValueType(p.Name); err == nil {
+		return &vt, 1
+	}
+	switch p.Unit {
+	case "bytes":
+		return &profile.ValueType{Type: "space", Unit: "bytes"}, 1
+	case "nanoseconds":
+		return &profile.ValueType{Type: "wall", Unit: "nanoseconds"}, 1
+	case "microseconds":
+		return &profile.ValueType{Type: "wall", Unit: "nanoseconds"}, 1e3
+	case "milliseconds":
+		return &profile.ValueType{Type: "wall", Unit: "nanoseconds"}, 1e6
+	case "seconds":
+		return &profile.ValueType{Type: "wall", Unit: "nanoseconds"}, 1e9
+	default:
+		return &profile.ValueType{Type: "samples", Unit: "count"}, 1
+	}
+}
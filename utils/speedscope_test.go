@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpeedscope(t *testing.T) {
+	t.Run("roundtrip", func(t *testing.T) {
+		in := foldedProfile(t, "samples/count cpu/nanoseconds\nmain;foo 5 50\nmain;bar 3 0")
+
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Speedscope{Input: in, Output: out}).Execute(context.Background()))
+
+		var file speedscopeFile
+		require.NoError(t, json.Unmarshal(out.Bytes(), &file))
+		require.Equal(t, speedscopeSchema, file.Schema)
+		require.Len(t, file.Profiles, 2)
+		require.Equal(t, "cpu/nanoseconds", file.Profiles[1].Name)
+		require.Equal(t, "nanoseconds", file.Profiles[1].Unit)
+		require.Len(t, file.Profiles[1].Samples, 1)
+		require.Equal(t, []float64{50}, file.Profiles[1].Weights)
+
+		pprofOut := &bytes.Buffer{}
+		require.NoError(t, (&Speedscope{Input: out.Bytes(), Output: pprofOut}).Execute(context.Background()))
+		prof, err := profile.Parse(pprofOut)
+		require.NoError(t, err)
+		require.Equal(t, "samples/count cpu/nanoseconds", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{"main;foo": 5, "main;bar": 3}, sampleValues(prof, "", 0))
+		require.Equal(t, map[string]int64{"main;foo": 50, "main;bar": 0}, sampleValues(prof, "", 1))
+	})
+
+	t.Run("evented", func(t *testing.T) {
+		in := `{
+  "$schema": "https://www.speedscope.app/file-format-schema.json",
+  "shared": {"frames": [{"name": "main"}, {"name": "foo", "file": "foo.js", "line": 3}]},
+  "profiles": [{
+    "type": "evented", "name": "main thread", "unit": "milliseconds", "startValue": 0, "endValue": 10,
+    "events": [
+      {"type": "O", "frame": 0, "at": 0},
+      {"type": "O", "frame": 1, "at": 2},
+      {"type": "C", "frame": 1, "at": 7},
+      {"type": "C", "frame": 0, "at": 10}
+    ]
+  }]
+}`
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Speedscope{Input: []byte(in), Output: out}).Execute(context.Background()))
+		prof, err := profile.Parse(out)
+		require.NoError(t, err)
+		require.Equal(t, "wall/nanoseconds", formatSampleTypes(prof))
+		require.Equal(t, map[string]int64{
+			"main thread main":     5e6,
+			"main thread main;foo": 5e6,
+		}, sampleValues(prof, "profile", 0))
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		err := (&Speedscope{Input: []byte("{}"), Output: &bytes.Buffer{}}).Execute(context.Background())
+		require.EqualError(t, err, "input format is neither pprof nor speedscope json")
+	})
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// Split splits a profile into one profile per value of a label and writes
+// them into a zip archive. It is the inverse of merging profiles with a
+// source label.
+type Split struct {
+	Input  []byte
+	Output io.Writer
+	// Label is the key of the label to split by. Samples without the label
+	// are written into a profile named after the label without a value.
+	Label string
+}
+
+func (s *Split) Execute(ctx context.Context) error {
+	if s.Label == "" {
+		return fmt.Errorf("label must not be empty")
+	}
+	prof, err := profile.ParseData(s.Input)
+	if err != nil {
+		return err
+	}
+
+	// Samples without the label are grouped by the file name directly, so
+	// they can't collide with any label value.
+	groups := map[string][]*profile.Sample{}
+	for _, sample := range prof.Sample {
+		name := url.PathEscape(s.Label) + ".pprof"
+		if val, ok := labelValue(sample, s.Label); ok {
+			name = splitFileName(s.Label, val)
+		}
+		groups[name] = append(groups[name], sample)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(s.Output)
+	for _, name := range names {
+		// Merging a single profile compacts away the functions, locations
+		// and mappings that are not used by the samples.
+		prof.Sample = groups[name]
+		part, err := profile.Merge([]*profile.Profile{prof})
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		} else if err := part.Write(w); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// splitFileName returns the name of the file in the zip archive for the given
+// label value. The value is escaped so that it can't contain slashes.
+func splitFileName(label, val string) string {
+	return url.PathEscape(label+"="+val) + ".pprof"
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+{endpoint=/foo} main;foo 1
+{endpoint=/foo} main;foo;bar 2
+{endpoint=/bar} main;bar 3
+{endpoint=N/A} main;qux 5
+main;baz 4
+`)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, (&Split{Input: in, Output: out, Label: "endpoint"}).Execute(context.Background()))
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	got := map[string]map[string]int64{}
+	functions := map[string]int{}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		prof, err := profile.ParseData(data)
+		require.NoError(t, err)
+		got[f.Name] = sampleValues(prof, "", 0)
+		functions[f.Name] = len(prof.Function)
+	}
+	require.Equal(t, []string{"endpoint.pprof", "endpoint=%2Fbar.pprof", "endpoint=%2Ffoo.pprof", "endpoint=N%2FA.pprof"}, names)
+	// Samples without the label don't collide with the value N/A.
+	require.Equal(t, map[string]map[string]int64{
+		"endpoint.pprof":        {"main;baz": 4},
+		"endpoint=%2Fbar.pprof": {"main;bar": 3},
+		"endpoint=%2Ffoo.pprof": {"main;foo": 1, "main;foo;bar": 2},
+		"endpoint=N%2FA.pprof":  {"main;qux": 5},
+	}, got)
+	// Unused functions are compacted away.
+	require.Equal(t, map[string]int{
+		"endpoint.pprof":        2,
+		"endpoint=%2Fbar.pprof": 2,
+		"endpoint=%2Ffoo.pprof": 3,
+		"endpoint=N%2FA.pprof":  2,
+	}, functions)
+}
+
+func TestSplitNumLabel(t *testing.T) {
+	in := foldedProfile(t, `samples/count @extended
+{size=#512:bytes} main;foo 1
+{size=#2048:bytes} main;foo 2
+{size=#512:bytes} main;bar 3
+`)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, (&Split{Input: in, Output: out, Label: "size"}).Execute(context.Background()))
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	got := map[string]map[string]int64{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		prof, err := profile.ParseData(data)
+		require.NoError(t, err)
+		got[f.Name] = sampleValues(prof, "", 0)
+	}
+	// Numeric labels are grouped by their formatted value.
+	require.Equal(t, map[string]map[string]int64{
+		"size=2.00kB.pprof": {"main;foo": 2},
+		"size=512B.pprof":   {"main;foo": 1, "main;bar": 3},
+	}, got)
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/google/pprof/profile"
+)
+
+// Stats reports statistics about a profile, e.g. to sanity check it.
+type Stats struct {
+	Input  []byte
+	Output io.Writer
+	// Format is either "text" or "json".
+	Format string
+	// Recursions limits the number of recursive locations that are reported.
+	Recursions int
+}
+
+// ProfileStats holds the statistics computed by Stats.
+type ProfileStats struct {
+	Samples               int                 `json:"samples"`
+	Locations             int                 `json:"locations"`
+	Functions             int                 `json:"functions"`
+	Mappings              int                 `json:"mappings"`
+	Comments              int                 `json:"comments"`
+	UnsymbolizedLocations int                 `json:"unsymbolized_locations"`
+	DuplicateFunctions    int                 `json:"duplicate_functions"`
+	SampleTypes           []SampleTypeStats   `json:"sample_types"`
+	StackDepth            StackDepthStats     `json:"stack_depth"`
+	LabelCardinality      map[string]int      `json:"label_cardinality"`
+	Recursions            int64               `json:"recursions"`
+	RecursiveLocations    int                 `json:"recursive_locations"`
+	TopRecursiveLocations []RecursiveLocation `json:"top_recursive_locations"`
+}
+
+// SampleTypeStats holds the total value of a sample type.
+type SampleTypeStats struct {
+	Type  string `json:"type"`
+	Unit  string `json:"unit"`
+	Total int64  `json:"total"`
+}
+
+// StackDepthStats holds percentiles of the number of locations per sample.
+type StackDepthStats struct {
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+// RecursiveLocation is a location that appears more than once in the stack of
+// a sample.
+type RecursiveLocation struct {
+	ID       uint64 `json:"id"`
+	Count    int64  `json:"count"`
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int64  `json:"line"`
+}
+
+func (s *Stats) Execute(ctx context.Context) error {
+	if s.Recursions < 0 {
+		return fmt.Errorf("recursions must be >= 0: %d", s.Recursions)
+	}
+
+	prof, err := profile.ParseData(s.Input)
+	if err != nil {
+		return err
+	}
+
+	stats := computeStats(prof, s.Recursions)
+	switch s.Format {
+	case "json":
+		enc := json.NewEncoder(s.Output)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	case "text", "":
+		return writeStatsText(stats, s.Output)
+	default:
+		return fmt.Errorf("unknown format: %q", s.Format)
+	}
+}
+
+func computeStats(prof *profile.Profile, topRecursions int) *ProfileStats {
+	stats := &ProfileStats{
+		Samples:          len(prof.Sample),
+		Locations:        len(prof.Location),
+		Functions:        len(prof.Function),
+		Mappings:         len(prof.Mapping),
+		Comments:         len(prof.Comments),
+		LabelCardinality: map[string]int{},
+	}
+
+	for _, loc := range prof.Location {
+		if len(loc.Line) == 0 {
+			stats.UnsymbolizedLocations++
+		}
+	}
+
+	type functionKey struct {
+		name, systemName, filename string
+		startLine                  int64
+	}
+	seenFunctions := map[functionKey]bool{}
+	for _, fn := range prof.Function {
+		key := functionKey{fn.Name, fn.SystemName, fn.Filename, fn.StartLine}
+		if seenFunctions[key] {
+			stats.DuplicateFunctions++
+		}
+		seenFunctions[key] = true
+	}
+
+	totals := make([]int64, len(prof.SampleType))
+	depths := make([]int, 0, len(prof.Sample))
+	labelValues := map[string]map[string]bool{}
+	addLabelValue := func(key, val string) {
+		if labelValues[key] == nil {
+			labelValues[key] = map[string]bool{}
+		}
+		labelValues[key][val] = true
+	}
+	recursions := map[*profile.Location]int64{}
+	for _, sample := range prof.Sample {
+		for i, v := range sample.Value {
+			totals[i] += v
+		}
+		depths = append(depths, len(sample.Location))
+		for k, vals := range sample.Label {
+			for _, v := range vals {
+				addLabelValue(k, v)
+			}
+		}
+		for k, vals := range sample.NumLabel {
+			for _, v := range vals {
+				addLabelValue(k, fmt.Sprint(v))
+			}
+		}
+
+		seen := map[*profile.Location]bool{}
+		for _, loc := range sample.Location {
+			if seen[loc] {
+				stats.Recursions++
+				recursions[loc]++
+			}
+			seen[loc] = true
+		}
+	}
+
+	for i, st := range prof.SampleType {
+		stats.SampleTypes = append(stats.SampleTypes, SampleTypeStats{
+			Type:  st.Type,
+			Unit:  st.Unit,
+			Total: totals[i],
+		})
+	}
+
+	sort.Ints(depths)
+	stats.StackDepth = StackDepthStats{
+		P50: percentile(depths, 50),
+		P90: percentile(depths, 90),
+		P99: percentile(depths, 99),
+		Max: percentile(depths, 100),
+	}
+
+	for k, vals := range labelValues {
+		stats.LabelCardinality[k] = len(vals)
+	}
+
+	stats.RecursiveLocations = len(recursions)
+	for loc, count := range recursions {
+		rl := RecursiveLocation{ID: loc.ID, Count: count}
+		if len(loc.Line) > 0 {
+			line := loc.Line[0]
+			rl.Function = line.Function.Name
+			rl.File = line.Function.Filename
+			rl.Line = line.Line
+		}
+		stats.TopRecursiveLocations = append(stats.TopRecursiveLocations, rl)
+	}
+	sort.Slice(stats.TopRecursiveLocations, func(i, j int) bool {
+		a, b := stats.TopRecursiveLocations[i], stats.TopRecursiveLocations[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.ID < b.ID
+	})
+	if len(stats.TopRecursiveLocations) > topRecursions {
+		stats.TopRecursiveLocations = stats.TopRecursiveLocations[:topRecursions]
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of the sorted values using the
+// nearest-rank method.
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func writeStatsText(stats *ProfileStats, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Samples:\t%d\n", stats.Samples)
+	fmt.Fprintf(w, "Locations:\t%d (%d unsymbolized)\n", stats.Locations, stats.UnsymbolizedLocations)
+	fmt.Fprintf(w, "Functions:\t%d (%d duplicates)\n", stats.Functions, stats.DuplicateFunctions)
+	fmt.Fprintf(w, "Mappings:\t%d\n", stats.Mappings)
+	fmt.Fprintf(w, "Comments:\t%d\n", stats.Comments)
+	fmt.Fprintf(w, "Recursions:\t%d (in %d locations)\n", stats.Recursions, stats.RecursiveLocations)
+	d := stats.StackDepth
+	fmt.Fprintf(w, "Stack Depth:\tp50=%d p90=%d p99=%d max=%d\n", d.P50, d.P90, d.P99, d.Max)
+
+	fmt.Fprintf(w, "\nSample Type\tTotal\n")
+	for _, st := range stats.SampleTypes {
+		fmt.Fprintf(w, "%s/%s\t%d\n", st.Type, st.Unit, st.Total)
+	}
+
+	if len(stats.LabelCardinality) > 0 {
+		var keys []string
+		for k := range stats.LabelCardinality {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "\nLabel\tCardinality\n")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%d\n", k, stats.LabelCardinality[k])
+		}
+	}
+
+	if len(stats.TopRecursiveLocations) > 0 {
+		fmt.Fprintf(w, "\nID\tCount\tFunction\tFile\tLine\n")
+		for _, rl := range stats.TopRecursiveLocations {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\n", rl.ID, rl.Count, rl.Function, rl.File, rl.Line)
+		}
+	}
+	return w.Flush()
+}
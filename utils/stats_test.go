@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	var (
+		main = &profile.Function{ID: 1, Name: "main"}
+		fib  = &profile.Function{ID: 2, Name: "fib"}
+		dup  = &profile.Function{ID: 3, Name: "fib"}
+		l1   = &profile.Location{ID: 1, Line: []profile.Line{{Function: main, Line: 3}}}
+		l2   = &profile.Location{ID: 2, Line: []profile.Line{{Function: fib, Line: 7}}}
+		l3   = &profile.Location{ID: 3}
+		prof = &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+			Sample: []*profile.Sample{
+				{Location: []*profile.Location{l2, l2, l2, l1}, Value: []int64{5}, Label: map[string][]string{"host": {"a"}}},
+				{Location: []*profile.Location{l3, l1}, Value: []int64{3}, Label: map[string][]string{"host": {"b"}}},
+			},
+			Location: []*profile.Location{l1, l2, l3},
+			Function: []*profile.Function{main, fib, dup},
+		}
+		in = &bytes.Buffer{}
+	)
+	require.NoError(t, prof.Write(in))
+
+	out := &bytes.Buffer{}
+	s := &Stats{Input: in.Bytes(), Output: out, Format: "json", Recursions: 10}
+	require.NoError(t, s.Execute(context.Background()))
+
+	var stats ProfileStats
+	require.NoError(t, json.Unmarshal(out.Bytes(), &stats))
+	require.Equal(t, ProfileStats{
+		Samples:               2,
+		Locations:             3,
+		Functions:             3,
+		UnsymbolizedLocations: 1,
+		DuplicateFunctions:    1,
+		SampleTypes:           []SampleTypeStats{{Type: "samples", Unit: "count", Total: 8}},
+		StackDepth:            StackDepthStats{P50: 2, P90: 4, P99: 4, Max: 4},
+		LabelCardinality:      map[string]int{"host": 2},
+		Recursions:            2,
+		RecursiveLocations:    1,
+		TopRecursiveLocations: []RecursiveLocation{{ID: 2, Count: 2, Function: "fib", Line: 7}},
+	}, stats)
+
+	out.Reset()
+	s.Format = "text"
+	require.NoError(t, s.Execute(context.Background()))
+	require.Contains(t, out.String(), "samples/count  8")
+
+	s.Recursions = -1
+	require.EqualError(t, s.Execute(context.Background()), "recursions must be >= 0: -1")
+}
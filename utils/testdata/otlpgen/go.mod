@@ -0,0 +1,8 @@
+module github.com/felixge/pprofutils/v2/utils/testdata/otlpgen
+
+go 1.22.0
+
+require (
+	go.opentelemetry.io/proto/otlp v1.5.0
+	google.golang.org/protobuf v1.36.1
+)
@@ -0,0 +1,93 @@
+// This program generates ../otlp.pb, a ProfilesData message encoded with the
+// official generated types of opentelemetry-proto v1.5.0. It's used as the
+// reference encoding for the OTLP util and lives in its own module to keep
+// the gRPC dependencies of go.opentelemetry.io/proto/otlp out of pprofutils.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	profilespb "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(profilesData())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join("..", "otlp.pb"), data, 0644)
+}
+
+// profilesData returns the OTLP equivalent of the profile built by
+// otlpGoldenProfile in otlp_test.go.
+func profilesData() *profilespb.ProfilesData {
+	profileID := make([]byte, 16)
+	for i := range profileID {
+		profileID[i] = byte(i)
+	}
+	return &profilespb.ProfilesData{ResourceProfiles: []*profilespb.ResourceProfiles{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{strAttr("service.name", "api")}},
+		ScopeProfiles: []*profilespb.ScopeProfiles{{
+			Scope: &commonpb.InstrumentationScope{Name: "pprofutils"},
+			Profiles: []*profilespb.Profile{{
+				SampleType: []*profilespb.ValueType{
+					{TypeStrindex: 1, UnitStrindex: 2},
+					{TypeStrindex: 3, UnitStrindex: 4},
+				},
+				Sample: []*profilespb.Sample{
+					{LocationsStartIndex: 0, LocationsLength: 2, Value: []int64{1, 10}, AttributeIndices: []int32{1, 2}},
+					{LocationsStartIndex: 2, LocationsLength: 1, Value: []int64{2, 20}},
+				},
+				MappingTable: []*profilespb.Mapping{{
+					MemoryStart:      0x1000,
+					MemoryLimit:      0x2000,
+					FilenameStrindex: 6,
+					AttributeIndices: []int32{0},
+					HasFunctions:     true,
+				}},
+				LocationTable: []*profilespb.Location{
+					{MappingIndex: proto.Int32(0), Address: 0x1100, Line: []*profilespb.Line{{FunctionIndex: 0, Line: 3}}},
+					{MappingIndex: proto.Int32(0), Address: 0x1200, Line: []*profilespb.Line{{FunctionIndex: 1, Line: 7}}},
+				},
+				LocationIndices: []int32{1, 0, 0},
+				FunctionTable: []*profilespb.Function{
+					{NameStrindex: 7, FilenameStrindex: 8, StartLine: 1},
+					{NameStrindex: 9, FilenameStrindex: 10},
+				},
+				AttributeTable: []*commonpb.KeyValue{
+					strAttr("process.executable.build_id.gnu", "abc"),
+					strAttr("endpoint", "/foo"),
+					{Key: "size", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 1024}}},
+				},
+				AttributeUnits: []*profilespb.AttributeUnit{{AttributeKeyStrindex: 11, UnitStrindex: 12}},
+				StringTable: []string{
+					"", "samples", "count", "cpu", "nanoseconds", "hello", "/bin/app",
+					"main", "main.go", "foo", "foo.go", "size", "bytes",
+				},
+				TimeNanos:                 1700000000000000000,
+				DurationNanos:             1000000000,
+				PeriodType:                &profilespb.ValueType{TypeStrindex: 3, UnitStrindex: 4},
+				Period:                    10000000,
+				CommentStrindices:         []int32{5},
+				DefaultSampleTypeStrindex: 3,
+				ProfileId:                 profileID,
+			}},
+		}},
+	}}}
+}
+
+func strAttr(key, val string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: val}}}
+}
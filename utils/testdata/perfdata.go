@@ -0,0 +1,151 @@
+//go:build ignore
+
+// This program generates perf.data, a small perf.data file used for testing
+// the PerfData util without requiring the perf binary.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	attrSize    = 128
+	sampleType  = 1<<16 | 1<<0 | 1<<1 | 1<<2 | 1<<8 | 1<<5 // IDENTIFIER|IP|TID|TIME|PERIOD|CALLCHAIN
+	ctxKernel   = ^uint64(128) + 1
+	ctxUser     = ^uint64(512) + 1
+	cyclesID    = 101
+	cpuClockID  = 102
+	headerSize  = 104
+	fileAttrLen = attrSize + 16
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		attrsOff = uint64(headerSize)
+		idsOff   = attrsOff + 2*fileAttrLen
+		dataOff  = idsOff + 16
+		data     = records()
+		featOff  = dataOff + uint64(len(data))
+		desc     = eventDesc()
+		descOff  = featOff + 16
+	)
+
+	out := &bytes.Buffer{}
+	w := func(vals ...interface{}) {
+		for _, v := range vals {
+			binary.Write(out, binary.LittleEndian, v)
+		}
+	}
+
+	// perf_file_header
+	w([]byte("PERFILE2"), uint64(headerSize), uint64(fileAttrLen))
+	w(attrsOff, uint64(2*fileAttrLen))
+	w(dataOff, uint64(len(data)))
+	w(uint64(0), uint64(0))
+	w(uint64(1<<12), uint64(0), uint64(0), uint64(0)) // HEADER_EVENT_DESC
+
+	// perf_file_attr entries
+	out.Write(attr(0, 0))
+	w(idsOff, uint64(8))
+	out.Write(attr(1, 0))
+	w(idsOff+8, uint64(8))
+	w(uint64(cyclesID), uint64(cpuClockID))
+
+	out.Write(data)
+	w(descOff, uint64(len(desc)))
+	out.Write(desc)
+
+	return os.WriteFile("perf.data", out.Bytes(), 0644)
+}
+
+func attr(typ uint32, config uint64) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, typ)
+	binary.Write(buf, binary.LittleEndian, uint32(attrSize))
+	binary.Write(buf, binary.LittleEndian, config)
+	binary.Write(buf, binary.LittleEndian, uint64(4000)) // sample_freq
+	binary.Write(buf, binary.LittleEndian, uint64(sampleType))
+	buf.Write(make([]byte, attrSize-buf.Len()))
+	return buf.Bytes()
+}
+
+func eventDesc() []byte {
+	buf := &bytes.Buffer{}
+	w := func(vals ...interface{}) {
+		for _, v := range vals {
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+	}
+	w(uint32(2), uint32(attrSize))
+	for _, e := range []struct {
+		typ  uint32
+		name string
+		id   uint64
+	}{{0, "cycles:u", cyclesID}, {1, "cpu-clock", cpuClockID}} {
+		buf.Write(attr(e.typ, 0))
+		w(uint32(1))
+		w(perfString(e.name))
+		w(e.id)
+	}
+	return buf.Bytes()
+}
+
+func perfString(s string) []byte {
+	buf := &bytes.Buffer{}
+	str := make([]byte, 64)
+	copy(str, s)
+	binary.Write(buf, binary.LittleEndian, uint32(len(str)))
+	buf.Write(str)
+	return buf.Bytes()
+}
+
+func records() []byte {
+	buf := &bytes.Buffer{}
+	record := func(typ uint32, misc uint16, vals ...interface{}) {
+		body := &bytes.Buffer{}
+		for _, v := range vals {
+			binary.Write(body, binary.LittleEndian, v)
+		}
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+		binary.Write(buf, binary.LittleEndian, typ)
+		binary.Write(buf, binary.LittleEndian, misc)
+		binary.Write(buf, binary.LittleEndian, uint16(8+body.Len()))
+		buf.Write(body.Bytes())
+	}
+	cstr := func(s string) []byte { return append([]byte(s), 0) }
+
+	// PERF_RECORD_COMM
+	record(3, 0, uint32(100), uint32(100), cstr("app"))
+	// PERF_RECORD_MMAP for the kernel
+	record(1, 0, ^uint32(0), uint32(0), uint64(0xffffffff81000000), uint64(0x1000000), uint64(0), cstr("[kernel.kallsyms]_text"))
+	// PERF_RECORD_MMAP2 with a build id
+	buildID := make([]byte, 20)
+	for i := range buildID {
+		buildID[i] = byte(i)
+	}
+	record(10, 1<<14, uint32(100), uint32(100), uint64(0x400000), uint64(0x10000), uint64(0x1000),
+		uint8(20), uint8(0), uint16(0), buildID, uint32(5), uint32(2), cstr("/usr/bin/app"))
+	// PERF_RECORD_FORK and PERF_RECORD_COMM for a child process
+	record(7, 0, uint32(101), uint32(100), uint32(101), uint32(100), uint64(1))
+	record(3, 0, uint32(101), uint32(101), cstr("worker"))
+	// PERF_RECORD_SAMPLE
+	record(9, 0, uint64(cyclesID), uint64(0xffffffff81000100), uint32(100), uint32(100), uint64(2), uint64(1000),
+		uint64(5), ctxKernel, uint64(0xffffffff81000100), ctxUser, uint64(0x401000), uint64(0x402000))
+	record(9, 0, uint64(cpuClockID), uint64(0x401000), uint32(101), uint32(101), uint64(3), uint64(250000),
+		uint64(3), ctxUser, uint64(0x401000), uint64(0x403000))
+	record(9, 0, uint64(cyclesID), uint64(0xffffffff81000100), uint32(100), uint32(100), uint64(4), uint64(3000),
+		uint64(5), ctxKernel, uint64(0xffffffff81000100), ctxUser, uint64(0x401000), uint64(0x402000))
+	return buf.Bytes()
+}
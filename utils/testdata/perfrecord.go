@@ -0,0 +1,236 @@
+//go:build ignore
+
+// This program records perf-record.data, a perf.data file with samples,
+// mappings and comms produced by the kernel, like perf record -g -e cpu-clock
+// would. It compiles a small multi-threaded C program with gcc and records it
+// via perf_event_open(2), so it works without the perf binary. It needs to run
+// as root on linux/amd64.
+//
+// Like perf record, it synthesizes the mapping of the kernel from
+// /proc/kallsyms. All other records are copied from the kernel's ring buffer
+// as is.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// appSource is the program that is being recorded. It calls work from main
+// and from a second thread.
+const appSource = `
+#include <pthread.h>
+
+__attribute__((noinline)) static long leaf(long n) {
+	volatile long x = 0;
+	for (long i = 0; i < n; i++) x += i;
+	return x;
+}
+
+__attribute__((noinline)) static long work(long n) { return leaf(n); }
+
+static void *worker(void *arg) { work(40000000); return 0; }
+
+int main(void) {
+	pthread_t t;
+	pthread_create(&t, 0, worker, 0);
+	work(40000000);
+	pthread_join(t, 0);
+	return 0;
+}
+`
+
+const (
+	appPath = "/tmp/app"
+
+	sysPerfEventOpen = 298
+	perfEventIOCID   = 0x80082407
+
+	attrSize   = 128
+	sampleType = 1<<16 | 1<<0 | 1<<1 | 1<<2 | 1<<8 | 1<<5 // IDENTIFIER|IP|TID|TIME|PERIOD|CALLCHAIN
+	// disabled|inherit|mmap|comm|enable_on_exec|task|sample_id_all|mmap2|build_id
+	attrFlags = 1<<0 | 1<<1 | 1<<8 | 1<<9 | 1<<12 | 1<<13 | 1<<18 | 1<<23 | 1<<34
+
+	pageSize   = 4096
+	dataPages  = 64
+	headerSize = 104
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	src := filepath.Join(os.TempDir(), "app.c")
+	if err := os.WriteFile(src, []byte(appSource), 0644); err != nil {
+		return err
+	}
+	gcc := exec.Command("gcc", "-O1", "-fno-omit-frame-pointer", "-pthread", "-o", appPath, src)
+	if out, err := gcc.CombinedOutput(); err != nil {
+		return fmt.Errorf("gcc: %w: %s", err, out)
+	}
+
+	// The child stops after exec'ing the shell, which gives us the chance to
+	// attach to it before it exec's the app and enables the event.
+	runtime.LockOSThread()
+	cmd := exec.Command("/bin/sh", "-c", "exec "+appPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+		return fmt.Errorf("wait: %w", err)
+	}
+
+	attr := make([]byte, attrSize)
+	binary.LittleEndian.PutUint32(attr[0:], 1) // PERF_TYPE_SOFTWARE
+	binary.LittleEndian.PutUint32(attr[4:], attrSize)
+	binary.LittleEndian.PutUint64(attr[8:], 0)        // PERF_COUNT_SW_CPU_CLOCK
+	binary.LittleEndian.PutUint64(attr[16:], 1000000) // sample every 1ms
+	binary.LittleEndian.PutUint64(attr[24:], sampleType)
+	binary.LittleEndian.PutUint64(attr[40:], attrFlags)
+	// Like perf record, one event is opened per cpu since the kernel doesn't
+	// allow to mmap the ring buffer of inherited events for all cpus.
+	var (
+		ids   []uint64
+		rings [][]byte
+	)
+	for cpu := 0; cpu < runtime.NumCPU(); cpu++ {
+		fd, _, errno := syscall.Syscall6(sysPerfEventOpen, uintptr(unsafe.Pointer(&attr[0])), uintptr(pid), uintptr(cpu), ^uintptr(0), 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("perf_event_open: %w", errno)
+		}
+		var id uint64
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, perfEventIOCID, uintptr(unsafe.Pointer(&id))); errno != 0 {
+			return fmt.Errorf("ioctl: %w", errno)
+		}
+		ring, err := syscall.Mmap(int(fd), 0, (1+dataPages)*pageSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			return fmt.Errorf("mmap: %w", err)
+		}
+		ids = append(ids, id)
+		rings = append(rings, ring)
+	}
+
+	if err := syscall.PtraceDetach(pid); err != nil {
+		return fmt.Errorf("ptrace: %w", err)
+	}
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+
+	records := kernelMmap()
+	for {
+		for _, ring := range rings {
+			records = append(records, drain(ring)...)
+		}
+		select {
+		case err := <-done:
+			for _, ring := range rings {
+				records = append(records, drain(ring)...)
+			}
+			if err != nil {
+				return err
+			}
+			return os.WriteFile("perf-record.data", perfData(attr, ids, records), 0644)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// drain returns the records in the ring buffer and marks them as consumed.
+func drain(ring []byte) []byte {
+	var (
+		headPtr = (*uint64)(unsafe.Pointer(&ring[1024]))
+		tailPtr = (*uint64)(unsafe.Pointer(&ring[1032]))
+		data    = ring[pageSize:]
+		head    = *headPtr
+		tail    = *tailPtr
+		out     []byte
+	)
+	for ; tail < head; tail++ {
+		out = append(out, data[tail%uint64(len(data))])
+	}
+	*tailPtr = tail
+	return out
+}
+
+// kernelMmap returns a PERF_RECORD_MMAP for the kernel the way perf record
+// synthesizes it.
+func kernelMmap() []byte {
+	kallsyms, err := os.ReadFile("/proc/kallsyms")
+	if err != nil {
+		return nil
+	}
+	var start uint64
+	for _, line := range strings.Split(string(kallsyms), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[2] == "_text" {
+			start, _ = strconv.ParseUint(fields[0], 16, 64)
+		}
+	}
+	if start == 0 {
+		return nil
+	}
+	name := []byte("[kernel.kallsyms]_text\x00")
+	name = append(name, make([]byte, (8-len(name)%8)%8)...)
+	// sample_id_all appends the sample id: identifier, tid and time.
+	sampleID := make([]byte, 24)
+	binary.LittleEndian.PutUint32(sampleID[8:], ^uint32(0))
+	binary.LittleEndian.PutUint32(sampleID[12:], ^uint32(0))
+
+	rec := make([]byte, 8+32)
+	binary.LittleEndian.PutUint32(rec[0:], 1) // PERF_RECORD_MMAP
+	binary.LittleEndian.PutUint16(rec[4:], 1) // PERF_RECORD_MISC_KERNEL
+	binary.LittleEndian.PutUint32(rec[8:], ^uint32(0))
+	binary.LittleEndian.PutUint32(rec[12:], 0)
+	binary.LittleEndian.PutUint64(rec[16:], start)
+	binary.LittleEndian.PutUint64(rec[24:], ^uint64(0)-start)
+	binary.LittleEndian.PutUint64(rec[32:], start)
+	rec = append(rec, name...)
+	rec = append(rec, sampleID...)
+	binary.LittleEndian.PutUint16(rec[6:], uint16(len(rec)))
+	return rec
+}
+
+// perfData returns a perf.data file with the given attr, its ids and records.
+func perfData(attr []byte, ids []uint64, records []byte) []byte {
+	var (
+		attrsOff = uint64(headerSize)
+		idsOff   = attrsOff + attrSize + 16
+		dataOff  = idsOff + 8*uint64(len(ids))
+		buf      bytes.Buffer
+		le       = binary.LittleEndian
+	)
+	header := make([]byte, headerSize)
+	le.PutUint64(header[0:], 0x32454c4946524550) // PERFILE2
+	le.PutUint64(header[8:], headerSize)
+	le.PutUint64(header[16:], attrSize+16)
+	le.PutUint64(header[24:], attrsOff)
+	le.PutUint64(header[32:], attrSize+16)
+	le.PutUint64(header[40:], dataOff)
+	le.PutUint64(header[48:], uint64(len(records)))
+	buf.Write(header)
+
+	// The disabled and enable_on_exec flags only matter while recording.
+	attr = append([]byte(nil), attr...)
+	le.PutUint64(attr[40:], le.Uint64(attr[40:])&^(1<<0|1<<12))
+	buf.Write(attr)
+	binary.Write(&buf, le, [2]uint64{idsOff, 8 * uint64(len(ids))})
+	binary.Write(&buf, le, ids)
+	buf.Write(records)
+	return buf.Bytes()
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/google/pprof/profile"
+)
+
+// Top reports the entries of a profile with the highest flat values like
+// "go tool pprof -top".
+type Top struct {
+	Input  []byte
+	Output io.Writer
+	// N limits the number of reported entries. 0 means no limit.
+	N int
+	// SampleType is the type/unit of the sample type to report. Defaults to
+	// the default sample type of the profile.
+	SampleType string
+	// By is the granularity of the entries, either "function", "file" or
+	// "package".
+	By string
+	// Format is either "text", "csv" or "json".
+	Format string
+}
+
+// TopReport holds the entries computed by Top.
+type TopReport struct {
+	SampleType string     `json:"sample_type"`
+	Total      int64      `json:"total"`
+	Entries    int        `json:"entries"`
+	Top        []TopEntry `json:"top"`
+}
+
+// TopEntry holds the flat and cumulative value of a function, file or package.
+type TopEntry struct {
+	Name    string  `json:"name"`
+	Flat    int64   `json:"flat"`
+	FlatPct float64 `json:"flat_pct"`
+	SumPct  float64 `json:"sum_pct"`
+	Cum     int64   `json:"cum"`
+	CumPct  float64 `json:"cum_pct"`
+}
+
+func (t *Top) Execute(ctx context.Context) error {
+	prof, err := profile.ParseData(t.Input)
+	if err != nil {
+		return err
+	}
+	idx, err := selectSampleType(prof, t.SampleType)
+	if err != nil {
+		return err
+	}
+	by := t.By
+	switch by {
+	case "":
+		by = "function"
+	case "function", "file", "package":
+	default:
+		return fmt.Errorf("unknown by: %q: must be function, file or package", by)
+	}
+	if t.N < 0 {
+		return fmt.Errorf("n must be >= 0: %d", t.N)
+	}
+
+	st := prof.SampleType[idx]
+	report := &TopReport{SampleType: st.Type + "/" + st.Unit}
+	value := func(s *profile.Sample) int64 { return s.Value[idx] }
+	for _, s := range prof.Sample {
+		report.Total += value(s)
+	}
+	entries := topEntries(prof, value, by)
+	report.Entries = len(entries)
+	var sum int64
+	for _, e := range entries {
+		if t.N > 0 && len(report.Top) >= t.N {
+			break
+		}
+		sum += e.Flat
+		report.Top = append(report.Top, TopEntry{
+			Name:    e.Name,
+			Flat:    e.Flat,
+			FlatPct: percent(e.Flat, report.Total),
+			SumPct:  percent(sum, report.Total),
+			Cum:     e.Cum,
+			CumPct:  percent(e.Cum, report.Total),
+		})
+	}
+
+	switch t.Format {
+	case "json":
+		enc := json.NewEncoder(t.Output)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		return writeTopCSV(report, t.Output)
+	case "text", "":
+		return writeTopText(report, st.Unit, t.Output)
+	default:
+		return fmt.Errorf("unknown format: %q", t.Format)
+	}
+}
+
+func writeTopText(report *TopReport, unit string, out io.Writer) error {
+	var shown int64
+	for _, e := range report.Top {
+		shown += e.Flat
+	}
+	fmt.Fprintf(out, "Type: %s\n", report.SampleType)
+	fmt.Fprintf(out, "Showing %d of %d entries accounting for %s, %.2f%% of %s total\n",
+		len(report.Top), report.Entries,
+		formatValue(shown, unit), percent(shown, report.Total), formatValue(report.Total, unit))
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "flat\tflat%%\tsum%%\tcum\tcum%%\t\n")
+	for _, e := range report.Top {
+		fmt.Fprintf(w, "%s\t%.2f%%\t%.2f%%\t%s\t%.2f%%\t %s\n",
+			formatValue(e.Flat, unit), e.FlatPct, e.SumPct,
+			formatValue(e.Cum, unit), e.CumPct, e.Name)
+	}
+	return w.Flush()
+}
+
+func writeTopCSV(report *TopReport, out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"name", "flat", "flat_pct", "sum_pct", "cum", "cum_pct"})
+	pct := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for _, e := range report.Top {
+		w.Write([]string{e.Name, strconv.FormatInt(e.Flat, 10), pct(e.FlatPct), pct(e.SumPct), strconv.FormatInt(e.Cum, 10), pct(e.CumPct)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// topEntry holds the flat and cumulative value of a function, file or
+// package.
+type topEntry struct {
+	Name string
+	Flat int64
+	Cum  int64
+}
+
+// topEntries returns the flat and cumulative values of all functions, files or
+// packages in the profile ordered by flat value. Inlined functions are
+// included and recursive entries are only counted once per sample.
+func topEntries(prof *profile.Profile, value func(s *profile.Sample) int64, by string) []*topEntry {
+	entries := map[string]*topEntry{}
+	for _, s := range prof.Sample {
+		v := value(s)
+		if v == 0 {
+			continue
+		}
+		names := stackKeys(s, by)
+		seen := map[string]bool{}
+		for i, name := range names {
+			e := entries[name]
+			if e == nil {
+				e = &topEntry{Name: name}
+				entries[name] = e
+			}
+			if i == len(names)-1 {
+				e.Flat += v
+			}
+			if !seen[name] {
+				seen[name] = true
+				e.Cum += v
+			}
+		}
+	}
+	var result []*topEntry
+	for _, e := range entries {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		} else if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Name < b.Name
+	})
+	return result
+}
+
+// stackKeys returns the function names, file names or packages of the stack
+// of s ordered from root to leaf. Inlined functions are included. Functions
+// without a package are reported as their own package. Unsymbolized locations
+// are reported by address, or by mapping file when grouping by file.
+func stackKeys(s *profile.Sample, by string) []string {
+	var keys []string
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		if len(loc.Line) == 0 {
+			if by == "file" && loc.Mapping != nil && loc.Mapping.File != "" {
+				keys = append(keys, loc.Mapping.File)
+			} else if by == "file" {
+				keys = append(keys, "<unknown>")
+			} else {
+				keys = append(keys, addressName(loc))
+			}
+		}
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			fn := loc.Line[j].Function
+			switch by {
+			case "file":
+				if fn.Filename != "" {
+					keys = append(keys, fn.Filename)
+				} else {
+					keys = append(keys, "<unknown>")
+				}
+			case "package":
+				if pkg := packageName(fn.Name); pkg != "" {
+					keys = append(keys, pkg)
+				} else {
+					keys = append(keys, fn.Name)
+				}
+			default:
+				keys = append(keys, fn.Name)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTop(t *testing.T) {
+	in := foldedProfile(t, `samples/count cpu/nanoseconds @extended
+main.main@main.go:1;encoding/json.Marshal@encode.go:2 3 3000
+main.main@main.go:1;main.work@main.go:5;main.work@main.go:6 2 2000
+main.main@main.go:1 5 5000
+`)
+
+	t.Run("text", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Top{Input: in, Output: out, N: 2}).Execute(context.Background()))
+		require.Equal(t, `Type: cpu/nanoseconds
+Showing 2 of 3 entries accounting for 8.00µs, 80.00% of 10.00µs total
+   flat  flat%   sum%     cum    cum%
+ 5.00µs 50.00% 50.00% 10.00µs 100.00% main.main
+ 3.00µs 30.00% 80.00%  3.00µs  30.00% encoding/json.Marshal
+`, out.String())
+	})
+
+	t.Run("csv by package", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Top{Input: in, Output: out, By: "package", Format: "csv", SampleType: "samples/count"}).Execute(context.Background()))
+		require.Equal(t, `name,flat,flat_pct,sum_pct,cum,cum_pct
+main,7,70.00,70.00,10,100.00
+encoding/json,3,30.00,100.00,3,30.00
+`, out.String())
+	})
+
+	t.Run("json by file", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Top{Input: in, Output: out, By: "file", Format: "json"}).Execute(context.Background()))
+		var report TopReport
+		require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+		require.Equal(t, TopReport{
+			SampleType: "cpu/nanoseconds",
+			Total:      10000,
+			Entries:    2,
+			Top: []TopEntry{
+				{Name: "main.go", Flat: 7000, FlatPct: 70, SumPct: 70, Cum: 10000, CumPct: 100},
+				{Name: "encode.go", Flat: 3000, FlatPct: 30, SumPct: 100, Cum: 3000, CumPct: 30},
+			},
+		}, report)
+	})
+
+	t.Run("unsymbolized", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Top{Input: addressProfile(t), Output: out}).Execute(context.Background()))
+		require.Equal(t, `Type: samples/count
+Showing 3 of 3 entries accounting for 4, 100.00% of 4 total
+ flat  flat%    sum% cum    cum%
+    3 75.00%  75.00%   3  75.00% 0x401100 [app]
+    1 25.00% 100.00%   1  25.00% 0xffffffff81000000
+    0  0.00% 100.00%   4 100.00% 0x401000 [app]
+`, out.String())
+
+		out.Reset()
+		require.NoError(t, (&Top{Input: addressProfile(t), Output: out, By: "file", Format: "csv"}).Execute(context.Background()))
+		require.Equal(t, `name,flat,flat_pct,sum_pct,cum,cum_pct
+/usr/bin/app,3,75.00,75.00,4,100.00
+<unknown>,1,25.00,100.00,1,25.00
+`, out.String())
+	})
+
+	t.Run("by is not modified", func(t *testing.T) {
+		top := &Top{Input: in, Output: &bytes.Buffer{}}
+		require.NoError(t, top.Execute(context.Background()))
+		require.Equal(t, "", top.By)
+	})
+
+	t.Run("bad by", func(t *testing.T) {
+		err := (&Top{Input: in, Output: &bytes.Buffer{}, By: "line"}).Execute(context.Background())
+		require.EqualError(t, err, `unknown by: "line": must be function, file or package`)
+	})
+}